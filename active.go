@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// activeMachineEnv names the active machine of a shell. It is set by
+	// env, so the machine a shell was configured for stays active in it.
+	activeMachineEnv = "DOCKER_MACHINE_NAME"
+
+	// activeMachineFile names the active machine of a directory and the
+	// directories below it
+	activeMachineFile = ".machine"
+
+	// activeSourceGlobal is the source of a machine made active with
+	// active, which is shared by all shells
+	activeSourceGlobal = "global"
+)
+
+// findActiveMachineFile returns the path of the nearest .machine file in dir
+// or one of its parents, or "" if there is none
+func findActiveMachineFile(dir string) string {
+	for {
+		path := filepath.Join(dir, activeMachineFile)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func readActiveMachineFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(string(data))
+	if name == "" {
+		return "", fmt.Errorf("%s does not name a machine", path)
+	}
+	return name, nil
+}
+
+// getActiveHost returns the active host and where it was made active. The
+// active host is taken from the first of
+//
+//	the DOCKER_MACHINE_NAME environment variable
+//	a .machine file in the working directory or one of its parents
+//	the active host of the store
+//
+// It returns a nil host if there is no active host.
+func getActiveHost(store Store) (*Host, string, error) {
+	if name := os.Getenv(activeMachineEnv); name != "" {
+		host, err := store.Load(name)
+		if err != nil {
+			return nil, "", fmt.Errorf("error loading machine %s named by %s: %s", name, activeMachineEnv, err)
+		}
+		return host, activeMachineEnv, nil
+	}
+
+	if wd, err := os.Getwd(); err == nil {
+		if path := findActiveMachineFile(wd); path != "" {
+			name, err := readActiveMachineFile(path)
+			if err != nil {
+				return nil, "", err
+			}
+			host, err := store.Load(name)
+			if err != nil {
+				return nil, "", fmt.Errorf("error loading machine %s named by %s: %s", name, path, err)
+			}
+			return host, path, nil
+		}
+	}
+
+	host, err := store.GetActive()
+	if err != nil || host == nil {
+		return nil, "", err
+	}
+	return host, activeSourceGlobal, nil
+}
+
+// setLocalActive makes the host the active host of the directory
+func setLocalActive(dir string, host *Host) error {
+	return ioutil.WriteFile(filepath.Join(dir, activeMachineFile), []byte(host.Name+"\n"), 0644)
+}
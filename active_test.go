@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindActiveMachineFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "machine-active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sub := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(sub, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setLocalActive(dir, &Host{Name: "dev"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := findActiveMachineFile(sub)
+	if path != filepath.Join(dir, activeMachineFile) {
+		t.Fatalf("expected %s, got %s", filepath.Join(dir, activeMachineFile), path)
+	}
+
+	name, err := readActiveMachineFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "dev" {
+		t.Fatalf("expected dev, got %q", name)
+	}
+}
+
+func TestGetActiveHost(t *testing.T) {
+	if err := clearHosts(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := getTestStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store.Path)
+
+	var global *Host
+	for _, name := range []string{"global", "local", "shell"} {
+		flags := getDefaultTestDriverFlags()
+		flags.Data["name"] = name
+		host, err := store.Create(name, "none", flags, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "global" {
+			global = host
+		}
+	}
+
+	if err := store.SetActive(global); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Setenv(activeMachineEnv, os.Getenv(activeMachineEnv))
+	os.Setenv(activeMachineEnv, "")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	dir, err := ioutil.TempDir("", "machine-active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	expectActive := func(name string, source string) {
+		host, s, err := getActiveHost(store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host == nil || host.Name != name || s != source {
+			t.Fatalf("expected %s set by %s, got %v set by %s", name, source, host, s)
+		}
+	}
+
+	expectActive("global", activeSourceGlobal)
+
+	if err := ioutil.WriteFile(activeMachineFile, []byte("local\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := findActiveMachineFile(dir)
+	expectActive("local", path)
+
+	os.Setenv(activeMachineEnv, "shell")
+	expectActive("shell", activeMachineEnv)
+
+	os.Setenv(activeMachineEnv, "missing")
+	if _, _, err := getActiveHost(store); err == nil {
+		t.Fatal("expected an error for a missing machine")
+	}
+}
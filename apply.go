@@ -0,0 +1,482 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/docker/machine/drivers"
+	"gopkg.in/yaml.v2"
+)
+
+// machineDefinitions is the format of the files read by apply. JSON files
+// work as well, as YAML is a superset of JSON.
+//
+//	machines:
+//	  - name: web-1
+//	    driver: amazonec2
+//	    options:
+//	      amazonec2-instance-type: t2.small
+//	    swarm:
+//	      discovery: token://1234
+//	    labels:
+//	      role: web
+type machineDefinitions struct {
+	Machines []*machineDefinition `yaml:"machines"`
+}
+
+// machineDefinition describes a machine. Options are create flags, given
+// with or without the prefix of the driver.
+type machineDefinition struct {
+	Name    string                 `yaml:"name"`
+	Driver  string                 `yaml:"driver"`
+	Options map[string]interface{} `yaml:"options"`
+	Swarm   *swarmDefinition       `yaml:"swarm"`
+	Labels  map[string]string      `yaml:"labels"`
+}
+
+type swarmDefinition struct {
+	Master    bool   `yaml:"master"`
+	Discovery string `yaml:"discovery"`
+	Host      string `yaml:"host"`
+	Addr      string `yaml:"addr"`
+}
+
+// definitionFlags are create flags which are set with the fields of a
+// definition rather than its options
+var definitionFlags = map[string]string{
+	"driver":          "driver",
+	"from":            "",
+	"label":           "labels",
+	"swarm":           "swarm",
+	"swarm-master":    "swarm",
+	"swarm-discovery": "swarm",
+	"swarm-host":      "swarm",
+	"swarm-addr":      "swarm",
+}
+
+// loadDefinitions reads and validates a definition file. Option names are
+// checked against the flags of the create command and normalized to them.
+func loadDefinitions(path string, createFlags []string) ([]*machineDefinition, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var definitions machineDefinitions
+	if err := yaml.UnmarshalStrict(data, &definitions); err != nil {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+
+	flags := map[string]bool{}
+	for _, name := range createFlags {
+		flags[name] = true
+	}
+	driverNames := map[string]bool{}
+	for _, name := range drivers.GetDriverNames() {
+		driverNames[name] = true
+	}
+
+	seen := map[string]bool{}
+	for _, definition := range definitions.Machines {
+		if _, err := ValidateHostName(definition.Name); err != nil {
+			return nil, err
+		}
+		if seen[definition.Name] {
+			return nil, fmt.Errorf("machine %s is defined more than once", definition.Name)
+		}
+		seen[definition.Name] = true
+
+		if !driverNames[definition.Driver] {
+			return nil, fmt.Errorf("machine %s: unknown driver %q", definition.Name, definition.Driver)
+		}
+		if definition.Swarm != nil && definition.Swarm.Discovery == "" {
+			return nil, fmt.Errorf("machine %s: swarm requires a discovery URL", definition.Name)
+		}
+
+		options := map[string]interface{}{}
+		for key, value := range definition.Options {
+			name := key
+			if !flags[name] {
+				name = definition.Driver + "-" + key
+			}
+			if !flags[name] {
+				return nil, fmt.Errorf("machine %s: unknown option %q", definition.Name, key)
+			}
+			if field, ok := definitionFlags[name]; ok {
+				if field == "" {
+					return nil, fmt.Errorf("machine %s: option %q is not supported", definition.Name, key)
+				}
+				return nil, fmt.Errorf("machine %s: set %q with the %s field", definition.Name, key, field)
+			}
+			options[name] = value
+		}
+		definition.Options = options
+	}
+
+	return definitions.Machines, nil
+}
+
+// labelOptions returns the labels of the definition as --label values
+func (d *machineDefinition) labelOptions() []string {
+	labels := []string{}
+	for key, value := range d.Labels {
+		labels = append(labels, key+"="+value)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// optionValues returns an option of a definition as flag values
+func optionValues(value interface{}) []string {
+	switch value := value.(type) {
+	case nil:
+		return []string{}
+	case []interface{}:
+		values := []string{}
+		for _, v := range value {
+			values = append(values, fmt.Sprint(v))
+		}
+		return values
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// definitionOptions are the driver options of a machine created from a
+// definition. Options which are not given default to the defaults of the
+// create flags.
+type definitionOptions struct {
+	definition *machineDefinition
+	defaults   drivers.DriverOptions
+}
+
+func (o *definitionOptions) values(name string) ([]string, bool) {
+	d := o.definition
+	switch name {
+	case "swarm":
+		return []string{strconv.FormatBool(d.Swarm != nil)}, true
+	case "swarm-master":
+		return []string{strconv.FormatBool(d.Swarm != nil && d.Swarm.Master)}, true
+	case "swarm-discovery", "swarm-host", "swarm-addr":
+		if d.Swarm == nil {
+			return nil, false
+		}
+		value := map[string]string{
+			"swarm-discovery": d.Swarm.Discovery,
+			"swarm-host":      d.Swarm.Host,
+			"swarm-addr":      d.Swarm.Addr,
+		}[name]
+		if value == "" {
+			return nil, false
+		}
+		return []string{value}, true
+	case "label":
+		return d.labelOptions(), true
+	}
+
+	value, ok := d.Options[name]
+	if !ok {
+		return nil, false
+	}
+	return optionValues(value), true
+}
+
+func (o *definitionOptions) String(name string) string {
+	if values, ok := o.values(name); ok && len(values) > 0 {
+		return values[0]
+	}
+	return o.defaults.String(name)
+}
+
+func (o *definitionOptions) StringSlice(name string) []string {
+	if values, ok := o.values(name); ok {
+		return values
+	}
+	return o.defaults.StringSlice(name)
+}
+
+func (o *definitionOptions) Int(name string) int {
+	if values, ok := o.values(name); ok && len(values) > 0 {
+		value, err := strconv.Atoi(values[0])
+		if err == nil {
+			return value
+		}
+		log.Warnf("machine %s: invalid number %q for option %s", o.definition.Name, values[0], name)
+	}
+	return o.defaults.Int(name)
+}
+
+func (o *definitionOptions) Bool(name string) bool {
+	if values, ok := o.values(name); ok && len(values) > 0 {
+		value, err := strconv.ParseBool(values[0])
+		if err == nil {
+			return value
+		}
+		log.Warnf("machine %s: invalid boolean %q for option %s", o.definition.Name, values[0], name)
+	}
+	return o.defaults.Bool(name)
+}
+
+// FlagNames, IsSet and Generic make the options of the definition recorded
+// as create flags, so that drift can compare them later
+func (o *definitionOptions) FlagNames() []string {
+	names := []string{}
+	seen := map[string]bool{}
+	if lister, ok := o.defaults.(flagLister); ok {
+		for _, name := range lister.FlagNames() {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	for name := range o.definition.Options {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// IsSet reports whether the flag is set by the definition
+func (o *definitionOptions) IsSet(name string) bool {
+	_, ok := o.values(name)
+	return ok
+}
+
+func (o *definitionOptions) Generic(name string) interface{} {
+	if values, ok := o.values(name); ok {
+		slice := cli.StringSlice(values)
+		return &slice
+	}
+	if lister, ok := o.defaults.(flagLister); ok {
+		return lister.Generic(name)
+	}
+	return nil
+}
+
+// drift returns how an existing machine differs from its definition
+func (d *machineDefinition) drift(host *Host) []string {
+	drift := []string{}
+
+	if host.DriverName != d.Driver {
+		drift = append(drift, fmt.Sprintf("driver is %s, not %s", host.DriverName, d.Driver))
+	}
+
+	labels := d.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	hostLabels := host.Labels
+	if hostLabels == nil {
+		hostLabels = map[string]string{}
+	}
+	if !reflect.DeepEqual(labels, hostLabels) {
+		drift = append(drift, fmt.Sprintf("labels are %q, not %q",
+			(&machineDefinition{Labels: hostLabels}).labelOptions(), d.labelOptions()))
+	}
+
+	switch {
+	case d.Swarm == nil && host.Swarm:
+		drift = append(drift, "swarm is enabled")
+	case d.Swarm != nil && !host.Swarm:
+		drift = append(drift, "swarm is disabled")
+	case d.Swarm != nil:
+		if host.SwarmMaster != d.Swarm.Master {
+			drift = append(drift, fmt.Sprintf("swarm master is %t, not %t", host.SwarmMaster, d.Swarm.Master))
+		}
+		if host.SwarmDiscovery != d.Swarm.Discovery {
+			drift = append(drift, fmt.Sprintf("swarm discovery is %s, not %s", host.SwarmDiscovery, d.Swarm.Discovery))
+		}
+	}
+
+	// options can only be compared to the recorded create options
+	names := []string{}
+	for name := range d.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		recorded, ok := host.CreateFlags[name]
+		if !ok {
+			continue
+		}
+		expected := optionValues(d.Options[name])
+		if len(recorded) == 1 && recorded[0] == redactedSecret {
+			continue
+		}
+		if !reflect.DeepEqual(recorded, expected) {
+			drift = append(drift, fmt.Sprintf("option %s is %q, not %q",
+				name, strings.Join(recorded, ","), strings.Join(expected, ",")))
+		}
+	}
+
+	return drift
+}
+
+// applyPlan is what apply changes to make the machines match the
+// definitions
+type applyPlan struct {
+	Create []*machineDefinition
+	Drift  map[string][]string
+	Remove []string
+}
+
+func planApply(definitions []*machineDefinition, hosts []Host, prune bool) applyPlan {
+	plan := applyPlan{Drift: map[string][]string{}}
+
+	existing := map[string]*Host{}
+	for i := range hosts {
+		existing[hosts[i].Name] = &hosts[i]
+	}
+
+	defined := map[string]bool{}
+	for _, definition := range definitions {
+		defined[definition.Name] = true
+
+		host, ok := existing[definition.Name]
+		if !ok {
+			plan.Create = append(plan.Create, definition)
+			continue
+		}
+		if drift := definition.drift(host); len(drift) > 0 {
+			plan.Drift[definition.Name] = drift
+		}
+	}
+
+	if prune {
+		for i := range hosts {
+			if !defined[hosts[i].Name] {
+				plan.Remove = append(plan.Remove, hosts[i].Name)
+			}
+		}
+	}
+
+	return plan
+}
+
+func (p applyPlan) empty() bool {
+	return len(p.Create) == 0 && len(p.Drift) == 0 && len(p.Remove) == 0
+}
+
+// print writes the plan as a table
+func (p applyPlan) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 5, 1, 3, ' ', 0)
+	fmt.Fprintln(tw, "MACHINE\tACTION\tDETAILS")
+	for _, definition := range p.Create {
+		fmt.Fprintf(tw, "%s\tcreate\t%s\n", definition.Name, definition.Driver)
+	}
+
+	names := []string{}
+	for name := range p.Drift {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, drift := range p.Drift[name] {
+			fmt.Fprintf(tw, "%s\tdrift\t%s\n", name, drift)
+		}
+	}
+
+	for _, name := range p.Remove {
+		fmt.Fprintf(tw, "%s\tremove\t\n", name)
+	}
+	tw.Flush()
+}
+
+func cmdApply(c *cli.Context) {
+	path := c.String("file")
+	if path == "" {
+		cli.ShowCommandHelp(c, "apply")
+		log.Fatal("You must specify a definition file with -f")
+	}
+
+	parallel := c.Int("parallel")
+	if parallel < 0 {
+		log.Fatal("--parallel must not be negative")
+	}
+
+	createCommand := c.App.Command("create")
+	if createCommand == nil {
+		log.Fatal("create command not found")
+	}
+	defaults := defaultOptions(c.App, *createCommand)
+
+	definitions, err := loadDefinitions(path, defaults.FlagNames())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store := getStore(c)
+	hosts, err := store.List()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	plan := planApply(definitions, hosts, c.Bool("prune"))
+	if plan.empty() {
+		log.Info("All machines match the definitions")
+		return
+	}
+	plan.print(os.Stdout)
+
+	if c.Bool("dry-run") {
+		return
+	}
+
+	if len(plan.Remove) > 0 && !confirmBulk(c, "remove", plan.Remove) {
+		log.Fatal(errAborted)
+	}
+
+	if len(plan.Create) > 0 {
+		if err := setupCertificates(c.GlobalString("tls-ca-cert"), c.GlobalString("tls-ca-key"),
+			c.GlobalString("tls-client-cert"), c.GlobalString("tls-client-key")); err != nil {
+			log.Fatalf("Error generating certificates: %s", err)
+		}
+	}
+
+	// the machines to create do not exist yet, so the executor gets
+	// placeholders to apply the limits of their drivers
+	definitionsByName := map[string]*machineDefinition{}
+	machines := []*Host{}
+	for _, definition := range plan.Create {
+		definitionsByName[definition.Name] = definition
+		machines = append(machines, &Host{Name: definition.Name, DriverName: definition.Driver})
+	}
+
+	// each create times out on its own, a Ctrl-C cancels all creates, which
+	// are rolled back
+	newWaiter, stopWaits := limitWaits(c,
+		"Canceling the creates and cleaning up",
+		"Interrupted; run rm on the machines being created to remove what is left of them")
+	runner := newExecutor(parallel)
+	results := runner.run("create", machines, func(machine *Host) error {
+		definition := definitionsByName[machine.Name]
+		_, err := store.Create(definition.Name, definition.Driver, &definitionOptions{definition, defaults}, newWaiter())
+		return err
+	})
+	stopWaits()
+
+	removals := []*Host{}
+	for _, name := range plan.Remove {
+		removals = append(removals, &Host{Name: name})
+	}
+	results = append(results, runner.run("rm", removals, func(machine *Host) error {
+		return store.Remove(machine.Name, false)
+	})...)
+
+	if len(results) > 0 {
+		fmt.Println()
+		printResults(os.Stdout, results)
+	}
+
+	if failed := failedResults(results); failed > 0 {
+		log.Fatalf("%d of %d changes failed", failed, len(results))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+var testCreateFlags = []string{"driver", "label", "swarm", "swarm-master", "swarm-discovery", "virtualbox-memory", "virtualbox-disk-size"}
+
+func writeTestDefinitions(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadDefinitions(t *testing.T) {
+	path := writeTestDefinitions(t, `
+machines:
+  - name: web-1
+    driver: virtualbox
+    options:
+      memory: 2048
+      virtualbox-disk-size: 40000
+    swarm:
+      master: true
+      discovery: token://1234
+    labels:
+      role: web
+  - name: db-1
+    driver: none
+`)
+	defer os.Remove(path)
+
+	definitions, err := loadDefinitions(path, testCreateFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(definitions) != 2 {
+		t.Fatalf("expected 2 definitions; received %d", len(definitions))
+	}
+
+	options := &definitionOptions{definitions[0], &DriverOptionsMock{Data: map[string]interface{}{}}}
+	if memory := options.Int("virtualbox-memory"); memory != 2048 {
+		t.Fatalf("expected memory 2048; received %d", memory)
+	}
+	if diskSize := options.Int("virtualbox-disk-size"); diskSize != 40000 {
+		t.Fatalf("expected disk size 40000; received %d", diskSize)
+	}
+	if !options.Bool("swarm") || !options.Bool("swarm-master") || options.String("swarm-discovery") != "token://1234" {
+		t.Fatal("expected the swarm options of the definition")
+	}
+	if labels := options.StringSlice("label"); len(labels) != 1 || labels[0] != "role=web" {
+		t.Fatalf("expected the label role=web; received %v", labels)
+	}
+}
+
+func TestLoadDefinitionsJSON(t *testing.T) {
+	path := writeTestDefinitions(t, `{"machines": [{"name": "dev", "driver": "virtualbox", "options": {"memory": 1024}}]}`)
+	defer os.Remove(path)
+
+	definitions, err := loadDefinitions(path, testCreateFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(definitions) != 1 || definitions[0].Options["virtualbox-memory"] != 1024 {
+		t.Fatalf("unexpected definitions %v", definitions)
+	}
+}
+
+func TestLoadDefinitionsErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown option":  "machines:\n  - name: dev\n    driver: virtualbox\n    options:\n      color: red\n",
+		"swarm option":    "machines:\n  - name: dev\n    driver: virtualbox\n    options:\n      swarm-master: true\n",
+		"unknown driver":  "machines:\n  - name: dev\n    driver: foo\n",
+		"duplicate name":  "machines:\n  - name: dev\n    driver: none\n  - name: dev\n    driver: none\n",
+		"invalid name":    "machines:\n  - name: dev_1\n    driver: none\n",
+		"unknown field":   "machines:\n  - name: dev\n    driver: none\n    size: big\n",
+		"swarm discovery": "machines:\n  - name: dev\n    driver: none\n    swarm:\n      master: true\n",
+	}
+
+	for name, content := range tests {
+		path := writeTestDefinitions(t, content)
+		_, err := loadDefinitions(path, testCreateFlags)
+		os.Remove(path)
+		if err == nil {
+			t.Fatalf("expected an error for %s", name)
+		}
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	definitions := []*machineDefinition{
+		{Name: "web-1", Driver: "virtualbox", Labels: map[string]string{"role": "web"}},
+		{Name: "web-2", Driver: "virtualbox", Options: map[string]interface{}{"virtualbox-memory": 2048}},
+		{Name: "web-3", Driver: "virtualbox"},
+	}
+	hosts := []Host{
+		{Name: "web-1", DriverName: "virtualbox", Labels: map[string]string{"role": "db"}},
+		{Name: "web-2", DriverName: "virtualbox", CreateFlags: map[string][]string{"virtualbox-memory": {"1024"}}},
+		{Name: "old", DriverName: "virtualbox"},
+	}
+
+	plan := planApply(definitions, hosts, false)
+	if len(plan.Create) != 1 || plan.Create[0].Name != "web-3" {
+		t.Fatalf("expected to create web-3; received %v", plan.Create)
+	}
+	if len(plan.Remove) != 0 {
+		t.Fatalf("expected no removals without prune; received %v", plan.Remove)
+	}
+	if drift := plan.Drift["web-1"]; len(drift) != 1 || !strings.Contains(drift[0], "labels") {
+		t.Fatalf("expected label drift for web-1; received %v", drift)
+	}
+	if drift := plan.Drift["web-2"]; len(drift) != 1 || !strings.Contains(drift[0], "virtualbox-memory") {
+		t.Fatalf("expected memory drift for web-2; received %v", drift)
+	}
+
+	plan = planApply(definitions, hosts, true)
+	if len(plan.Remove) != 1 || plan.Remove[0] != "old" {
+		t.Fatalf("expected to remove old; received %v", plan.Remove)
+	}
+}
+
+func TestApplyDriftOfCreatedMachine(t *testing.T) {
+	store, err := getTestStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store.Path)
+
+	definition := &machineDefinition{
+		Name:    "test",
+		Driver:  "none",
+		Options: map[string]interface{}{"url": "tcp://10.0.0.5:2376"},
+	}
+	if _, err := store.Create(definition.Name, definition.Driver, &definitionOptions{definition, getDefaultTestDriverFlags()}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan := planApply([]*machineDefinition{definition}, hosts, false); !plan.empty() {
+		t.Fatalf("expected no changes for an unchanged definition; received %+v", plan)
+	}
+
+	definition.Options["url"] = "tcp://10.0.0.6:2376"
+	plan := planApply([]*machineDefinition{definition}, hosts, false)
+	if drift := plan.Drift["test"]; len(drift) != 1 || !strings.Contains(drift[0], "option url") {
+		t.Fatalf("expected url drift; received %v", drift)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"reflect"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/docker/machine/drivers"
+)
+
+// cliOptions are driver options which know which flags were given, such as
+// the *cli.Context of create
+type cliOptions interface {
+	drivers.DriverOptions
+	flagLister
+}
+
+// cloneOptions are the options of a machine created from another one with
+// create --from. Driver options which are not given explicitly default to
+// the create options recorded for the source machine.
+type cloneOptions struct {
+	flags    cliOptions
+	defaults drivers.DriverOptions
+	source   *Host
+	explicit bool
+}
+
+func newCloneOptions(c *cli.Context, source *Host) *cloneOptions {
+	return &cloneOptions{
+		flags:    c,
+		defaults: defaultOptions(c.App, c.Command),
+		source:   source,
+		explicit: true,
+	}
+}
+
+// defaultOptions returns the default values of the flags of a command,
+// ignoring the command line
+func defaultOptions(app *cli.App, command cli.Command) *cli.Context {
+	set := flag.NewFlagSet(command.Name, flag.ContinueOnError)
+	for _, f := range command.Flags {
+		f.Apply(set)
+	}
+
+	c := cli.NewContext(app, set, nil)
+	c.Command = command
+	return c
+}
+
+// recorded returns the recorded value of a driver option of the source
+// machine, unless the option is given explicitly
+func (o *cloneOptions) recorded(name string) ([]string, bool) {
+	if !strings.HasPrefix(name, o.source.DriverName+"-") {
+		return nil, false
+	}
+	if o.explicit && o.flags.IsSet(name) {
+		return nil, false
+	}
+
+	values, ok := o.source.CreateFlags[name]
+	if !ok {
+		return nil, false
+	}
+	for _, value := range values {
+		if value == redactedSecret {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+func (o *cloneOptions) options(name string) drivers.DriverOptions {
+	if !o.explicit && o.flags.IsSet(name) {
+		return o.defaults
+	}
+	return o.flags
+}
+
+func (o *cloneOptions) String(name string) string {
+	if values, ok := o.recorded(name); ok && len(values) > 0 {
+		return values[0]
+	}
+	return o.options(name).String(name)
+}
+
+func (o *cloneOptions) StringSlice(name string) []string {
+	if values, ok := o.recorded(name); ok {
+		return values
+	}
+	return o.options(name).StringSlice(name)
+}
+
+func (o *cloneOptions) Int(name string) int {
+	if values, ok := o.recorded(name); ok && len(values) > 0 {
+		if value, err := strconv.Atoi(values[0]); err == nil {
+			return value
+		}
+	}
+	return o.options(name).Int(name)
+}
+
+func (o *cloneOptions) Bool(name string) bool {
+	if values, ok := o.recorded(name); ok && len(values) > 0 {
+		if value, err := strconv.ParseBool(values[0]); err == nil {
+			return value
+		}
+	}
+	return o.options(name).Bool(name)
+}
+
+func (o *cloneOptions) FlagNames() []string {
+	return o.flags.FlagNames()
+}
+
+// IsSet reports whether the flag was given or taken from the source machine
+func (o *cloneOptions) IsSet(name string) bool {
+	if _, ok := o.recorded(name); ok {
+		return true
+	}
+	return o.flags.IsSet(name)
+}
+
+func (o *cloneOptions) Generic(name string) interface{} {
+	if values, ok := o.recorded(name); ok {
+		slice := cli.StringSlice(values)
+		return &slice
+	}
+	return o.flags.Generic(name)
+}
+
+// copyDriverConfig copies the driver config of the source machine to the
+// driver of the host, which has been configured with the options. Identity
+// fields and fields set by explicitly given options are kept.
+func (o *cloneOptions) copyDriverConfig(host *Host) error {
+	// configure a driver without the explicit options to find the fields
+	// they set
+	implicit := *o
+	implicit.explicit = false
+	unset, err := drivers.NewDriver(host.DriverName, host.Name, host.storePath, host.CaCertPath, host.PrivateKeyPath)
+	if err != nil {
+		return err
+	}
+	if err := unset.SetConfigFromFlags(&implicit); err != nil {
+		// the fields set before the error are still usable
+		log.Debugf("error configuring driver without explicit options: %s", err)
+	}
+
+	source, err := driverConfigMap(o.source.Driver)
+	if err != nil {
+		return err
+	}
+	for _, field := range drivers.IdentityFields(o.source.Driver) {
+		deleteConfigPath(source, field)
+	}
+
+	current, err := driverConfigMap(host.Driver)
+	if err != nil {
+		return err
+	}
+	unsetConfig, err := driverConfigMap(unset)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range source {
+		if _, ok := current[key]; !ok {
+			continue
+		}
+		if !reflect.DeepEqual(current[key], unsetConfig[key]) {
+			continue
+		}
+		current[key] = value
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, host.Driver)
+}
+
+func driverConfigMap(d drivers.Driver) (map[string]interface{}, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+
+	config := map[string]interface{}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// deleteConfigPath deletes the value at the path, e.g. ["Client", "Id"],
+// from a decoded config
+func deleteConfigPath(config map[string]interface{}, path []string) {
+	for _, name := range path[:len(path)-1] {
+		nested, ok := config[name].(map[string]interface{})
+		if !ok {
+			return
+		}
+		config = nested
+	}
+	delete(config, path[len(path)-1])
+}
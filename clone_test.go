@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/codegangsta/cli"
+	"github.com/docker/machine/drivers/virtualbox"
+)
+
+func getTestCloneContext(t *testing.T, args []string) *cli.Context {
+	command := cli.Command{
+		Name: "create",
+		Flags: []cli.Flag{
+			cli.IntFlag{Name: "virtualbox-memory", Value: 1024},
+			cli.IntFlag{Name: "virtualbox-disk-size", Value: 20000},
+			cli.StringFlag{Name: "virtualbox-boot2docker-url", Value: ""},
+			cli.BoolFlag{Name: "swarm-master"},
+		},
+	}
+	set := flag.NewFlagSet("create", flag.ContinueOnError)
+	for _, f := range command.Flags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	c := cli.NewContext(nil, set, nil)
+	c.Command = command
+	return c
+}
+
+func getTestCloneSource(t *testing.T) *Host {
+	source, err := NewHost("source", "virtualbox", "", "", "", true, "", "token://1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := source.Driver.(*virtualbox.Driver)
+	d.Memory = 4096
+	d.DiskSize = 40000
+	d.Boot2DockerURL = "https://example.com/boot2docker.iso"
+	d.SSHPort = 2222
+	d.SwarmMaster = true
+	return source
+}
+
+func cloneTestHost(t *testing.T, source *Host, args []string) *virtualbox.Driver {
+	options := newCloneOptions(getTestCloneContext(t, args), source)
+
+	host, err := NewHost("clone", "virtualbox", "", "", "", false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := host.Driver.SetConfigFromFlags(options); err != nil {
+		t.Fatal(err)
+	}
+	if err := options.copyDriverConfig(host); err != nil {
+		t.Fatal(err)
+	}
+	return host.Driver.(*virtualbox.Driver)
+}
+
+func TestCloneDriverConfig(t *testing.T) {
+	d := cloneTestHost(t, getTestCloneSource(t), []string{"--virtualbox-disk-size=30000"})
+
+	if d.Memory != 4096 || d.Boot2DockerURL != "https://example.com/boot2docker.iso" {
+		t.Fatalf("expected the options of the source to be copied; received %+v", d)
+	}
+	if d.DiskSize != 30000 {
+		t.Fatalf("expected the explicit disk size 30000; received %d", d.DiskSize)
+	}
+	if d.MachineName != "clone" || d.SSHPort != 0 || d.SwarmMaster {
+		t.Fatalf("expected identity fields not to be copied; received %+v", d)
+	}
+}
+
+func TestCloneRecordedOptions(t *testing.T) {
+	source := getTestCloneSource(t)
+	source.CreateFlags = map[string][]string{
+		"virtualbox-memory":    {"2048"},
+		"virtualbox-disk-size": {"40000"},
+		"swarm-master":         {"true"},
+	}
+
+	options := newCloneOptions(getTestCloneContext(t, []string{"--virtualbox-disk-size=30000"}), source)
+
+	if memory := options.Int("virtualbox-memory"); memory != 2048 {
+		t.Fatalf("expected the recorded memory 2048; received %d", memory)
+	}
+	if diskSize := options.Int("virtualbox-disk-size"); diskSize != 30000 {
+		t.Fatalf("expected the explicit disk size 30000; received %d", diskSize)
+	}
+	if options.Bool("swarm-master") {
+		t.Fatal("expected options other than driver options not to be copied")
+	}
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"sort"
 	"strings"
 	"text/tabwriter"
+	"text/template"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
@@ -28,8 +32,10 @@ import (
 	_ "github.com/docker/machine/drivers/vmwarefusion"
 	_ "github.com/docker/machine/drivers/vmwarevcloudair"
 	_ "github.com/docker/machine/drivers/vmwarevsphere"
+	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/state"
 	"github.com/docker/machine/utils"
+	"github.com/docker/machine/wait"
 )
 
 type machineConfig struct {
@@ -42,8 +48,12 @@ type machineConfig struct {
 	swarmMaster    bool
 	swarmHost      string
 	swarmDiscovery string
+	host           *Host
 }
 
+// defaultListTimeout is how long ls waits for the state of each machine
+const defaultListTimeout = 10 * time.Second
+
 type hostListItem struct {
 	Name           string
 	Active         bool
@@ -52,20 +62,11 @@ type hostListItem struct {
 	URL            string
 	SwarmMaster    bool
 	SwarmDiscovery string
-}
-
-type hostListItemByName []hostListItem
-
-func (h hostListItemByName) Len() int {
-	return len(h)
-}
-
-func (h hostListItemByName) Swap(i, j int) {
-	h[i], h[j] = h[j], h[i]
-}
-
-func (h hostListItemByName) Less(i, j int) bool {
-	return strings.ToLower(h[i].Name) < strings.ToLower(h[j].Name)
+	Error          string
+	IP             string
+	CreatedAt      time.Time
+	DockerVersion  string
+	Size           string
 }
 
 func setupCertificates(caCertPath, caKeyPath, clientCertPath, clientKeyPath string) error {
@@ -121,11 +122,75 @@ func setupCertificates(caCertPath, caKeyPath, clientCertPath, clientKeyPath stri
 	return nil
 }
 
+// filterFlag selects the machines a command operates on, e.g.
+// --filter label=env=staging
+var filterFlag = cli.StringSliceFlag{
+	Name:  "filter",
+	Usage: "Select machines with a filter, e.g. label=key=value",
+	Value: &cli.StringSlice{},
+}
+
+// allFlag selects all machines
+var allFlag = cli.BoolFlag{
+	Name:  "all, a",
+	Usage: "Select all machines",
+}
+
+// parallelFlag limits how many machines an action runs on at the same time
+var parallelFlag = cli.IntFlag{
+	Name:  "parallel",
+	Usage: "Number of machines to run the action on at the same time, 0 for no limit",
+	Value: defaultParallel,
+}
+
+// createTimeoutFlag limits how long all waits of each create of a command
+// take together
+var createTimeoutFlag = cli.DurationFlag{
+	Name:  "create-timeout",
+	Usage: "Give up waiting for the machine after this long, e.g. 15m (default: no overall timeout)",
+}
+
+// yesFlag skips the confirmation of destructive operations on several machines
+var yesFlag = cli.BoolFlag{
+	Name:  "yes, y",
+	Usage: "Do not ask for confirmation",
+}
+
 var Commands = []cli.Command{
 	{
 		Name:   "active",
 		Usage:  "Get or set the active machine",
 		Action: cmdActive,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "local, l",
+				Usage: "Set the active machine of the working directory in a .machine file",
+			},
+		},
+	},
+	{
+		Name:        "apply",
+		Usage:       "Create machines from a definition file",
+		Description: "Creates the machines defined in the file which do not exist yet and reports how existing ones differ from their definitions.",
+		Action:      cmdApply,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "file, f",
+				Usage: "YAML or JSON file defining the machines",
+				Value: "",
+			},
+			cli.BoolFlag{
+				Name:  "prune",
+				Usage: "Remove machines which are not defined in the file",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Show the changes without making them",
+			},
+			parallelFlag,
+			createTimeoutFlag,
+			yesFlag,
+		},
 	},
 	{
 		Flags: append(
@@ -161,6 +226,21 @@ var Commands = []cli.Command{
 				Usage: "addr to advertise for Swarm (default: detect and use the machine IP)",
 				Value: "",
 			},
+			cli.StringFlag{
+				Name:  "from",
+				Usage: "Create the machine with the driver options of an existing machine",
+				Value: "",
+			},
+			cli.BoolFlag{
+				Name:  "keep-on-failure",
+				Usage: "Keep the machine and its resources if the create fails instead of removing them",
+			},
+			createTimeoutFlag,
+			cli.StringSliceFlag{
+				Name:  "label",
+				Usage: "Label to set on the machine as key=value",
+				Value: &cli.StringSlice{},
+			},
 		),
 		Name:   "create",
 		Usage:  "Create a machine",
@@ -172,49 +252,164 @@ var Commands = []cli.Command{
 		Description: "Argument is a machine name. Will use the active machine if none is provided.",
 		Action:      cmdConfig,
 		Flags: []cli.Flag{
+			filterFlag,
+			formatFlag,
 			cli.BoolFlag{
 				Name:  "swarm",
 				Usage: "Display the Swarm config instead of the Docker daemon",
 			},
 		},
 	},
+	{
+		Name:        "config-defaults",
+		Usage:       "Show the effective defaults of the options and where they come from",
+		Description: "Argument is an optional prefix of the options to show.",
+		Action:      cmdConfigDefaults,
+	},
+	{
+		Name:        "export",
+		Usage:       "Export a machine to an archive",
+		Description: "Argument is a machine name. Will use the active machine if none is provided.",
+		Action:      cmdExport,
+		Flags: []cli.Flag{
+			filterFlag,
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "File to write the archive to (default: <machine-name>.tar.gz)",
+				Value: "",
+			},
+			cli.StringFlag{
+				EnvVar: "MACHINE_EXPORT_PASSPHRASE",
+				Name:   "passphrase",
+				Usage:  "Encrypt the keys and config of the machine with this passphrase (required)",
+				Value:  "",
+			},
+		},
+	},
+	{
+		Name:        "import",
+		Usage:       "Import a machine from an archive created by export",
+		Description: "Argument is the archive to import.",
+		Action:      cmdImport,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "name",
+				Usage: "Name to import the machine as (default: the name it was exported with)",
+				Value: "",
+			},
+			cli.StringFlag{
+				EnvVar: "MACHINE_EXPORT_PASSPHRASE",
+				Name:   "passphrase",
+				Usage:  "Passphrase the archive was exported with",
+				Value:  "",
+			},
+		},
+	},
+	{
+		Name:        "history",
+		Usage:       "Show how a machine was created and the actions run on it",
+		Description: "Argument is a machine name. Will use the active machine if none is provided.",
+		Action:      cmdHistory,
+		Flags:       []cli.Flag{filterFlag, formatFlag},
+	},
 	{
 		Name:        "inspect",
 		Usage:       "Inspect information about a machine",
 		Description: "Argument is a machine name. Will use the active machine if none is provided.",
 		Action:      cmdInspect,
+		Flags: []cli.Flag{
+			filterFlag,
+			formatFlag,
+			cli.BoolFlag{
+				Name:  "show-secrets",
+				Usage: "Show credentials of the driver instead of redacting them",
+			},
+		},
 	},
 	{
 		Name:        "ip",
 		Usage:       "Get the IP address of a machine",
 		Description: "Argument is a machine name. Will use the active machine if none is provided.",
 		Action:      cmdIp,
+		Flags:       []cli.Flag{filterFlag, formatFlag},
 	},
 	{
 		Name:        "kill",
 		Usage:       "Kill a machine",
-		Description: "Argument(s) are one or more machine names. Will use the active machine if none is provided.",
+		Description: "Argument(s) are one or more machine names or glob patterns, e.g. 'web-*'. Will use the active machine if none is provided.",
 		Action:      cmdKill,
+		Flags:       []cli.Flag{filterFlag, allFlag, parallelFlag, yesFlag},
+	},
+	{
+		Name:        "label",
+		Usage:       "Add or remove labels of a machine",
+		Description: "Arguments are machine-name add key=value... or machine-name rm key...",
+		Action:      cmdLabel,
+		Flags:       []cli.Flag{filterFlag, allFlag},
 	},
 	{
 		Flags: []cli.Flag{
+			filterFlag,
+			formatFlag,
 			cli.BoolFlag{
 				Name:  "quiet, q",
 				Usage: "Enable quiet mode",
 			},
+			cli.BoolFlag{
+				Name:  "no-cache",
+				Usage: "Get the state of all machines from their providers without using the state cache",
+			},
+			cli.BoolFlag{
+				Name:  "refresh",
+				Usage: "Get the state of all machines from their providers and update the state cache",
+			},
+			cli.DurationFlag{
+				Name:  "timeout, t",
+				Usage: "Time to wait for the state of each machine, 0 to wait indefinitely",
+				Value: defaultListTimeout,
+			},
+			cli.StringFlag{
+				Name:  "sort",
+				Usage: "Sort the machines by name, driver, state or created",
+				Value: "name",
+			},
+			cli.StringFlag{
+				Name:  "columns",
+				Usage: fmt.Sprintf("Comma separated optional columns to show: %s", strings.Join(listColumns, ", ")),
+				Value: "",
+			},
 		},
 		Name:   "ls",
 		Usage:  "List machines",
 		Action: cmdLs,
 	},
+	{
+		Name:        "provision",
+		Usage:       "Run the remaining phases of an interrupted create",
+		Description: "Argument is a machine name. Will use the active machine if none is provided.",
+		Action:      cmdProvision,
+		Flags: []cli.Flag{
+			filterFlag,
+			cli.StringFlag{
+				Name:  "from-phase",
+				Usage: fmt.Sprintf("Run all phases from this phase on: %s", strings.Join(resumablePhases(), ", ")),
+				Value: "",
+			},
+			createTimeoutFlag,
+		},
+	},
 	{
 		Name:        "restart",
 		Usage:       "Restart a machine",
-		Description: "Argument(s) are one or more machine names. Will use the active machine if none is provided.",
+		Description: "Argument(s) are one or more machine names or glob patterns, e.g. 'web-*'. Will use the active machine if none is provided.",
 		Action:      cmdRestart,
+		Flags:       []cli.Flag{filterFlag, allFlag, parallelFlag, yesFlag},
 	},
 	{
 		Flags: []cli.Flag{
+			filterFlag,
+			allFlag,
+			yesFlag,
 			cli.BoolFlag{
 				Name:  "force, f",
 				Usage: "Remove local configuration even if machine cannot be removed",
@@ -222,7 +417,7 @@ var Commands = []cli.Command{
 		},
 		Name:        "rm",
 		Usage:       "Remove a machine",
-		Description: "Argument(s) are one or more machine names. Will use the active machine if none is provided.",
+		Description: "Argument(s) are one or more machine names or glob patterns, e.g. 'web-*'. Will use the active machine if none is provided.",
 		Action:      cmdRm,
 	},
 	{
@@ -231,6 +426,7 @@ var Commands = []cli.Command{
 		Description: "Argument is a machine name. Will use the active machine if none is provided.",
 		Action:      cmdEnv,
 		Flags: []cli.Flag{
+			filterFlag,
 			cli.BoolFlag{
 				Name:  "swarm",
 				Usage: "Display the Swarm config instead of the Docker daemon",
@@ -239,6 +435,30 @@ var Commands = []cli.Command{
 				Name:  "unset, u",
 				Usage: "Unset variables instead of setting them",
 			},
+			cli.StringFlag{
+				Name:  "shell",
+				Usage: "Shell to print the commands for, detected from $SHELL if not given: " + strings.Join(supportedShells(), ", "),
+			},
+			cli.BoolFlag{
+				Name:  "no-proxy",
+				Usage: "Add the IP of the machine to NO_PROXY",
+			},
+			cli.BoolFlag{
+				Name:  "tunnel",
+				Usage: "Point Docker at the local end of the running tunnel --docker to the machine",
+			},
+		},
+	},
+	{
+		Name:        "scp",
+		Usage:       "Copy files between the local host and machines",
+		Description: "Arguments are [machine:][path] [machine:][path].",
+		Action:      cmdScp,
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "recursive, r",
+				Usage: "Copy directories recursively",
+			},
 		},
 	},
 	{
@@ -246,44 +466,91 @@ var Commands = []cli.Command{
 		Usage:       "Log into or run a command on a machine with SSH",
 		Description: "Arguments are [machine-name] command - Will use the active machine if none is provided.",
 		Action:      cmdSsh,
+		Flags:       []cli.Flag{filterFlag},
+	},
+	{
+		Name:        "ssh-keyscan",
+		Usage:       "Show or re-pin the SSH host key of a machine",
+		Description: "Argument is a machine name. Will use the active machine if none is provided.",
+		Action:      cmdSshKeyscan,
+		Flags: []cli.Flag{
+			filterFlag,
+			cli.BoolFlag{
+				Name:  "reset",
+				Usage: "Replace the pinned host key with the current key of the machine",
+			},
+		},
 	},
 	{
 		Name:        "start",
 		Usage:       "Start a machine",
-		Description: "Argument(s) are one or more machine names. Will use the active machine if none is provided.",
+		Description: "Argument(s) are one or more machine names or glob patterns, e.g. 'web-*'. Will use the active machine if none is provided.",
 		Action:      cmdStart,
+		Flags:       []cli.Flag{filterFlag, allFlag, parallelFlag},
 	},
 	{
 		Name:        "stop",
 		Usage:       "Stop a machine",
-		Description: "Argument(s) are one or more machine names. Will use the active machine if none is provided.",
+		Description: "Argument(s) are one or more machine names or glob patterns, e.g. 'web-*'. Will use the active machine if none is provided.",
 		Action:      cmdStop,
+		Flags:       []cli.Flag{filterFlag, allFlag, parallelFlag, yesFlag},
+	},
+	{
+		Name:        "tunnel",
+		Usage:       "Forward local ports to a machine over SSH",
+		Description: "Argument is a machine name. Will use the active machine if none is provided.",
+		Action:      cmdTunnel,
+		Flags: []cli.Flag{
+			filterFlag,
+			cli.StringSliceFlag{
+				Name:  "L",
+				Usage: "Forward a local port to a host and port as seen from the machine, as [bind_address:]port:host:hostport",
+				Value: &cli.StringSlice{},
+			},
+			cli.StringFlag{
+				Name:  "D",
+				Usage: "Run a SOCKS5 proxy on the local [bind_address:]port which connects from the machine",
+			},
+			cli.BoolFlag{
+				Name:  "docker",
+				Usage: "Forward a local port to the Docker port of the machine, and one to the Swarm port of a Swarm master, for env --tunnel",
+			},
+			cli.IntFlag{
+				Name:  "docker-port",
+				Usage: "Local port to forward to the Docker port with --docker (default: a free port)",
+			},
+		},
 	},
 	{
 		Name:        "upgrade",
 		Usage:       "Upgrade a machine to the latest version of Docker",
-		Description: "Argument(s) are one or more machine names. Will use the active machine if none is provided.",
+		Description: "Argument(s) are one or more machine names or glob patterns, e.g. 'web-*'. Will use the active machine if none is provided.",
 		Action:      cmdUpgrade,
+		Flags:       []cli.Flag{filterFlag, allFlag, parallelFlag, yesFlag},
 	},
 	{
 		Name:        "url",
 		Usage:       "Get the URL of a machine",
 		Description: "Argument is a machine name. Will use the active machine if none is provided.",
 		Action:      cmdUrl,
+		Flags:       []cli.Flag{filterFlag, formatFlag},
 	},
 }
 
 func cmdActive(c *cli.Context) {
 	name := c.Args().First()
-	store := NewStore(utils.GetMachineDir(), c.GlobalString("tls-ca-cert"), c.GlobalString("tls-ca-key"))
+	store := getStore(c)
 
 	if name == "" {
-		host, err := store.GetActive()
+		host, source, err := getActiveHost(store)
 		if err != nil {
 			log.Fatalf("error getting active host: %v", err)
 		}
 		if host != nil {
 			fmt.Println(host.Name)
+			// the source goes to stderr so that the output can be used
+			// as a machine name
+			fmt.Fprintf(os.Stderr, "Active machine set by %s\n", source)
 		}
 	} else if name != "" {
 		host, err := store.Load(name)
@@ -291,9 +558,23 @@ func cmdActive(c *cli.Context) {
 			log.Fatalf("error loading host: %v", err)
 		}
 
+		if c.Bool("local") {
+			wd, err := os.Getwd()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := setLocalActive(wd, host); err != nil {
+				log.Fatalf("error setting active host: %v", err)
+			}
+			return
+		}
+
 		if err := store.SetActive(host); err != nil {
 			log.Fatalf("error setting active host: %v", err)
 		}
+		if _, source, err := getActiveHost(store); err == nil && source != activeSourceGlobal {
+			log.Warnf("%s is not active here, as the active machine is set by %s", name, source)
+		}
 	} else {
 		cli.ShowCommandHelp(c, "active")
 	}
@@ -313,12 +594,28 @@ func cmdCreate(c *cli.Context) {
 		log.Fatalf("Error generating certificates: %s", err)
 	}
 
-	store := NewStore(utils.GetMachineDir(), c.GlobalString("tls-ca-cert"), c.GlobalString("tls-ca-key"))
+	store := getStore(c)
 
-	host, err := store.Create(name, driver, c)
+	var flags drivers.DriverOptions = c
+	if from := c.String("from"); from != "" {
+		source, err := store.Load(from)
+		if err != nil {
+			log.Fatalf("Error loading machine %s: %s", from, err)
+		}
+		if (c.IsSet("driver") || c.IsSet("d")) && driver != source.DriverName {
+			log.Fatalf("%s was created with the %s driver", from, source.DriverName)
+		}
+		driver = source.DriverName
+		flags = newCloneOptions(c, source)
+	}
+
+	newWaiter, stopWaits := limitWaits(c,
+		fmt.Sprintf("Canceling the create of machine %s and cleaning up", name),
+		fmt.Sprintf("Interrupted; run rm %s to remove what is left of the machine", name))
+	host, err := store.Create(name, driver, flags, newWaiter())
+	stopWaits()
 	if err != nil {
 		log.Errorf("Error creating machine: %s", err)
-		log.Warn("You will want to check the provider to make sure the machine and associated resources were properly removed.")
 		log.Fatal("Error creating machine")
 	}
 	if err := store.SetActive(host); err != nil {
@@ -329,6 +626,49 @@ func cmdCreate(c *cli.Context) {
 	log.Infof("To point your Docker client at it, run this in your shell: $(%s env %s)", c.App.Name, name)
 }
 
+// limitWaits returns a function making a Waiter for each create run by the
+// command, whose waits time out after --create-timeout and are canceled on
+// Ctrl-C, see cancelOnInterrupt. The returned stop function stops handling
+// interrupts.
+func limitWaits(c *cli.Context, canceling string, interrupted string) (func() *wait.Waiter, func()) {
+	command := wait.New(nil, 0)
+	timeout := c.Duration("create-timeout")
+	newWaiter := func() *wait.Waiter {
+		return wait.New(command, timeout)
+	}
+	return newWaiter, cancelOnInterrupt(command, canceling, interrupted)
+}
+
+// cancelOnInterrupt cancels the waits of waiter on the first Ctrl-C, logging
+// canceling, so that the running creates fail. A second Ctrl-C exits at once
+// with interrupted. The returned function stops handling interrupts.
+func cancelOnInterrupt(waiter *wait.Waiter, canceling string, interrupted string) func() {
+	interrupts := make(chan os.Signal, 2)
+	signal.Notify(interrupts, os.Interrupt)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-interrupts:
+		case <-done:
+			return
+		}
+		log.Warnf("%s; press Ctrl-C again to exit at once", canceling)
+		waiter.Cancel()
+
+		select {
+		case <-interrupts:
+			log.Fatal(interrupted)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(interrupts)
+		close(done)
+	}
+}
+
 func cmdConfig(c *cli.Context) {
 	cfg, err := getMachineConfig(c)
 	if err != nil {
@@ -356,41 +696,270 @@ func cmdConfig(c *cli.Context) {
 
 		dockerHost = fmt.Sprintf("tcp://%s:%s", machineIp, swarmPort)
 	}
+
+	if format := c.String("format"); format != "" {
+		if err := writeFormatted(os.Stdout, format, configJSON{
+			Name:       cfg.machineName,
+			Host:       dockerHost,
+			TLSCaCert:  cfg.caCertPath,
+			TLSCert:    cfg.clientCertPath,
+			TLSKey:     cfg.clientKeyPath,
+			SwarmAgent: c.Bool("swarm"),
+		}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	fmt.Printf("--tls --tlscacert=%s --tlscert=%s --tlskey=%s -H=%q",
 		cfg.caCertPath, cfg.clientCertPath, cfg.clientKeyPath, dockerHost)
 }
 
+func cmdExport(c *cli.Context) {
+	host := getHost(c)
+
+	output := c.String("output")
+	if output == "" {
+		output = host.Name + ".tar.gz"
+	}
+
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := exportHost(host, f, c.String("passphrase")); err != nil {
+		f.Close()
+		os.Remove(output)
+		log.Fatalf("Error exporting machine: %s", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Infof("%q has been exported to %s", host.Name, output)
+}
+
+func cmdImport(c *cli.Context) {
+	archive := c.Args().First()
+	if archive == "" {
+		cli.ShowCommandHelp(c, "import")
+		log.Fatal("You must specify an archive")
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	store := getStore(c)
+
+	// the name is only known once the manifest has been read
+	name := c.String("name")
+	hostPath := func(name string) string {
+		return filepath.Join(utils.GetMachineDir(), name)
+	}
+
+	host, err := importHost(store, f, name, hostPath, filepath.Dir(c.GlobalString("tls-ca-cert")), c.String("passphrase"))
+	if err != nil {
+		log.Fatalf("Error importing machine: %s", err)
+	}
+
+	log.Infof("%q has been imported", host.Name)
+}
+
 func cmdInspect(c *cli.Context) {
-	prettyJSON, err := json.MarshalIndent(getHost(c), "", "    ")
+	host := getHost(c)
+	format := c.String("format")
+
+	if format == jsonFormat {
+		if err := writeJSON(os.Stdout, newInspectJSON(host)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(host)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	fmt.Println(string(prettyJSON))
+	if !c.Bool("show-secrets") {
+		if data, err = redactSecrets(data, host.Driver); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if format != "" {
+		if err := writeInspectTemplate(os.Stdout, format, host.Name, data); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var prettyJSON bytes.Buffer
+	if err := json.Indent(&prettyJSON, data, "", "    "); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(prettyJSON.String())
+}
+
+// writeInspectTemplate executes the template with the fields of the host.
+// The fields are taken from its encoded config, so that secrets stay
+// redacted unless --show-secrets is given.
+func writeInspectTemplate(w io.Writer, format string, name string, data []byte) error {
+	tmpl, err := parseTemplate(format)
+	if err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	fields["Name"] = name
+
+	return writeTemplate(w, tmpl, fields)
 }
 
 func cmdIp(c *cli.Context) {
-	ip, err := getHost(c).Driver.GetIP()
+	host := getHost(c)
+	ip, err := host.Driver.GetIP()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if format := c.String("format"); format != "" {
+		if err := writeFormatted(os.Stdout, format, ipJSON{Name: host.Name, IP: ip}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	fmt.Println(ip)
 }
 
+func cmdLabel(c *cli.Context) {
+	args := []string(c.Args())
+	names := []string{}
+
+	// with a filter or --all the machines are not named
+	if len(c.StringSlice("filter")) == 0 && !c.Bool("all") {
+		if len(args) == 0 {
+			cli.ShowCommandHelp(c, "label")
+			log.Fatal("You must specify a machine name")
+		}
+		names, args = args[:1], args[1:]
+	}
+
+	if len(args) < 2 || (args[0] != "add" && args[0] != "rm") {
+		cli.ShowCommandHelp(c, "label")
+		log.Fatal("You must specify add or rm and at least one label")
+	}
+
+	add := map[string]string{}
+	remove := []string{}
+	if args[0] == "add" {
+		labels, err := parseLabels(args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		add = labels
+	} else {
+		remove = args[1:]
+	}
+
+	hosts, err := selectHosts(c, names)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	isError := false
+
+	store := getStore(c)
+	for _, host := range hosts {
+		if err := updateLabels(store, host.Name, add, remove); err != nil {
+			log.Errorf("Error labeling machine %s: %s", host.Name, err)
+			isError = true
+		}
+	}
+	if isError {
+		log.Fatal("There was an error labeling a machine")
+	}
+}
+
 func cmdLs(c *cli.Context) {
-	quiet := c.Bool("quiet")
-	store := NewStore(utils.GetMachineDir(), c.GlobalString("tls-ca-cert"), c.GlobalString("tls-ca-key"))
+	format := c.String("format")
+	quiet := c.Bool("quiet") && format == ""
+	store := getStore(c)
+
+	var tmpl *template.Template
+	if format != "" && format != jsonFormat {
+		t, err := parseTemplate(format)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tmpl = t
+	}
 
-	hostList, err := store.List()
+	// the state filters are applied to the states queried for the listing,
+	// so that they use the state cache and the timeout
+	otherFilters, stateFilters := splitStateFilters(c.StringSlice("filter"))
+	filters, err := parseFilters(otherFilters, store)
 	if err != nil {
 		log.Fatal(err)
 	}
+	needStates := !quiet || len(stateFilters) > 0
+
+	columns, err := parseColumns(c.String("columns"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sortKey := c.String("sort")
+	if _, ok := listSortKeys[sortKey]; !ok {
+		log.Fatalf("unknown sort key %q; valid keys are name, driver, state and created", sortKey)
+	}
+
+	allHosts, err := store.List()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	hostList := []Host{}
+	for i := range allHosts {
+		if matchesFilters(&allHosts[i], filters) {
+			hostList = append(hostList, allHosts[i])
+		}
+	}
 
 	w := tabwriter.NewWriter(os.Stdout, 5, 1, 3, ' ', 0)
 
+	if !quiet && format == "" {
+		header := "NAME\tACTIVE\tDRIVER\tSTATE\tURL\tSWARM"
+		for _, column := range columns {
+			header += "\t" + columnHeader(column)
+		}
+		fmt.Fprintln(w, header+"\tERRORS")
+	}
+
+	mode := stateCacheUse
+	if c.Bool("no-cache") {
+		mode = stateCacheOff
+	} else if c.Bool("refresh") {
+		mode = stateCacheRefresh
+	}
+
+	activeName := ""
 	if !quiet {
-		fmt.Fprintln(w, "NAME\tACTIVE\tDRIVER\tSTATE\tURL\tSWARM")
+		active, _, err := getActiveHost(store)
+		if err != nil {
+			log.Debugf("error getting active host: %s", err)
+		} else if active != nil {
+			activeName = active.Name
+		}
 	}
 
 	items := []hostListItem{}
@@ -400,7 +969,7 @@ func cmdLs(c *cli.Context) {
 	swarmInfo := make(map[string]string)
 
 	for _, host := range hostList {
-		if !quiet {
+		if needStates {
 			if host.SwarmMaster {
 				swarmMasters[host.SwarmDiscovery] = host.Name
 			}
@@ -409,21 +978,70 @@ func cmdLs(c *cli.Context) {
 				swarmInfo[host.Name] = host.SwarmDiscovery
 			}
 
-			go getHostState(host, *store, hostListItems)
+			go getHostState(host, activeName, mode, c.Duration("timeout"), columns, hostListItems)
 		} else {
 			fmt.Fprintf(w, "%s\n", host.Name)
 		}
 	}
 
-	if !quiet {
+	if needStates {
 		for i := 0; i < len(hostList); i++ {
-			items = append(items, <-hostListItems)
+			item := <-hostListItems
+			if matchesStates(item.State, stateFilters) {
+				items = append(items, item)
+			}
 		}
 	}
 
 	close(hostListItems)
 
-	sort.Sort(hostListItemByName(items))
+	if err := sortHostListItems(items, sortKey); err != nil {
+		log.Fatal(err)
+	}
+
+	if quiet {
+		for _, item := range items {
+			fmt.Fprintf(w, "%s\n", item.Name)
+		}
+		w.Flush()
+		return
+	}
+
+	switch {
+	case format == jsonFormat:
+		jsonItems := []listItemJSON{}
+		for _, item := range items {
+			swarm := ""
+			if item.SwarmDiscovery != "" {
+				swarm = swarmMasters[item.SwarmDiscovery]
+			}
+			jsonItems = append(jsonItems, listItemJSON{
+				Name:        item.Name,
+				Active:      item.Active,
+				Driver:      item.DriverName,
+				State:       item.State.String(),
+				URL:         item.URL,
+				Swarm:       swarm,
+				SwarmMaster: item.SwarmMaster,
+				Error:       item.Error,
+				IP:          item.IP,
+				Created:     item.columnValue(createdColumn),
+				Docker:      item.DockerVersion,
+				Size:        item.Size,
+			})
+		}
+		if err := writeJSON(os.Stdout, jsonItems); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case tmpl != nil:
+		for _, item := range items {
+			if err := writeTemplate(os.Stdout, tmpl, item); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
 
 	for _, item := range items {
 		activeString := ""
@@ -439,25 +1057,77 @@ func cmdLs(c *cli.Context) {
 				swarmInfo = fmt.Sprintf("%s (master)", swarmInfo)
 			}
 		}
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+		row := fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s",
 			item.Name, activeString, item.DriverName, item.State, item.URL, swarmInfo)
+		for _, column := range columns {
+			row += "\t" + item.columnValue(column)
+		}
+		fmt.Fprintln(w, row+"\t"+item.Error)
 	}
 
 	w.Flush()
 }
 
+func cmdProvision(c *cli.Context) {
+	name := getHost(c).Name
+	store := getStore(c)
+
+	if err := store.Lock(name); err != nil {
+		log.Fatal(err)
+	}
+
+	// reload the host now that nothing else can change it
+	host, err := store.Load(name)
+	if err == nil {
+		newWaiter, stopWaits := limitWaits(c,
+			fmt.Sprintf("Canceling the provision of machine %s", name),
+			fmt.Sprintf("Interrupted; run provision %s to resume", name))
+		err = host.resumeCreate(c.String("from-phase"), newWaiter())
+		stopWaits()
+		host.recordEvent("provision", err)
+	}
+
+	store.Unlock(name)
+
+	if err != nil {
+		log.Fatalf("Error provisioning machine: %s", err)
+	}
+
+	log.Infof("%q has been provisioned", name)
+}
+
 func cmdRm(c *cli.Context) {
-	if len(c.Args()) == 0 {
+	names := []string(c.Args())
+	selector := getHostSelector(c, names)
+
+	// machines selected by their exact names are not loaded, so that broken
+	// machines can still be removed
+	if selector.isBulk() {
+		hosts, err := getHosts(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		names = []string{}
+		for _, host := range hosts {
+			names = append(names, host.Name)
+		}
+	}
+
+	if len(names) == 0 {
 		cli.ShowCommandHelp(c, "rm")
 		log.Fatal("You must specify a machine name")
 	}
 
+	if (selector.isBulk() || len(names) > 1) && !confirmBulk(c, "remove", names) {
+		log.Fatal("Aborted")
+	}
+
 	force := c.Bool("force")
 
 	isError := false
 
-	store := NewStore(utils.GetMachineDir(), c.GlobalString("tls-ca-cert"), c.GlobalString("tls-ca-key"))
-	for _, host := range c.Args() {
+	store := getStore(c)
+	for _, host := range names {
 		if err := store.Remove(host, force); err != nil {
 			log.Errorf("Error removing machine %s: %s", host, err)
 			isError = true
@@ -469,13 +1139,15 @@ func cmdRm(c *cli.Context) {
 }
 
 func cmdEnv(c *cli.Context) {
-	userShell := filepath.Base(os.Getenv("SHELL"))
+	shell, err := detectShell(c.String("shell"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	if c.Bool("unset") {
-		switch userShell {
-		case "fish":
-			fmt.Printf("set -e DOCKER_TLS_VERIFY\nset -e DOCKER_CERT_PATH\nset -e DOCKER_HOST\n")
-		default:
-			fmt.Println("unset DOCKER_TLS_VERIFY DOCKER_CERT_PATH DOCKER_HOST")
+		writeUnsetEnv(os.Stdout, shell, envVariableNames)
+		if c.Bool("no-proxy") {
+			unsetNoProxy(c, shell)
 		}
 		return
 	}
@@ -508,58 +1180,93 @@ func cmdEnv(c *cli.Context) {
 		dockerHost = fmt.Sprintf("tcp://%s:%s", machineIp, swarmPort)
 	}
 
-	switch userShell {
-	case "fish":
-		fmt.Printf("set -x DOCKER_TLS_VERIFY yes\nset -x DOCKER_CERT_PATH %s\nset -x DOCKER_HOST %s\n",
-			cfg.machineDir, dockerHost)
-	default:
-		fmt.Printf("export DOCKER_TLS_VERIFY=yes\nexport DOCKER_CERT_PATH=%s\nexport DOCKER_HOST=%s\n",
-			cfg.machineDir, dockerHost)
+	if c.Bool("tunnel") {
+		info, err := cfg.host.loadTunnelInfo()
+		if err != nil {
+			log.Fatal(err)
+		}
+		dockerHost = info.DockerHost
+		if c.Bool("swarm") {
+			dockerHost = info.SwarmHost
+		}
 	}
-}
-
-func cmdSsh(c *cli.Context) {
-	var (
-		err    error
-		sshCmd *exec.Cmd
-	)
-	name := c.Args().First()
-	store := NewStore(utils.GetMachineDir(), c.GlobalString("tls-ca-cert"), c.GlobalString("tls-ca-key"))
 
-	if name == "" {
-		host, err := store.GetActive()
+	variables := []envVariable{
+		{"DOCKER_TLS_VERIFY", "yes"},
+		{"DOCKER_HOST", dockerHost},
+		{"DOCKER_CERT_PATH", cfg.machineDir},
+		{"DOCKER_MACHINE_NAME", cfg.machineName},
+	}
+	if c.Bool("no-proxy") {
+		noProxy, err := noProxyValue(dockerHost)
 		if err != nil {
-			log.Fatalf("unable to get active host: %v", err)
+			log.Fatal(err)
 		}
+		variables = append(variables, envVariable{noProxyVariable, noProxy})
+	}
 
-		name = host.Name
+	writeEnv(os.Stdout, shell, variables)
+}
+
+// unsetNoProxy removes the IP of the machine from NO_PROXY, keeping the
+// other entries, and unsets NO_PROXY if no entries are left
+func unsetNoProxy(c *cli.Context, shell envShell) {
+	cfg, err := getMachineConfig(c)
+	if err == nil && cfg.machineUrl == "" {
+		err = fmt.Errorf("%s is not running", cfg.machineName)
+	}
+	if err != nil {
+		log.Warnf("Leaving %s unchanged, as the IP of the machine is unknown: %s", noProxyVariable, err)
+		return
 	}
 
-	host, err := store.Load(name)
+	noProxy, err := noProxyWithout(cfg.machineUrl)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if noProxy == "" {
+		writeUnsetEnv(os.Stdout, shell, []string{noProxyVariable})
+		return
+	}
+	writeEnv(os.Stdout, shell, []envVariable{{noProxyVariable, noProxy}})
+}
+
+func cmdSsh(c *cli.Context) {
+	var err error
+	args := []string(c.Args())
+	var host *Host
 
-	if len(c.Args()) <= 1 {
-		sshCmd, err = host.Driver.GetSSHCommand()
+	// with a filter all arguments are the command
+	if len(c.StringSlice("filter")) > 0 {
+		if host, err = getFilteredHost(c); err != nil {
+			log.Fatal(err)
+		}
 	} else {
-		sshCmd, err = host.Driver.GetSSHCommand(c.Args()[1:]...)
+		// the first argument, if any, is the machine
+		host = getHost(c)
+
+		if len(args) > 0 {
+			args = args[1:]
+		}
 	}
+
+	client, err := host.sshClient()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	sshCmd.Stdin = os.Stdin
-	sshCmd.Stdout = os.Stdout
-	sshCmd.Stderr = os.Stderr
-	if err := sshCmd.Run(); err != nil {
+	if err := client.Shell(args...); err != nil {
+		// exit like the command, so that scripts can check its status
+		if status, ok := ssh.ExitStatus(err); ok {
+			os.Exit(status)
+		}
 		log.Fatal(err)
 	}
 }
 
-// machineCommand maps the command name to the corresponding machine command.
-// We run commands concurrently and communicate back an error if there was one.
-func machineCommand(actionName string, machine *Host, errorChan chan<- error) {
+// machineCommand maps the command name to the corresponding machine command
+// and runs it while holding the lock of the machine
+func machineCommand(actionName string, machine *Host) error {
 	commands := map[string](func() error){
 		"start":   machine.Driver.Start,
 		"stop":    machine.Driver.Stop,
@@ -570,67 +1277,76 @@ func machineCommand(actionName string, machine *Host, errorChan chan<- error) {
 
 	log.Debugf("command=%s machine=%s", actionName, machine.Name)
 
-	if err := commands[actionName](); err != nil {
-		errorChan <- err
-		return
+	store := machine.getStore()
+	if err := store.Lock(machine.Name); err != nil {
+		return err
+	}
+	defer store.Unlock(machine.Name)
+
+	err := commands[actionName]()
+	machine.recordEvent(actionName, err)
+	if err != nil {
+		machine.invalidateStateCache()
+		return err
 	}
 
-	errorChan <- nil
+	machine.refreshStateCache()
+	return nil
 }
 
-// runActionForeachMachine will run the command across multiple machines
-func runActionForeachMachine(actionName string, machines []*Host) {
-	var (
-		numConcurrentActions = 0
-		serialMachines       = []*Host{}
-		errorChan            = make(chan error)
-	)
+// destructiveActions need to be confirmed when they run on several machines,
+// as they interrupt the containers running on them. start is not, as it
+// leaves running machines alone.
+var destructiveActions = map[string]bool{
+	"kill":    true,
+	"restart": true,
+	"stop":    true,
+	"upgrade": true,
+}
 
-	for _, machine := range machines {
-		// Virtualbox is temperamental about doing things concurrently,
-		// so we schedule the actions in a "queue" to be executed serially
-		// after the concurrent actions are scheduled.
-		switch machine.DriverName {
-		case "virtualbox":
-			machine := machine
-			serialMachines = append(serialMachines, machine)
-		default:
-			numConcurrentActions++
-			go machineCommand(actionName, machine, errorChan)
-		}
+var errAborted = errors.New("Aborted")
+
+// runActionForeachMachine will run the command across multiple machines, at
+// most parallel at a time
+func runActionForeachMachine(actionName string, machines []*Host, parallel int) []actionResult {
+	return newExecutor(parallel).run(actionName, machines, func(machine *Host) error {
+		return machineCommand(actionName, machine)
+	})
+}
+
+func runActionWithContext(actionName string, c *cli.Context) error {
+	machines, err := getHosts(c)
+	if err != nil {
+		return err
 	}
 
-	// While the concurrent actions are running,
-	// do the serial actions.  As the name implies,
-	// these run one at a time.
-	for _, machine := range serialMachines {
-		serialChan := make(chan error)
-		go machineCommand(actionName, machine, serialChan)
-		if err := <-serialChan; err != nil {
-			log.Errorln(err)
+	if destructiveActions[actionName] && (getHostSelector(c, c.Args()).isBulk() || len(machines) > 1) {
+		names := []string{}
+		for _, machine := range machines {
+			names = append(names, machine.Name)
+		}
+		if !confirmBulk(c, actionName, names) {
+			return errAborted
 		}
-		close(serialChan)
 	}
 
-	// TODO: We should probably only do 5-10 of these
-	// at a time, since otherwise cloud providers might
-	// rate limit us.
-	for i := 0; i < numConcurrentActions; i++ {
-		if err := <-errorChan; err != nil {
-			log.Errorln(err)
-		}
+	parallel := c.Int("parallel")
+	if parallel < 0 {
+		return fmt.Errorf("--parallel must not be negative")
 	}
 
-	close(errorChan)
-}
+	results := runActionForeachMachine(actionName, machines, parallel)
 
-func runActionWithContext(actionName string, c *cli.Context) error {
-	machines, err := getHosts(c)
-	if err != nil {
-		return err
+	if len(results) > 1 {
+		printResults(os.Stdout, results)
 	}
 
-	runActionForeachMachine(actionName, machines)
+	if failed := failedResults(results); failed > 0 {
+		if len(results) == 1 {
+			return results[0].Err
+		}
+		return fmt.Errorf("%d of %d machines failed to %s", failed, len(results), actionName)
+	}
 
 	return nil
 }
@@ -666,11 +1382,19 @@ func cmdUpgrade(c *cli.Context) {
 }
 
 func cmdUrl(c *cli.Context) {
-	url, err := getHost(c).GetURL()
+	host := getHost(c)
+	url, err := host.GetURL()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if format := c.String("format"); format != "" {
+		if err := writeFormatted(os.Stdout, format, urlJSON{Name: host.Name, URL: url}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	fmt.Println(url)
 }
 
@@ -684,22 +1408,66 @@ func cmdNotFound(c *cli.Context, command string) {
 	)
 }
 
+// getStore returns the store configured by the global flags
+func getStore(c *cli.Context) Store {
+	caCert := c.GlobalString("tls-ca-cert")
+	caKey := c.GlobalString("tls-ca-key")
+
+	if storeURL := c.GlobalString("storage-url"); storeURL != "" {
+		return NewHTTPStore(storeURL, utils.GetMachineDir(), caCert, caKey)
+	}
+	return NewFilestore(utils.GetMachineDir(), caCert, caKey)
+}
+
 func getHosts(c *cli.Context) ([]*Host, error) {
-	machines := []*Host{}
-	for _, n := range c.Args() {
-		machine, err := loadMachine(n, c)
-		if err != nil {
-			return nil, err
-		}
+	return selectHosts(c, c.Args())
+}
+
+// selectHosts loads the machines selected by the names, which may be glob
+// patterns, and the --all and --filter options of a command
+func selectHosts(c *cli.Context, names []string) ([]*Host, error) {
+	return getHostSelector(c, names).selectHosts(getStore(c))
+}
+
+func getHostSelector(c *cli.Context, names []string) hostSelector {
+	return hostSelector{
+		Names:   names,
+		All:     c.Bool("all"),
+		Filters: c.StringSlice("filter"),
+	}
+}
 
-		machines = append(machines, machine)
+// confirmBulk asks the user to confirm a destructive operation on several
+// machines, unless -y was given
+func confirmBulk(c *cli.Context, action string, machines []string) bool {
+	if c.Bool("yes") {
+		return true
 	}
 
-	return machines, nil
+	fmt.Printf("About to %s %d machine(s): %s\nAre you sure? (y/N): ", action, len(machines), strings.Join(machines, ", "))
+
+	var answer string
+	fmt.Scanln(&answer)
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// getFilteredHost returns the single machine selected by the filters of a
+// command
+func getFilteredHost(c *cli.Context) (*Host, error) {
+	hosts, err := selectHosts(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(hosts) != 1 {
+		return nil, fmt.Errorf("The filters must select exactly one machine, but they select %d", len(hosts))
+	}
+	return hosts[0], nil
 }
 
 func loadMachine(name string, c *cli.Context) (*Host, error) {
-	store := NewStore(utils.GetMachineDir(), c.GlobalString("tls-ca-cert"), c.GlobalString("tls-ca-key"))
+	store := getStore(c)
 
 	machine, err := store.Load(name)
 	if err != nil {
@@ -711,10 +1479,18 @@ func loadMachine(name string, c *cli.Context) (*Host, error) {
 
 func getHost(c *cli.Context) *Host {
 	name := c.Args().First()
-	store := NewStore(utils.GetMachineDir(), c.GlobalString("tls-ca-cert"), c.GlobalString("tls-ca-key"))
+	store := getStore(c)
+
+	if name == "" && len(c.StringSlice("filter")) > 0 {
+		host, err := getFilteredHost(c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return host
+	}
 
 	if name == "" {
-		host, err := store.GetActive()
+		host, _, err := getActiveHost(store)
 		if err != nil {
 			log.Fatalf("unable to get active host: %v", err)
 		}
@@ -732,45 +1508,45 @@ func getHost(c *cli.Context) *Host {
 	return host
 }
 
-func getHostState(host Host, store Store, hostListItems chan<- hostListItem) {
-	currentState, err := host.Driver.GetState()
-	if err != nil {
-		log.Errorf("error getting state for host %s: %s", host.Name, err)
-	}
+func getHostState(host Host, activeName string, mode stateCacheMode, timeout time.Duration, columns []string, hostListItems chan<- hostListItem) {
+	current, err := host.getStateWithTimeout(mode, timeout)
 
-	url, err := host.GetURL()
-	if err != nil {
-		if err == drivers.ErrHostIsNotRunning {
-			url = ""
-		} else {
-			log.Errorf("error getting URL for host %s: %s", host.Name, err)
-		}
-	}
-
-	isActive, err := store.IsActive(&host)
-	if err != nil {
-		log.Debugf("error determining whether host %q is active: %s",
-			host.Name, err)
-	}
-
-	hostListItems <- hostListItem{
+	item := hostListItem{
 		Name:           host.Name,
-		Active:         isActive,
+		Active:         host.Name == activeName,
 		DriverName:     host.Driver.DriverName(),
-		State:          currentState,
-		URL:            url,
+		State:          current.State,
+		URL:            current.URL,
 		SwarmMaster:    host.SwarmMaster,
 		SwarmDiscovery: host.SwarmDiscovery,
+		CreatedAt:      host.CreatedAt,
+		Size:           hostSize(&host),
+	}
+
+	if err == nil && current.State == state.Running {
+		err = item.getRemoteColumns(&host, columns, timeout)
+	}
+	if err != nil {
+		log.Debugf("error getting state for host %s: %s", host.Name, err)
+		item.Error = err.Error()
 	}
+
+	hostListItems <- item
 }
 
 func getMachineConfig(c *cli.Context) (*machineConfig, error) {
 	name := c.Args().First()
-	store := NewStore(utils.GetMachineDir(), c.GlobalString("tls-ca-cert"), c.GlobalString("tls-ca-key"))
+	store := getStore(c)
 	var machine *Host
 
-	if name == "" {
-		m, err := store.GetActive()
+	if name == "" && len(c.StringSlice("filter")) > 0 {
+		m, err := getFilteredHost(c)
+		if err != nil {
+			return nil, err
+		}
+		machine = m
+	} else if name == "" {
+		m, _, err := getActiveHost(store)
 		if err != nil {
 			log.Fatalf("error getting active host: %v", err)
 		}
@@ -799,7 +1575,7 @@ func getMachineConfig(c *cli.Context) (*machineConfig, error) {
 		}
 	}
 	return &machineConfig{
-		machineName:    name,
+		machineName:    machine.Name,
 		machineDir:     machineDir,
 		caCertPath:     caCert,
 		clientCertPath: clientCert,
@@ -808,5 +1584,6 @@ func getMachineConfig(c *cli.Context) (*machineConfig, error) {
 		swarmMaster:    machine.SwarmMaster,
 		swarmHost:      machine.SwarmHost,
 		swarmDiscovery: machine.SwarmDiscovery,
+		host:           machine,
 	}, nil
 }
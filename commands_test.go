@@ -82,6 +82,22 @@ func (d *FakeDriver) GetDockerConfigDir() string {
 	return ""
 }
 
+func (d *FakeDriver) GetSSHHostname() (string, error) {
+	return "", nil
+}
+
+func (d *FakeDriver) GetSSHPort() (int, error) {
+	return 0, nil
+}
+
+func (d *FakeDriver) GetSSHUsername() string {
+	return ""
+}
+
+func (d *FakeDriver) GetSSHKeyPath() string {
+	return ""
+}
+
 func (d *FakeDriver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
 	return &exec.Cmd{}, nil
 }
@@ -94,15 +110,15 @@ func TestGetHosts(t *testing.T) {
 
 	flags := getDefaultTestDriverFlags()
 
-	store := NewStore(TestMachineDir, "", "")
+	store := NewFilestore(TestMachineDir, "", "")
 	var err error
 
-	_, err = store.Create("test-a", "none", flags)
+	_, err = store.Create("test-a", "none", flags, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_, err = store.Create("test-b", "none", flags)
+	_, err = store.Create("test-b", "none", flags, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -143,11 +159,6 @@ func TestGetHostState(t *testing.T) {
 	}
 	hostListItems := make(chan hostListItem)
 
-	store, err := getTestStore()
-	if err != nil {
-		t.Fatal(err)
-	}
-
 	hosts := []Host{
 		{
 			Name:       "foo",
@@ -181,7 +192,7 @@ func TestGetHostState(t *testing.T) {
 	}
 	items := []hostListItem{}
 	for _, host := range hosts {
-		go getHostState(host, *store, hostListItems)
+		go getHostState(host, "foo", stateCacheOff, 0, []string{}, hostListItems)
 	}
 	for i := 0; i < len(hosts); i++ {
 		items = append(items, <-hostListItems)
@@ -190,6 +201,9 @@ func TestGetHostState(t *testing.T) {
 		if expected[item.Name] != item.State {
 			t.Fatal("Expected state did not match for item", item)
 		}
+		if item.Active != (item.Name == "foo") {
+			t.Fatal("Expected only foo to be active", item)
+		}
 	}
 }
 
@@ -256,7 +270,7 @@ func TestRunActionForeachMachine(t *testing.T) {
 		},
 	}
 
-	runActionForeachMachine("start", machines)
+	runActionForeachMachine("start", machines, defaultParallel)
 
 	expected := map[string]state.State{
 		"foo":  state.Running,
@@ -284,7 +298,7 @@ func TestRunActionForeachMachine(t *testing.T) {
 		"ham":  state.Stopped,
 	}
 
-	runActionForeachMachine("stop", machines)
+	runActionForeachMachine("stop", machines, defaultParallel)
 
 	for _, machine := range machines {
 		state, _ := machine.Driver.GetState()
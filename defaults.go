@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/docker/machine/utils"
+)
+
+// The sources of the value of an option, in order of precedence
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceProfile = "profile"
+	sourceConfig  = "config"
+	sourceDefault = "default"
+)
+
+// defaultsFile is the format of the config file which sets defaults for
+// the global options and the options of create, e.g.
+//
+//	{
+//	    "defaults": {"amazonec2-region": "us-west-1"},
+//	    "profiles": {
+//	        "staging": {"amazonec2-instance-type": "t2.small"}
+//	    }
+//	}
+type defaultsFile struct {
+	Defaults map[string]interface{}            `json:"defaults"`
+	Profiles map[string]map[string]interface{} `json:"profiles"`
+}
+
+// configDefaults are the defaults set by the config file and a profile of it
+type configDefaults struct {
+	Path    string
+	Profile string
+	values  map[string]interface{}
+	sources map[string]string
+}
+
+// configuredDefaults are the defaults loaded at startup
+var configuredDefaults = &configDefaults{
+	values:  map[string]interface{}{},
+	sources: map[string]string{},
+}
+
+func defaultConfigPath() string {
+	return filepath.Join(utils.GetMachineRoot(), "config.json")
+}
+
+// loadConfigDefaults loads the defaults of the config file at path, or of
+// the default config file if path is empty. The default config file does not
+// need to exist.
+func loadConfigDefaults(path string, profile string) (*configDefaults, error) {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+	}
+
+	defaults := &configDefaults{
+		Path:    path,
+		Profile: profile,
+		values:  map[string]interface{}{},
+		sources: map[string]string{},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && !explicit {
+			if profile != "" {
+				return nil, fmt.Errorf("profile %s not found: there is no config file %s", profile, path)
+			}
+			return defaults, nil
+		}
+		return nil, err
+	}
+
+	var file defaultsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %s", path, err)
+	}
+
+	for name, value := range file.Defaults {
+		defaults.values[name] = value
+		defaults.sources[name] = sourceConfig
+	}
+
+	if profile != "" {
+		values, ok := file.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("profile %s not found in %s", profile, path)
+		}
+		for name, value := range values {
+			defaults.values[name] = value
+			defaults.sources[name] = sourceProfile
+		}
+	}
+
+	return defaults, nil
+}
+
+// flagInfo returns the name and environment variables of a flag
+func flagInfo(f cli.Flag) (string, string) {
+	var name, envVar string
+	switch f := f.(type) {
+	case cli.StringFlag:
+		name, envVar = f.Name, f.EnvVar
+	case cli.IntFlag:
+		name, envVar = f.Name, f.EnvVar
+	case cli.BoolFlag:
+		name, envVar = f.Name, f.EnvVar
+	case cli.BoolTFlag:
+		name, envVar = f.Name, f.EnvVar
+	case cli.StringSliceFlag:
+		name, envVar = f.Name, f.EnvVar
+	case cli.IntSliceFlag:
+		name, envVar = f.Name, f.EnvVar
+	case cli.DurationFlag:
+		name, envVar = f.Name, f.EnvVar
+	case cli.Float64Flag:
+		name, envVar = f.Name, f.EnvVar
+	case cli.GenericFlag:
+		name, envVar = f.Name, f.EnvVar
+	}
+	return strings.TrimSpace(strings.Split(name, ",")[0]), envVar
+}
+
+// envSet reports whether one of the environment variables of a flag is set
+func envSet(envVar string) bool {
+	if envVar == "" {
+		return false
+	}
+	for _, name := range strings.Split(envVar, ",") {
+		if os.Getenv(strings.TrimSpace(name)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the flags with their defaults replaced by the configured
+// ones. Flags given on the command line or in their environment variables
+// still take precedence, as they override the defaults.
+func (d *configDefaults) apply(flags []cli.Flag) ([]cli.Flag, error) {
+	applied := make([]cli.Flag, len(flags))
+	for i, f := range flags {
+		applied[i] = f
+
+		name, _ := flagInfo(f)
+		value, ok := d.values[name]
+		if !ok {
+			continue
+		}
+
+		configured, err := configureFlag(f, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s in %s: %s", name, d.Path, err)
+		}
+		applied[i] = configured
+	}
+	return applied, nil
+}
+
+// configureFlag returns the flag with its default set to value
+func configureFlag(f cli.Flag, value interface{}) (cli.Flag, error) {
+	text := fmt.Sprint(value)
+	if number, ok := value.(float64); ok {
+		// JSON numbers are decoded as floats
+		text = strconv.FormatFloat(number, 'f', -1, 64)
+	}
+
+	switch f := f.(type) {
+	case cli.StringFlag:
+		f.Value = text
+		return f, nil
+	case cli.IntFlag:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return nil, err
+		}
+		f.Value = n
+		return f, nil
+	case cli.DurationFlag:
+		duration, err := time.ParseDuration(text)
+		if err != nil {
+			return nil, err
+		}
+		f.Value = duration
+		return f, nil
+	case cli.BoolFlag:
+		return configureBoolFlag(f.Name, f.Usage, f.EnvVar, text)
+	case cli.BoolTFlag:
+		return configureBoolFlag(f.Name, f.Usage, f.EnvVar, text)
+	case cli.StringSliceFlag:
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		slice := cli.StringSlice{}
+		for _, v := range values {
+			slice = append(slice, fmt.Sprint(v))
+		}
+		f.Value = &slice
+		return f, nil
+	}
+	return nil, fmt.Errorf("options of type %T cannot be configured", f)
+}
+
+// configureBoolFlag returns a bool flag which defaults to the value. A flag
+// which defaults to true is a BoolTFlag.
+func configureBoolFlag(name string, usage string, envVar string, value string) (cli.Flag, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, err
+	}
+	if b {
+		return cli.BoolTFlag{Name: name, Usage: usage, EnvVar: envVar}, nil
+	}
+	return cli.BoolFlag{Name: name, Usage: usage, EnvVar: envVar}, nil
+}
+
+// applyConfigDefaults sets the configured defaults on the global flags and
+// the flags of create. It warns about options which are not one of them.
+func applyConfigDefaults(app *cli.App, defaults *configDefaults) error {
+	known := map[string]bool{}
+
+	flags, err := defaults.apply(app.Flags)
+	if err != nil {
+		return err
+	}
+	app.Flags = flags
+	for _, f := range app.Flags {
+		name, _ := flagInfo(f)
+		known[name] = true
+	}
+
+	for i := range app.Commands {
+		if app.Commands[i].Name != "create" {
+			continue
+		}
+		flags, err := defaults.apply(app.Commands[i].Flags)
+		if err != nil {
+			return err
+		}
+		app.Commands[i].Flags = flags
+		for _, f := range flags {
+			name, _ := flagInfo(f)
+			known[name] = true
+		}
+	}
+
+	for name := range defaults.values {
+		if !known[name] {
+			log.Warnf("Unknown option %s in %s", name, defaults.Path)
+		}
+	}
+
+	configuredDefaults = defaults
+	return nil
+}
+
+// preScanFlag returns the value of a global string flag given on the command
+// line, before the flags are parsed, or of its environment variable
+func preScanFlag(args []string, name string, envVar string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		for _, prefix := range []string{"--", "-"} {
+			if arg == prefix+name && i+1 < len(args) {
+				return args[i+1]
+			}
+			if strings.HasPrefix(arg, prefix+name+"=") {
+				return strings.TrimPrefix(arg, prefix+name+"=")
+			}
+		}
+	}
+	return os.Getenv(envVar)
+}
+
+// isSecretOption reports whether an option holds credentials, so that
+// config-defaults does not show its value
+func isSecretOption(name string) bool {
+	for _, word := range []string{"secret", "password", "token", "api-key", "access-key"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// optionDefault is an option with its effective value and its source
+type optionDefault struct {
+	Name   string
+	Value  string
+	Source string
+}
+
+// effectiveDefaults returns the effective values of the global options and
+// the options of create
+func effectiveDefaults(c *cli.Context, defaults *configDefaults) []optionDefault {
+	options := []optionDefault{}
+
+	source := func(name string, envVar string) string {
+		if envSet(envVar) {
+			return sourceEnv
+		}
+		if s, ok := defaults.sources[name]; ok {
+			if s == sourceProfile {
+				return fmt.Sprintf("%s %s", sourceProfile, defaults.Profile)
+			}
+			return s
+		}
+		return sourceDefault
+	}
+
+	for _, f := range c.App.Flags {
+		name, envVar := flagInfo(f)
+		if name == "help" || name == "version" {
+			continue
+		}
+		s := source(name, envVar)
+		if c.GlobalIsSet(name) {
+			s = sourceFlag
+		}
+		options = append(options, optionDefault{name, fmt.Sprint(c.GlobalGeneric(name)), s})
+	}
+
+	if createCommand := c.App.Command("create"); createCommand != nil {
+		createDefaults := defaultOptions(c.App, *createCommand)
+		for _, f := range createCommand.Flags {
+			name, envVar := flagInfo(f)
+			options = append(options, optionDefault{name, fmt.Sprint(createDefaults.Generic(name)), source(name, envVar)})
+		}
+	}
+
+	for i := range options {
+		if isSecretOption(options[i].Name) && options[i].Value != "" {
+			options[i].Value = redactedSecret
+		}
+	}
+
+	sort.Sort(optionDefaultsByName(options))
+	return options
+}
+
+type optionDefaultsByName []optionDefault
+
+func (o optionDefaultsByName) Len() int {
+	return len(o)
+}
+
+func (o optionDefaultsByName) Swap(i, j int) {
+	o[i], o[j] = o[j], o[i]
+}
+
+func (o optionDefaultsByName) Less(i, j int) bool {
+	return o[i].Name < o[j].Name
+}
+
+func cmdConfigDefaults(c *cli.Context) {
+	prefix := c.Args().First()
+	options := effectiveDefaults(c, configuredDefaults)
+
+	fmt.Printf("Config file: %s\n", configuredDefaults.Path)
+	if configuredDefaults.Profile != "" {
+		fmt.Printf("Profile:     %s\n", configuredDefaults.Profile)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 5, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "OPTION\tVALUE\tSOURCE")
+	for _, option := range options {
+		if prefix != "" && !strings.HasPrefix(option.Name, prefix) {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", option.Name, option.Value, option.Source)
+	}
+	w.Flush()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codegangsta/cli"
+)
+
+const testDefaultsFile = `{
+    "defaults": {
+        "driver": "virtualbox",
+        "virtualbox-memory": 2048,
+        "swarm": true
+    },
+    "profiles": {
+        "staging": {"virtualbox-memory": 4096}
+    }
+}`
+
+func writeTestDefaults(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "machine-defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(testDefaultsFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	path, cleanup := writeTestDefaults(t)
+	defer cleanup()
+
+	defaults, err := loadConfigDefaults(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaults.values["virtualbox-memory"] != float64(2048) || defaults.sources["virtualbox-memory"] != sourceConfig {
+		t.Fatalf("unexpected defaults %v", defaults.values)
+	}
+
+	defaults, err = loadConfigDefaults(path, "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if defaults.values["virtualbox-memory"] != float64(4096) || defaults.sources["virtualbox-memory"] != sourceProfile {
+		t.Fatalf("profile did not override the defaults: %v", defaults.values)
+	}
+	if defaults.sources["driver"] != sourceConfig {
+		t.Fatalf("expected driver from the config, got %s", defaults.sources["driver"])
+	}
+
+	if _, err := loadConfigDefaults(path, "production"); err == nil {
+		t.Fatal("expected an error for an unknown profile")
+	}
+	if _, err := loadConfigDefaults(path+".missing", ""); err == nil {
+		t.Fatal("expected an error for a missing config file")
+	}
+}
+
+func TestConfigDefaultsApply(t *testing.T) {
+	defaults := &configDefaults{
+		values: map[string]interface{}{
+			"driver":  "virtualbox",
+			"memory":  float64(2048),
+			"swarm":   true,
+			"timeout": "30s",
+			"labels":  []interface{}{"env=staging"},
+		},
+	}
+
+	flags, err := defaults.apply([]cli.Flag{
+		cli.StringFlag{Name: "driver, d", Value: "none"},
+		cli.IntFlag{Name: "memory", Value: 1024},
+		cli.BoolFlag{Name: "swarm"},
+		cli.DurationFlag{Name: "timeout", Value: time.Second},
+		cli.StringSliceFlag{Name: "labels", Value: &cli.StringSlice{}},
+		cli.StringFlag{Name: "other", Value: "unchanged"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f := flags[0].(cli.StringFlag); f.Value != "virtualbox" || f.Name != "driver, d" {
+		t.Fatalf("unexpected flag %v", f)
+	}
+	if f := flags[1].(cli.IntFlag); f.Value != 2048 {
+		t.Fatalf("unexpected memory %d", f.Value)
+	}
+	if _, ok := flags[2].(cli.BoolTFlag); !ok {
+		t.Fatalf("expected swarm to default to true, got %T", flags[2])
+	}
+	if f := flags[3].(cli.DurationFlag); f.Value != 30*time.Second {
+		t.Fatalf("unexpected timeout %s", f.Value)
+	}
+	if f := flags[4].(cli.StringSliceFlag); len(*f.Value) != 1 || (*f.Value)[0] != "env=staging" {
+		t.Fatalf("unexpected labels %v", f.Value)
+	}
+	if f := flags[5].(cli.StringFlag); f.Value != "unchanged" {
+		t.Fatalf("unexpected flag %v", f)
+	}
+
+	defaults.values = map[string]interface{}{"memory": "lots"}
+	if _, err := defaults.apply([]cli.Flag{cli.IntFlag{Name: "memory"}}); err == nil {
+		t.Fatal("expected an error for an invalid value")
+	}
+}
+
+func TestPreScanFlag(t *testing.T) {
+	os.Setenv("MACHINE_TEST_PROFILE", "env")
+	defer os.Unsetenv("MACHINE_TEST_PROFILE")
+
+	cases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--profile", "staging", "ls"}, "staging"},
+		{[]string{"--profile=staging", "ls"}, "staging"},
+		{[]string{"-profile", "staging", "ls"}, "staging"},
+		{[]string{"ls"}, "env"},
+		{[]string{"ssh", "dev", "--", "--profile", "x"}, "env"},
+	}
+
+	for _, c := range cases {
+		if value := preScanFlag(c.args, "profile", "MACHINE_TEST_PROFILE"); value != c.expected {
+			t.Errorf("%v: expected %q, got %q", c.args, c.expected, value)
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/docker/machine/drivers/amazonec2/amz"
 	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/state"
+	"github.com/docker/machine/wait"
 )
 
 const (
@@ -33,32 +34,37 @@ const (
 )
 
 type Driver struct {
-	Id                string
-	AccessKey         string
-	SecretKey         string
-	SessionToken      string
+	drivers.KnownHosts
+
+	Id                string `machine:"identity"`
+	AccessKey         string `machine:"secret"`
+	SecretKey         string `machine:"secret"`
+	SessionToken      string `machine:"secret"`
 	Region            string
 	AMI               string
-	SSHKeyID          int
-	KeyName           string
-	InstanceId        string
+	SSHKeyID          int    `machine:"identity"`
+	KeyName           string `machine:"identity"`
+	InstanceId        string `machine:"identity"`
 	InstanceType      string
-	IPAddress         string
-	MachineName       string
-	SecurityGroupId   string
+	IPAddress         string `machine:"identity"`
+	MachineName       string `machine:"identity"`
+	SecurityGroupId   string `machine:"identity"`
 	SecurityGroupName string
-	ReservationId     string
+	ReservationId     string `machine:"identity"`
 	RootSize          int64
 	VpcId             string
 	SubnetId          string
 	Zone              string
-	CaCertPath        string
-	PrivateKeyPath    string
-	SwarmMaster       bool
-	SwarmHost         string
-	SwarmDiscovery    string
+	CaCertPath        string `machine:"identity"`
+	PrivateKeyPath    string `machine:"identity"`
+	SwarmMaster       bool   `machine:"identity"`
+	SwarmHost         string `machine:"identity"`
+	SwarmDiscovery    string `machine:"identity"`
+	Tags              map[string]string
 	storePath         string
 	keyPath           string
+	recordStep        drivers.StepRecorder
+	waiter            *wait.Waiter
 }
 
 type CreateFlags struct {
@@ -75,6 +81,7 @@ func init() {
 	drivers.Register(driverName, &drivers.RegisteredDriver{
 		New:            NewDriver,
 		GetCreateFlags: GetCreateFlags,
+		Migrations:     []drivers.Migration{drivers.MigrateV0},
 	})
 }
 
@@ -199,6 +206,11 @@ func (d *Driver) DriverName() string {
 	return driverName
 }
 
+// MachineSize returns the size of the machine for machine ls
+func (d *Driver) MachineSize() string {
+	return d.InstanceType
+}
+
 func (d *Driver) checkPrereqs() error {
 	// check for existing keypair
 	key, err := d.getClient().GetKeyPair(d.MachineName)
@@ -269,8 +281,13 @@ func (d *Driver) Create() error {
 	}
 
 	d.InstanceId = instance.InstanceId
+	if err := drivers.RecordStep(d.recordStep, "instance", d.InstanceId); err != nil {
+		return err
+	}
 
-	d.waitForInstance()
+	if err := d.waitForInstance(); err != nil {
+		return err
+	}
 
 	log.Debugf("created instance ID %s, IP address %s",
 		d.InstanceId,
@@ -278,36 +295,70 @@ func (d *Driver) Create() error {
 
 	log.Infof("Waiting for SSH on %s:%d", d.IPAddress, 22)
 
-	if err := ssh.WaitForTCP(fmt.Sprintf("%s:%d", d.IPAddress, 22)); err != nil {
+	if err := d.waiter.ForTCP(fmt.Sprintf("%s:%d", d.IPAddress, 22), 0); err != nil {
 		return err
 	}
 
 	log.Info("Configuring Machine...")
 
 	log.Debug("Settings tags for instance")
-	tags := map[string]string{
-		"Name": d.MachineName,
+	tags := map[string]string{}
+	for k, v := range d.Tags {
+		tags[k] = v
 	}
+	tags["Name"] = d.MachineName
 
 	if err = d.getClient().CreateTags(d.InstanceId, tags); err != nil {
 		return err
 	}
 
 	log.Debugf("Setting hostname: %s", d.MachineName)
-	cmd, err := d.GetSSHCommand(fmt.Sprintf(
+	if _, err := drivers.RunSSHCommandFromDriver(d, fmt.Sprintf(
 		"echo \"127.0.0.1 %s\" | sudo tee -a /etc/hosts && sudo hostname %s && echo \"%s\" | sudo tee /etc/hostname",
 		d.MachineName,
 		d.MachineName,
 		d.MachineName,
-	))
-
-	if err != nil {
+	)); err != nil {
 		return err
 	}
-	if err := cmd.Run(); err != nil {
-		return err
+
+	return nil
+}
+
+// SetLabels applies the labels of the machine as tags on the instance. The
+// Name tag is always the machine name.
+func (d *Driver) SetLabels(labels map[string]string) error {
+	tags := map[string]string{}
+	for k, v := range labels {
+		if k != "Name" {
+			tags[k] = v
+		}
 	}
 
+	if d.InstanceId != "" {
+		removed := []string{}
+		for k := range d.Tags {
+			if _, ok := tags[k]; !ok {
+				removed = append(removed, k)
+			}
+		}
+
+		if len(removed) > 0 {
+			log.Debugf("removing tags %v from instance %s", removed, d.InstanceId)
+			if err := d.getClient().DeleteTags(d.InstanceId, removed); err != nil {
+				return err
+			}
+		}
+
+		if len(tags) > 0 {
+			log.Debugf("setting tags for instance %s", d.InstanceId)
+			if err := d.getClient().CreateTags(d.InstanceId, tags); err != nil {
+				return err
+			}
+		}
+	}
+
+	d.Tags = tags
 	return nil
 }
 
@@ -387,6 +438,32 @@ func (d *Driver) Remove() error {
 	return nil
 }
 
+func (d *Driver) SetStepRecorder(record drivers.StepRecorder) {
+	d.recordStep = record
+}
+
+func (d *Driver) Rollback(step drivers.CreateStep) error {
+	switch step.Resource {
+	case "instance":
+		log.Debugf("terminating instance: %s", step.ID)
+		return d.getClient().TerminateInstance(step.ID)
+	case "keypair":
+		log.Debugf("deleting key pair: %s", step.ID)
+		return d.getClient().DeleteKeyPair(step.ID)
+	case "securitygroup":
+		log.Debugf("deleting security group: %s", step.ID)
+		// the group cannot be deleted while the terminating instance uses it
+		return d.waiter.Until("the security group to be deleted", 5*time.Minute, func() (bool, error) {
+			if err := d.getClient().DeleteSecurityGroup(step.ID); err != nil {
+				log.Debug(err)
+				return false, nil
+			}
+			return true, nil
+		})
+	}
+	return fmt.Errorf("unknown resource %q", step.Resource)
+}
+
 func (d *Driver) Restart() error {
 	if err := d.getClient().RestartInstance(d.InstanceId); err != nil {
 		return fmt.Errorf("unable to restart instance: %s", err)
@@ -404,11 +481,7 @@ func (d *Driver) Kill() error {
 func (d *Driver) StartDocker() error {
 	log.Debug("Starting Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker start")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker start"); err != nil {
 		return err
 	}
 
@@ -418,11 +491,7 @@ func (d *Driver) StartDocker() error {
 func (d *Driver) StopDocker() error {
 	log.Debug("Stopping Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker stop")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker stop"); err != nil {
 		return err
 	}
 
@@ -436,21 +505,28 @@ func (d *Driver) GetDockerConfigDir() string {
 func (d *Driver) Upgrade() error {
 	log.Debugf("Upgrading Docker")
 
-	cmd, err := d.GetSSHCommand("sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
-	if err != nil {
-		return err
+	_, err := drivers.RunSSHCommandFromDriver(d, "sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
+	return err
+}
 
-	}
-	if err := cmd.Run(); err != nil {
-		return err
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.IPAddress, nil
+}
 
-	}
+func (d *Driver) GetSSHPort() (int, error) {
+	return 22, nil
+}
 
-	return cmd.Run()
+func (d *Driver) GetSSHUsername() string {
+	return "ubuntu"
 }
 
 func (d *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
-	return ssh.GetSSHCommand(d.IPAddress, 22, "ubuntu", d.sshKeyPath(), args...), nil
+	return drivers.GetSSHCommandFromDriver(d, args...)
+}
+
+func (d *Driver) SetWaiter(w *wait.Waiter) {
+	d.waiter = w
 }
 
 func (d *Driver) getClient() *amz.EC2 {
@@ -458,35 +534,29 @@ func (d *Driver) getClient() *amz.EC2 {
 	return amz.NewEC2(auth, d.Region)
 }
 
-func (d *Driver) sshKeyPath() string {
+func (d *Driver) GetSSHKeyPath() string {
 	return path.Join(d.storePath, "id_rsa")
 }
 
 func (d *Driver) updateDriver() error {
-	inst, err := d.getInstance()
-	if err != nil {
-		return err
-	}
 	// wait for ipaddress
-	for {
-		i, err := d.getInstance()
+	return d.waiter.Until("the IP address of the instance", 0, func() (bool, error) {
+		inst, err := d.getInstance()
 		if err != nil {
-			return err
+			return false, err
 		}
-		if i.IpAddress == "" {
-			time.Sleep(1 * time.Second)
-			continue
+		if inst.IpAddress == "" {
+			return false, nil
 		}
 
 		d.InstanceId = inst.InstanceId
 		d.IPAddress = inst.IpAddress
-		break
-	}
-	return nil
+		return true, nil
+	})
 }
 
 func (d *Driver) publicSSHKeyPath() string {
-	return d.sshKeyPath() + ".pub"
+	return d.GetSSHKeyPath() + ".pub"
 }
 
 func (d *Driver) getInstance() (*amz.EC2Instance, error) {
@@ -499,15 +569,14 @@ func (d *Driver) getInstance() (*amz.EC2Instance, error) {
 }
 
 func (d *Driver) waitForInstance() error {
-	for {
+	if err := d.waiter.Until("the instance to run", 0, func() (bool, error) {
 		st, err := d.GetState()
 		if err != nil {
-			return err
-		}
-		if st == state.Running {
-			break
+			return false, err
 		}
-		time.Sleep(1 * time.Second)
+		return st == state.Running, nil
+	}); err != nil {
+		return err
 	}
 
 	if err := d.updateDriver(); err != nil {
@@ -519,7 +588,7 @@ func (d *Driver) waitForInstance() error {
 
 func (d *Driver) createKeyPair() error {
 
-	if err := ssh.GenerateSSHKey(d.sshKeyPath()); err != nil {
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
 		return err
 	}
 
@@ -537,7 +606,7 @@ func (d *Driver) createKeyPair() error {
 	}
 
 	d.KeyName = keyName
-	return nil
+	return drivers.RecordStep(d.recordStep, "keypair", keyName)
 }
 
 func (d *Driver) terminate() error {
@@ -582,16 +651,25 @@ func (d *Driver) configureSecurityGroup(groupName string) error {
 		if err != nil {
 			return err
 		}
+		if group == nil {
+			return fmt.Errorf("security group %s was created concurrently; please retry", groupName)
+		}
 		securityGroup = group
+		// only a group created here is removed again; existing groups
+		// may be used by other machines
+		if err := drivers.RecordStep(d.recordStep, "securitygroup", group.GroupId); err != nil {
+			return err
+		}
 		// wait until created (dat eventual consistency)
 		log.Debugf("waiting for group (%s) to become available", group.GroupId)
-		for {
-			_, err := d.getClient().GetSecurityGroupById(group.GroupId)
-			if err == nil {
-				break
+		if err := d.waiter.Until("the security group to become available", 0, func() (bool, error) {
+			if _, err := d.getClient().GetSecurityGroupById(group.GroupId); err != nil {
+				log.Debug(err)
+				return false, nil
 			}
-			log.Debug(err)
-			time.Sleep(1 * time.Second)
+			return true, nil
+		}); err != nil {
+			return err
 		}
 	}
 
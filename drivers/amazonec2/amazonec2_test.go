@@ -125,3 +125,14 @@ func TestValidateAwsRegionInvalid(t *testing.T) {
 		}
 	}
 }
+
+func TestSetLabelsBeforeCreate(t *testing.T) {
+	d := &Driver{}
+	if err := d.SetLabels(map[string]string{"env": "staging", "Name": "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.Tags) != 1 || d.Tags["env"] != "staging" {
+		t.Fatalf("unexpected tags: %v", d.Tags)
+	}
+}
@@ -305,6 +305,30 @@ func (e *EC2) CreateTags(id string, tags map[string]string) error {
 	return nil
 }
 
+func (e *EC2) DeleteTags(id string, keys []string) error {
+	v := url.Values{}
+	v.Set("Action", "DeleteTags")
+	v.Set("ResourceId.1", id)
+
+	for i, k := range keys {
+		v.Set(fmt.Sprintf("Tag.%d.Key", i+1), k)
+	}
+
+	resp, err := e.awsApiCall(v)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	deleteTagsResponse := &DeleteTagsResponse{}
+
+	if err := getDecodedResponse(*resp, &deleteTagsResponse); err != nil {
+		return fmt.Errorf("Error decoding delete tags response: %s", err)
+	}
+
+	return nil
+}
+
 func (e *EC2) CreateSecurityGroup(name string, description string, vpcId string) (*SecurityGroup, error) {
 	v := url.Values{}
 	v.Set("Action", "CreateSecurityGroup")
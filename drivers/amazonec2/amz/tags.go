@@ -4,3 +4,8 @@ type CreateTagsResponse struct {
 	RequestId string `xml:"requestId"`
 	Return    bool   `xml:"return"`
 }
+
+type DeleteTagsResponse struct {
+	RequestId string `xml:"requestId"`
+	Return    bool   `xml:"return"`
+}
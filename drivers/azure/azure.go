@@ -18,6 +18,7 @@ import (
 	"github.com/docker/machine/drivers"
 	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/state"
+	"github.com/docker/machine/wait"
 )
 
 const (
@@ -25,29 +26,33 @@ const (
 )
 
 type Driver struct {
-	MachineName             string
+	drivers.KnownHosts
+
+	MachineName             string `machine:"identity"`
 	SubscriptionID          string
 	SubscriptionCert        string
 	PublishSettingsFilePath string
 	Location                string
 	Size                    string
 	UserName                string
-	UserPassword            string
+	UserPassword            string `machine:"secret"`
 	Image                   string
 	SSHPort                 int
 	DockerPort              int
-	CaCertPath              string
-	PrivateKeyPath          string
-	SwarmMaster             bool
-	SwarmHost               string
-	SwarmDiscovery          string
+	CaCertPath              string `machine:"identity"`
+	PrivateKeyPath          string `machine:"identity"`
+	SwarmMaster             bool   `machine:"identity"`
+	SwarmHost               string `machine:"identity"`
+	SwarmDiscovery          string `machine:"identity"`
 	storePath               string
+	waiter                  *wait.Waiter
 }
 
 func init() {
 	drivers.Register("azure", &drivers.RegisteredDriver{
 		New:            NewDriver,
 		GetCreateFlags: GetCreateFlags,
+		Migrations:     []drivers.Migration{drivers.MigrateV0},
 	})
 }
 
@@ -119,6 +124,11 @@ func (driver *Driver) DriverName() string {
 	return "azure"
 }
 
+// MachineSize returns the size of the machine for machine ls
+func (driver *Driver) MachineSize() string {
+	return driver.Size
+}
+
 func (driver *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	driver.SubscriptionID = flags.String("azure-subscription-id")
 
@@ -222,7 +232,7 @@ func (driver *Driver) Create() error {
 	log.Info("Waiting for SSH...")
 	log.Debugf("Host: %s SSH Port: %d", driver.getHostname(), driver.SSHPort)
 
-	if err := ssh.WaitForTCP(fmt.Sprintf("%s:%d", driver.getHostname(), driver.SSHPort)); err != nil {
+	if err := driver.waiter.ForTCP(fmt.Sprintf("%s:%d", driver.getHostname(), driver.SSHPort), 0); err != nil {
 		return err
 	}
 
@@ -230,12 +240,14 @@ func (driver *Driver) Create() error {
 }
 
 func (driver *Driver) runSSHCommand(command string, retries int) error {
-	cmd, err := driver.GetSSHCommand(command)
+	client, err := drivers.GetSSHClientFromDriver(driver)
 	if err != nil {
 		return err
 	}
-	if err := cmd.Run(); err != nil {
-		if err.Error() == "exit status 255" {
+	if _, err := client.Output(command); err != nil {
+		// retry if the connection failed, which the ssh binary reports
+		// with exit status 255
+		if status, ok := ssh.ExitStatus(err); !ok || status == 255 {
 			if retries == 0 {
 				return err
 			}
@@ -419,11 +431,7 @@ func (driver *Driver) Kill() error {
 func (d *Driver) StartDocker() error {
 	log.Debug("Starting Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker start")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker start"); err != nil {
 		return err
 	}
 
@@ -433,11 +441,7 @@ func (d *Driver) StartDocker() error {
 func (d *Driver) StopDocker() error {
 	log.Debug("Stopping Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker stop")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker stop"); err != nil {
 		return err
 	}
 
@@ -448,38 +452,45 @@ func (d *Driver) GetDockerConfigDir() string {
 	return dockerConfigDir
 }
 
-func (driver *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
+func (driver *Driver) GetSSHHostname() (string, error) {
 	err := driver.setUserSubscription()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	vmState, err := driver.GetState()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
 	if vmState == state.Stopped {
-		return nil, fmt.Errorf("Azure host is stopped. Please start it before using ssh command.")
+		return "", fmt.Errorf("Azure host is stopped. Please start it before using ssh command.")
 	}
 
-	return ssh.GetSSHCommand(driver.getHostname(), driver.SSHPort, driver.UserName, driver.sshKeyPath(), args...), nil
+	return driver.getHostname(), nil
 }
 
-func (driver *Driver) Upgrade() error {
-	log.Debugf("Upgrading Docker")
+func (driver *Driver) GetSSHPort() (int, error) {
+	return driver.SSHPort, nil
+}
 
-	cmd, err := driver.GetSSHCommand("sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
-	if err != nil {
-		return err
+func (driver *Driver) GetSSHUsername() string {
+	return driver.UserName
+}
 
-	}
-	if err := cmd.Run(); err != nil {
-		return err
+func (driver *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
+	return drivers.GetSSHCommandFromDriver(driver, args...)
+}
 
-	}
+func (driver *Driver) SetWaiter(w *wait.Waiter) {
+	driver.waiter = w
+}
+
+func (driver *Driver) Upgrade() error {
+	log.Debugf("Upgrading Docker")
 
-	return cmd.Run()
+	_, err := drivers.RunSSHCommandFromDriver(driver, "sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
+	return err
 }
 
 func generateVMName() string {
@@ -524,7 +535,7 @@ func (driver *Driver) addDockerEndpoint(vmConfig *vmClient.Role) error {
 
 func (driver *Driver) waitForSSH() error {
 	log.Infof("Waiting for SSH...")
-	err := ssh.WaitForTCP(fmt.Sprintf("%s:%v", driver.getHostname(), driver.SSHPort))
+	err := driver.waiter.ForTCP(fmt.Sprintf("%s:%v", driver.getHostname(), driver.SSHPort), 0)
 	if err != nil {
 		return err
 	}
@@ -534,39 +545,30 @@ func (driver *Driver) waitForSSH() error {
 
 func (driver *Driver) waitForDocker() error {
 	log.Infof("Waiting for docker daemon on host to be available...")
-	maxRepeats := 48
 	url := fmt.Sprintf("%s:%v", driver.getHostname(), driver.DockerPort)
-	success := waitForDockerEndpoint(url, maxRepeats)
-	if !success {
-		return fmt.Errorf("Can not run docker daemon on remote machine. Please try again.")
+	if err := waitForDockerEndpoint(driver.waiter, url, 8*time.Minute); err != nil {
+		return fmt.Errorf("Can not run docker daemon on remote machine: %s", err)
 	}
 	return nil
 }
 
-func waitForDockerEndpoint(url string, maxRepeats int) bool {
-	counter := 0
-	for {
+func waitForDockerEndpoint(w *wait.Waiter, url string, timeout time.Duration) error {
+	return w.Until(fmt.Sprintf("Docker to listen on %s", url), timeout, func() (bool, error) {
 		conn, err := net.Dial("tcp", url)
 		if err != nil {
-			time.Sleep(10 * time.Second)
-			counter++
-			if counter == maxRepeats {
-				return false
-			}
-			continue
+			return false, nil
 		}
-		defer conn.Close()
-		break
-	}
-	return true
+		conn.Close()
+		return true, nil
+	})
 }
 
 func (driver *Driver) generateCertForAzure() error {
-	if err := ssh.GenerateSSHKey(driver.sshKeyPath()); err != nil {
+	if err := ssh.GenerateSSHKey(driver.GetSSHKeyPath()); err != nil {
 		return err
 	}
 
-	cmd := exec.Command("openssl", "req", "-x509", "-key", driver.sshKeyPath(), "-nodes", "-days", "365", "-newkey", "rsa:2048", "-out", driver.azureCertPath(), "-subj", "/C=AU/ST=Some-State/O=InternetWidgitsPtyLtd/CN=\\*")
+	cmd := exec.Command("openssl", "req", "-x509", "-key", driver.GetSSHKeyPath(), "-nodes", "-days", "365", "-newkey", "rsa:2048", "-out", driver.azureCertPath(), "-subj", "/C=AU/ST=Some-State/O=InternetWidgitsPtyLtd/CN=\\*")
 	if err := cmd.Run(); err != nil {
 		return err
 	}
@@ -574,12 +576,12 @@ func (driver *Driver) generateCertForAzure() error {
 	return nil
 }
 
-func (driver *Driver) sshKeyPath() string {
+func (driver *Driver) GetSSHKeyPath() string {
 	return filepath.Join(driver.storePath, "id_rsa")
 }
 
 func (driver *Driver) publicSSHKeyPath() string {
-	return driver.sshKeyPath() + ".pub"
+	return driver.GetSSHKeyPath() + ".pub"
 }
 
 func (driver *Driver) azureCertPath() string {
@@ -5,7 +5,7 @@ import (
 	"io/ioutil"
 	"os/exec"
 	"path/filepath"
-	"time"
+	"strconv"
 
 	"code.google.com/p/goauth2/oauth"
 	log "github.com/Sirupsen/logrus"
@@ -14,6 +14,7 @@ import (
 	"github.com/docker/machine/drivers"
 	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/state"
+	"github.com/docker/machine/wait"
 )
 
 const (
@@ -21,28 +22,33 @@ const (
 )
 
 type Driver struct {
-	AccessToken    string
-	DropletID      int
-	DropletName    string
+	drivers.KnownHosts
+
+	AccessToken    string `machine:"secret"`
+	DropletID      int    `machine:"identity"`
+	DropletName    string `machine:"identity"`
 	Image          string
-	MachineName    string
-	IPAddress      string
+	MachineName    string `machine:"identity"`
+	IPAddress      string `machine:"identity"`
 	Region         string
-	SSHKeyID       int
+	SSHKeyID       int `machine:"identity"`
 	Size           string
-	CaCertPath     string
-	PrivateKeyPath string
-	DriverKeyPath  string
-	SwarmMaster    bool
-	SwarmHost      string
-	SwarmDiscovery string
+	CaCertPath     string `machine:"identity"`
+	PrivateKeyPath string `machine:"identity"`
+	DriverKeyPath  string `machine:"identity"`
+	SwarmMaster    bool   `machine:"identity"`
+	SwarmHost      string `machine:"identity"`
+	SwarmDiscovery string `machine:"identity"`
 	storePath      string
+	recordStep     drivers.StepRecorder
+	waiter         *wait.Waiter
 }
 
 func init() {
 	drivers.Register("digitalocean", &drivers.RegisteredDriver{
 		New:            NewDriver,
 		GetCreateFlags: GetCreateFlags,
+		Migrations:     []drivers.Migration{drivers.MigrateV0},
 	})
 }
 
@@ -84,6 +90,11 @@ func (d *Driver) DriverName() string {
 	return "digitalocean"
 }
 
+// MachineSize returns the size of the machine for machine ls
+func (d *Driver) MachineSize() string {
+	return d.Size
+}
+
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.AccessToken = flags.String("digitalocean-access-token")
 	d.Image = flags.String("digitalocean-image")
@@ -113,6 +124,9 @@ func (d *Driver) Create() error {
 	}
 
 	d.SSHKeyID = key.ID
+	if err := drivers.RecordStep(d.recordStep, "ssh-key", strconv.Itoa(key.ID)); err != nil {
+		return err
+	}
 
 	log.Infof("Creating Digital Ocean droplet...")
 
@@ -132,11 +146,14 @@ func (d *Driver) Create() error {
 	}
 
 	d.DropletID = newDroplet.Droplet.ID
+	if err := drivers.RecordStep(d.recordStep, "droplet", strconv.Itoa(d.DropletID)); err != nil {
+		return err
+	}
 
-	for {
+	if err := d.waiter.Until("the IP address of the droplet", 0, func() (bool, error) {
 		newDroplet, _, err = client.Droplets.Get(d.DropletID)
 		if err != nil {
-			return err
+			return false, err
 		}
 		for _, network := range newDroplet.Droplet.Networks.V4 {
 			if network.Type == "public" {
@@ -144,11 +161,9 @@ func (d *Driver) Create() error {
 			}
 		}
 
-		if d.IPAddress != "" {
-			break
-		}
-
-		time.Sleep(1 * time.Second)
+		return d.IPAddress != "", nil
+	}); err != nil {
+		return err
 	}
 
 	log.Debugf("Created droplet ID %d, IP address %s",
@@ -157,24 +172,19 @@ func (d *Driver) Create() error {
 
 	log.Infof("Waiting for SSH...")
 
-	if err := ssh.WaitForTCP(fmt.Sprintf("%s:%d", d.IPAddress, 22)); err != nil {
+	if err := d.waiter.ForTCP(fmt.Sprintf("%s:%d", d.IPAddress, 22), 0); err != nil {
 		return err
 	}
 
 	log.Info("Configuring Machine...")
 
 	log.Debugf("Setting hostname: %s", d.MachineName)
-	cmd, err := d.GetSSHCommand(fmt.Sprintf(
+	if _, err := drivers.RunSSHCommandFromDriver(d, fmt.Sprintf(
 		"echo \"127.0.0.1 %s\" | sudo tee -a /etc/hosts && sudo hostname %s && echo \"%s\" | sudo tee /etc/hostname",
 		d.MachineName,
 		d.MachineName,
 		d.MachineName,
-	))
-
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	)); err != nil {
 		return err
 	}
 
@@ -182,7 +192,7 @@ func (d *Driver) Create() error {
 }
 
 func (d *Driver) createSSHKey() (*godo.Key, error) {
-	if err := ssh.GenerateSSHKey(d.sshKeyPath()); err != nil {
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
 		return nil, err
 	}
 
@@ -264,6 +274,38 @@ func (d *Driver) Remove() error {
 	return nil
 }
 
+func (d *Driver) SetStepRecorder(record drivers.StepRecorder) {
+	d.recordStep = record
+}
+
+func (d *Driver) Rollback(step drivers.CreateStep) error {
+	id, err := strconv.Atoi(step.ID)
+	if err != nil {
+		return err
+	}
+
+	client := d.getClient()
+	switch step.Resource {
+	case "droplet":
+		if resp, err := client.Droplets.Delete(id); err != nil {
+			if resp == nil || resp.StatusCode != 404 {
+				return err
+			}
+			log.Infof("Digital Ocean droplet doesn't exist, assuming it is already deleted")
+		}
+		return nil
+	case "ssh-key":
+		if resp, err := client.Keys.DeleteByID(id); err != nil {
+			if resp == nil || resp.StatusCode != 404 {
+				return err
+			}
+			log.Infof("Digital Ocean SSH key doesn't exist, assuming it is already deleted")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown resource %q", step.Resource)
+}
+
 func (d *Driver) Restart() error {
 	_, _, err := d.getClient().DropletActions.Reboot(d.DropletID)
 	return err
@@ -277,11 +319,7 @@ func (d *Driver) Kill() error {
 func (d *Driver) StartDocker() error {
 	log.Debug("Starting Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker start")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker start"); err != nil {
 		return err
 	}
 
@@ -291,11 +329,7 @@ func (d *Driver) StartDocker() error {
 func (d *Driver) StopDocker() error {
 	log.Debug("Stopping Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker stop")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker stop"); err != nil {
 		return err
 	}
 
@@ -309,22 +343,28 @@ func (d *Driver) GetDockerConfigDir() string {
 func (d *Driver) Upgrade() error {
 	log.Debugf("Upgrading Docker")
 
-	cmd, err := d.GetSSHCommand("sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
-	if err != nil {
-		return err
+	_, err := drivers.RunSSHCommandFromDriver(d, "sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
+	return err
+}
 
-	}
-	if err := cmd.Run(); err != nil {
-		return err
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.IPAddress, nil
+}
 
-	}
+func (d *Driver) GetSSHPort() (int, error) {
+	return 22, nil
+}
 
-	return cmd.Run()
+func (d *Driver) GetSSHUsername() string {
+	return "root"
 }
 
 func (d *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
-	cmd := ssh.GetSSHCommand(d.IPAddress, 22, "root", d.sshKeyPath(), args...)
-	return cmd, nil
+	return drivers.GetSSHCommandFromDriver(d, args...)
+}
+
+func (d *Driver) SetWaiter(w *wait.Waiter) {
+	d.waiter = w
 }
 
 func (d *Driver) getClient() *godo.Client {
@@ -335,10 +375,10 @@ func (d *Driver) getClient() *godo.Client {
 	return godo.NewClient(t.Client())
 }
 
-func (d *Driver) sshKeyPath() string {
+func (d *Driver) GetSSHKeyPath() string {
 	return filepath.Join(d.storePath, "id_rsa")
 }
 
 func (d *Driver) publicSSHKeyPath() string {
-	return d.sshKeyPath() + ".pub"
+	return d.GetSSHKeyPath() + ".pub"
 }
@@ -8,6 +8,7 @@ import (
 
 	"github.com/codegangsta/cli"
 	"github.com/docker/machine/state"
+	"github.com/docker/machine/wait"
 )
 
 // Driver defines how a host is created and controlled. Different types of
@@ -66,21 +67,64 @@ type Driver interface {
 	// GetDockerConfigDir returns the config directory for storing daemon configs
 	GetDockerConfigDir() string
 
+	// GetSSHHostname returns the hostname or IP to connect to with SSH
+	GetSSHHostname() (string, error)
+
+	// GetSSHPort returns the port to connect to with SSH
+	GetSSHPort() (int, error)
+
+	// GetSSHUsername returns the user to log in as with SSH
+	GetSSHUsername() string
+
+	// GetSSHKeyPath returns the private key to log in with over SSH
+	GetSSHKeyPath() string
+
 	// GetSSHCommand returns a command for SSH pointing at the correct user, host
 	// and keys for the host with args appended. If no args are passed, it will
 	// initiate an interactive SSH session as if SSH were passed no args.
+	// Drivers usually return GetSSHCommandFromDriver.
 	GetSSHCommand(args ...string) (*exec.Cmd, error)
 }
 
+// Labeler is implemented by drivers that can apply the labels of a machine to
+// its resources on the provider, e.g. as tags
+type Labeler interface {
+	// SetLabels replaces the labels of the machine. It is called before
+	// Create, so drivers should apply the labels when creating the machine
+	// and update them in place if it already exists.
+	SetLabels(labels map[string]string) error
+}
+
+// Sizer is implemented by drivers that can describe the size of a machine,
+// e.g. the memory of a VM or the instance type of a cloud machine
+type Sizer interface {
+	// MachineSize returns the size in the terms of the provider
+	MachineSize() string
+}
+
+// WaitLimited is implemented by drivers which wait for their resources. The
+// waits of the driver are bounded by the deadline and cancel of the waiter.
+type WaitLimited interface {
+	// SetWaiter is called before each operation with the waiter of the
+	// operation
+	SetWaiter(w *wait.Waiter)
+}
+
 // RegisteredDriver is used to register a driver with the Register function.
-// It has two attributes:
+// It has three attributes:
 // - New: a function that returns a new driver given a path to store host
 //   configuration in
 // - RegisterCreateFlags: a function that takes the FlagSet for
 //   "docker hosts create" and returns an object to pass to SetConfigFromFlags
+// - Migrations: the functions upgrading persisted configs of the driver,
+//   where Migrations[n] upgrades a config from version n to n+1
+// - MaxConcurrency: how many actions may run at the same time on machines
+//   of the driver, 0 means no limit
 type RegisteredDriver struct {
 	New            func(machineName string, storePath string, caCert string, privateKey string) (Driver, error)
 	GetCreateFlags func() []cli.Flag
+	Migrations     []Migration
+	MaxConcurrency int
 }
 
 var ErrHostIsNotRunning = errors.New("host is not running")
@@ -109,7 +153,15 @@ func NewDriver(name string, machineName string, storePath string, caCert string,
 	if !exists {
 		return nil, fmt.Errorf("hosts: Unknown driver %q", name)
 	}
-	return driver.New(machineName, storePath, caCert, privateKey)
+	d, err := driver.New(machineName, storePath, caCert, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	// loaded configs keep the pin they were saved with
+	if k, ok := d.(KnownHostsKeeper); ok && storePath != "" {
+		k.SetKnownHosts(KnownHostsPath(storePath), machineName)
+	}
+	return d, nil
 }
 
 // GetCreateFlags runs GetCreateFlags for all of the drivers and
@@ -129,6 +181,16 @@ func GetCreateFlags() []cli.Flag {
 	return flags
 }
 
+// GetMaxConcurrency returns how many actions may run at the same time on
+// machines of the named driver, 0 means no limit
+func GetMaxConcurrency(name string) int {
+	driver, exists := drivers[name]
+	if !exists {
+		return 0
+	}
+	return driver.MaxConcurrency
+}
+
 // GetDriverNames returns a slice of all registered driver names
 func GetDriverNames() []string {
 	names := make([]string, 0, len(drivers))
@@ -141,6 +203,7 @@ func GetDriverNames() []string {
 
 type DriverOptions interface {
 	String(key string) string
+	StringSlice(key string) []string
 	Int(key string) int
 	Bool(key string) bool
 }
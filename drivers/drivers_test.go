@@ -1,9 +1,13 @@
 package drivers
 
 import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/codegangsta/cli"
+	"github.com/docker/machine/ssh"
 )
 
 func TestGetCreateFlags(t *testing.T) {
@@ -75,3 +79,191 @@ func TestGetCreateFlags(t *testing.T) {
 		}
 	}
 }
+
+func TestMigrateConfig(t *testing.T) {
+	Register("migrating", &RegisteredDriver{
+		New: func(machineName string, storePath string, caCert string, privateKey string) (Driver, error) {
+			return nil, nil
+		},
+		GetCreateFlags: func() []cli.Flag {
+			return []cli.Flag{}
+		},
+		Migrations: []Migration{
+			func(config map[string]interface{}) error {
+				config["DiskSize"] = config["Disk"]
+				delete(config, "Disk")
+				return nil
+			},
+			func(config map[string]interface{}) error {
+				config["Memory"] = 1024
+				return nil
+			},
+		},
+	})
+
+	if v := ConfigVersion("migrating"); v != 2 {
+		t.Fatalf("expected config version 2; received %d", v)
+	}
+
+	config := map[string]interface{}{"Disk": 20000}
+	v, err := MigrateConfig("migrating", config, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 {
+		t.Fatalf("expected migration to version 2; received %d", v)
+	}
+	if config["DiskSize"] != 20000 || config["Memory"] != 1024 {
+		t.Fatalf("unexpected migrated config: %v", config)
+	}
+	if _, ok := config["Disk"]; ok {
+		t.Fatal("expected Disk to be removed")
+	}
+
+	// only the remaining migrations run
+	config = map[string]interface{}{}
+	if _, err := MigrateConfig("migrating", config, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config["DiskSize"]; ok {
+		t.Fatal("expected first migration to be skipped")
+	}
+
+	if _, err := MigrateConfig("migrating", config, 3); err == nil {
+		t.Fatal("expected an error for a config newer than supported")
+	}
+}
+
+type secretClient struct {
+	User   string
+	ApiKey string `machine:"secret"`
+}
+
+type secretBase struct {
+	Password string `machine:"secret"`
+}
+
+type secretDriver struct {
+	*secretBase
+	Client      *secretClient
+	AccessToken string `json:"token" machine:"secret"`
+	Region      string
+	InstanceId  string `machine:"identity"`
+	password    string
+}
+
+func TestSecretFields(t *testing.T) {
+	var d struct{ Driver }
+	if fields := SecretFields(d.Driver); fields != nil {
+		t.Fatalf("expected no secret fields for nil driver; received %v", fields)
+	}
+
+	fields := taggedFields(reflect.TypeOf(&secretDriver{}), secretTag, nil, 0)
+
+	expected := [][]string{
+		{"Password"},
+		{"Client", "ApiKey"},
+		{"token"},
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected secret fields %v; received %v", expected, fields)
+	}
+}
+
+func TestIdentityFields(t *testing.T) {
+	fields := taggedFields(reflect.TypeOf(&secretDriver{}), identityTag, nil, 0)
+
+	expected := [][]string{{"InstanceId"}}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected identity fields %v; received %v", expected, fields)
+	}
+}
+
+type sshDriver struct {
+	Driver
+	KnownHosts
+}
+
+func (d *sshDriver) GetSSHHostname() (string, error) {
+	return "10.0.0.1", nil
+}
+
+func (d *sshDriver) GetSSHPort() (int, error) {
+	return 22, nil
+}
+
+func (d *sshDriver) GetSSHUsername() string {
+	return "docker"
+}
+
+func (d *sshDriver) GetSSHKeyPath() string {
+	return "/tmp/id_rsa"
+}
+
+func TestGetSSHAddressFromDriverKnownHosts(t *testing.T) {
+	Register("ssh", &RegisteredDriver{
+		New: func(machineName string, storePath string, caCert string, privateKey string) (Driver, error) {
+			return &sshDriver{}, nil
+		},
+		GetCreateFlags: func() []cli.Flag { return nil },
+	})
+
+	storePath := filepath.Join("machines", "dev")
+	d, err := NewDriver("ssh", "dev", storePath, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := GetSSHAddressFromDriver(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ssh.Address{
+		User:           "docker",
+		Host:           "10.0.0.1",
+		Port:           22,
+		KeyPath:        "/tmp/id_rsa",
+		KnownHostsFile: filepath.Join(storePath, "known_hosts"),
+		HostKeyAlias:   "dev",
+	}
+	if *addr != expected {
+		t.Fatalf("expected address %+v; received %+v", expected, *addr)
+	}
+
+	// the pin is kept with the config of the driver, e.g. of an imported
+	// machine whose known hosts still name it as it was exported
+	data, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := NewDriver("ssh", "imported", filepath.Join("machines", "imported"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if addr, err = GetSSHAddressFromDriver(reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if *addr != expected {
+		t.Fatalf("expected reloaded address %+v; received %+v", expected, *addr)
+	}
+
+	// the pin is not copied when cloning the machine
+	if fields := IdentityFields(d); !reflect.DeepEqual(fields, [][]string{{"KnownHostsFile"}, {"HostKeyAlias"}}) {
+		t.Fatalf("expected the known hosts to be identity fields; received %v", fields)
+	}
+
+	// a driver without a store path has no known hosts
+	d, err = NewDriver("ssh", "dev", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err = GetSSHAddressFromDriver(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.KnownHostsFile != "" {
+		t.Fatalf("expected no known hosts file; received %s", addr.KnownHostsFile)
+	}
+}
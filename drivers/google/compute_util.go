@@ -5,10 +5,10 @@ import (
 	"io/ioutil"
 	"net/url"
 	"strings"
-	"time"
 
 	log "github.com/Sirupsen/logrus"
-	"github.com/docker/machine/ssh"
+	"github.com/docker/machine/drivers"
+	"github.com/docker/machine/wait"
 	raw "google.golang.org/api/compute/v1"
 )
 
@@ -24,6 +24,7 @@ type ComputeUtil struct {
 	ipAddress    string
 	SwarmMaster  bool
 	SwarmHost    string
+	waiter       *wait.Waiter
 }
 
 const (
@@ -51,6 +52,7 @@ func newComputeUtil(driver *Driver) (*ComputeUtil, error) {
 		service:      service,
 		zoneURL:      apiURL + driver.Project + "/zones/" + driver.Zone,
 		globalURL:    apiURL + driver.Project + "/global",
+		waiter:       driver.waiter,
 		SwarmMaster:  driver.SwarmMaster,
 		SwarmHost:    driver.SwarmHost,
 	}
@@ -227,17 +229,12 @@ func (c *ComputeUtil) createInstance(d *Driver) error {
 	log.Info("Configuring Machine...")
 
 	log.Debugf("Setting hostname: %s", d.MachineName)
-	cmd, err := d.GetSSHCommand(fmt.Sprintf(
+	if _, err := drivers.RunSSHCommandFromDriver(d, fmt.Sprintf(
 		"echo \"127.0.0.1 %s\" | sudo tee -a /etc/hosts && sudo hostname %s && echo \"%s\" | sudo tee /etc/hostname",
 		d.MachineName,
 		d.MachineName,
 		d.MachineName,
-	))
-
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	)); err != nil {
 		return err
 	}
 
@@ -247,14 +244,8 @@ func (c *ComputeUtil) createInstance(d *Driver) error {
 func (c *ComputeUtil) updateDocker(d *Driver) error {
 	log.Debugf("Upgrading Docker")
 
-	cmd, err := d.GetSSHCommand("sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
-	if err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo apt-get update && sudo apt-get install --upgrade lxc-docker"); err != nil {
 		return err
-
-	}
-	if err := cmd.Run(); err != nil {
-		return err
-
 	}
 
 	return nil
@@ -271,32 +262,21 @@ func (c *ComputeUtil) deleteInstance() error {
 	return c.waitForRegionalOp(op.Name)
 }
 
-func (c *ComputeUtil) executeCommands(commands []string, ip, sshKeyPath string) error {
-	for _, command := range commands {
-		cmd := ssh.GetSSHCommand(ip, 22, c.userName, sshKeyPath, command)
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("error executing command: %v %v", command, err)
-		}
-	}
-	return nil
-}
-
 func (c *ComputeUtil) waitForOp(opGetter func() (*raw.Operation, error)) error {
-	for {
+	return c.waiter.Until("the operation to finish", 0, func() (bool, error) {
 		op, err := opGetter()
 		if err != nil {
-			return err
+			return false, err
 		}
 		log.Debugf("operation %q status: %s", op.Name, op.Status)
 		if op.Status == "DONE" {
 			if op.Error != nil {
-				return fmt.Errorf("Operation error: %v", *op.Error.Errors[0])
+				return false, fmt.Errorf("Operation error: %v", *op.Error.Errors[0])
 			}
-			break
+			return true, nil
 		}
-		time.Sleep(1 * time.Second)
-	}
-	return nil
+		return false, nil
+	})
 }
 
 // waitForOp waits for the GCE Operation to finish.
@@ -315,7 +295,7 @@ func (c *ComputeUtil) waitForGlobalOp(name string) error {
 // waitForSSH waits for SSH to become ready on the instance.
 func (c *ComputeUtil) waitForSSH(ip string) error {
 	log.Infof("Waiting for SSH...")
-	return ssh.WaitForTCP(fmt.Sprintf("%s:22", ip))
+	return c.waiter.ForTCP(fmt.Sprintf("%s:22", ip), 0)
 }
 
 // ip retrieves and returns the external IP address of the instance.
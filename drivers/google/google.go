@@ -12,6 +12,7 @@ import (
 	"github.com/codegangsta/cli"
 	"github.com/docker/machine/drivers"
 	"github.com/docker/machine/ssh"
+	"github.com/docker/machine/wait"
 )
 
 const (
@@ -20,7 +21,9 @@ const (
 
 // Driver is a struct compatible with the docker.hosts.drivers.Driver interface.
 type Driver struct {
-	MachineName      string
+	drivers.KnownHosts
+
+	MachineName      string `machine:"identity"`
 	Zone             string
 	MachineType      string
 	Scopes           string
@@ -28,13 +31,14 @@ type Driver struct {
 	storePath        string
 	UserName         string
 	Project          string
-	CaCertPath       string
-	PrivateKeyPath   string
+	CaCertPath       string `machine:"identity"`
+	PrivateKeyPath   string `machine:"identity"`
 	sshKeyPath       string
 	publicSSHKeyPath string
-	SwarmMaster      bool
-	SwarmHost        string
-	SwarmDiscovery   string
+	SwarmMaster      bool   `machine:"identity"`
+	SwarmHost        string `machine:"identity"`
+	SwarmDiscovery   string `machine:"identity"`
+	waiter           *wait.Waiter
 }
 
 // CreateFlags are the command line flags used to create a driver.
@@ -51,6 +55,7 @@ func init() {
 	drivers.Register("google", &drivers.RegisteredDriver{
 		New:            NewDriver,
 		GetCreateFlags: GetCreateFlags,
+		Migrations:     []drivers.Migration{drivers.MigrateV0},
 	})
 }
 
@@ -113,6 +118,11 @@ func (driver *Driver) DriverName() string {
 	return "google"
 }
 
+// MachineSize returns the size of the machine for machine ls
+func (driver *Driver) MachineSize() string {
+	return driver.MachineType
+}
+
 // SetConfigFromFlags initializes the driver based on the command line flags.
 func (driver *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	driver.Zone = flags.String("google-zone")
@@ -264,11 +274,7 @@ func (driver *Driver) Kill() error {
 func (d *Driver) StartDocker() error {
 	log.Debug("Starting Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker start")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker start"); err != nil {
 		return err
 	}
 
@@ -278,11 +284,7 @@ func (d *Driver) StartDocker() error {
 func (d *Driver) StopDocker() error {
 	log.Debug("Stopping Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker stop")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker stop"); err != nil {
 		return err
 	}
 
@@ -294,12 +296,28 @@ func (d *Driver) GetDockerConfigDir() string {
 }
 
 // GetSSHCommand returns a command that will run over SSH on the GCE instance.
+func (driver *Driver) GetSSHHostname() (string, error) {
+	return driver.GetIP()
+}
+
+func (driver *Driver) GetSSHPort() (int, error) {
+	return 22, nil
+}
+
+func (driver *Driver) GetSSHKeyPath() string {
+	return driver.sshKeyPath
+}
+
+func (driver *Driver) GetSSHUsername() string {
+	return driver.UserName
+}
+
 func (driver *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
-	ip, err := driver.GetIP()
-	if err != nil {
-		return nil, err
-	}
-	return ssh.GetSSHCommand(ip, 22, driver.UserName, driver.sshKeyPath, args...), nil
+	return drivers.GetSSHCommandFromDriver(driver, args...)
+}
+
+func (driver *Driver) SetWaiter(w *wait.Waiter) {
+	driver.waiter = w
 }
 
 // Upgrade upgrades the docker daemon on the host to the latest version.
@@ -8,7 +8,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
@@ -16,6 +15,7 @@ import (
 	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/state"
 	"github.com/docker/machine/utils"
+	"github.com/docker/machine/wait"
 )
 
 const (
@@ -23,25 +23,29 @@ const (
 )
 
 type Driver struct {
+	drivers.KnownHosts
+
 	storePath      string
 	boot2DockerURL string
 	boot2DockerLoc string
 	vSwitch        string
-	MachineName    string
+	MachineName    string `machine:"identity"`
 	diskImage      string
 	diskSize       int
 	memSize        int
-	CaCertPath     string
-	PrivateKeyPath string
-	SwarmMaster    bool
-	SwarmHost      string
-	SwarmDiscovery string
+	CaCertPath     string `machine:"identity"`
+	PrivateKeyPath string `machine:"identity"`
+	SwarmMaster    bool   `machine:"identity"`
+	SwarmHost      string `machine:"identity"`
+	SwarmDiscovery string `machine:"identity"`
+	waiter         *wait.Waiter
 }
 
 func init() {
 	drivers.Register("hyper-v", &drivers.RegisteredDriver{
 		New:            NewDriver,
 		GetCreateFlags: GetCreateFlags,
+		Migrations:     []drivers.Migration{drivers.MigrateV0},
 	})
 }
 
@@ -195,7 +199,7 @@ func (d *Driver) Create() error {
 
 	log.Infof("Creating SSH key...")
 
-	if err := ssh.GenerateSSHKey(d.sshKeyPath()); err != nil {
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
 		return err
 	}
 
@@ -254,18 +258,12 @@ func (d *Driver) Create() error {
 	}
 
 	log.Infof("Setting hostname...")
-	cmd, err := d.GetSSHCommand(fmt.Sprintf(
+	if _, err := drivers.RunSSHCommandFromDriver(d, fmt.Sprintf(
 		"sudo hostname %s && echo \"%s\" | sudo tee /var/lib/boot2docker/etc/hostname",
 		d.MachineName,
 		d.MachineName,
-	))
-	if err != nil {
-		return err
-
-	}
-	if err := cmd.Run(); err != nil {
+	)); err != nil {
 		return err
-
 	}
 
 	return nil
@@ -291,19 +289,18 @@ func (d *Driver) chooseVirtualSwitch() (string, error) {
 
 func (d *Driver) wait() error {
 	log.Infof("Waiting for host to start...")
-	for {
+	if err := d.waiter.Until("the IP of the host", 0, func() (bool, error) {
 		ip, _ := d.GetIP()
-		if ip != "" {
-			break
-		}
-		time.Sleep(1 * time.Second)
+		return ip != "", nil
+	}); err != nil {
+		return err
 	}
 	log.Infof("Got IP, waiting for SSH")
 	ip, err := d.GetIP()
 	if err != nil {
 		return err
 	}
-	return ssh.WaitForTCP(fmt.Sprintf("%s:22", ip))
+	return d.waiter.ForTCP(fmt.Sprintf("%s:22", ip), 0)
 }
 
 func (d *Driver) Start() error {
@@ -325,18 +322,13 @@ func (d *Driver) Stop() error {
 	if err != nil {
 		return err
 	}
-	for {
+	return d.waiter.Until("the VM to stop", 0, func() (bool, error) {
 		s, err := d.GetState()
 		if err != nil {
-			return err
+			return false, err
 		}
-		if s == state.Running {
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
-	}
-	return nil
+		return s != state.Running, nil
+	})
 }
 
 func (d *Driver) Remove() error {
@@ -375,18 +367,13 @@ func (d *Driver) Kill() error {
 	if err != nil {
 		return err
 	}
-	for {
+	return d.waiter.Until("the VM to stop", 0, func() (bool, error) {
 		s, err := d.GetState()
 		if err != nil {
-			return err
+			return false, err
 		}
-		if s == state.Running {
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
-	}
-	return nil
+		return s != state.Running, nil
+	})
 }
 
 func (d *Driver) setMachineNameIfNotSet() {
@@ -412,12 +399,24 @@ func (d *Driver) GetIP() (string, error) {
 	return resp[0], nil
 }
 
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+func (d *Driver) GetSSHPort() (int, error) {
+	return 22, nil
+}
+
+func (d *Driver) GetSSHUsername() string {
+	return "docker"
+}
+
 func (d *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
-	ip, err := d.GetIP()
-	if err != nil {
-		return nil, err
-	}
-	return ssh.GetSSHCommand(ip, 22, "docker", d.sshKeyPath(), args...), nil
+	return drivers.GetSSHCommandFromDriver(d, args...)
+}
+
+func (d *Driver) SetWaiter(w *wait.Waiter) {
+	d.waiter = w
 }
 
 func (d *Driver) Upgrade() error {
@@ -448,11 +447,7 @@ func (d *Driver) Upgrade() error {
 func (d *Driver) StartDocker() error {
 	log.Debug("Starting Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo /etc/init.d/docker start")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo /etc/init.d/docker start"); err != nil {
 		return err
 	}
 
@@ -462,11 +457,7 @@ func (d *Driver) StartDocker() error {
 func (d *Driver) StopDocker() error {
 	log.Debug("Stopping Docker...")
 
-	cmd, err := d.GetSSHCommand("if [ -e /var/run/docker.pid ]; then sudo /etc/init.d/docker stop ; fi")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "if [ -e /var/run/docker.pid ]; then sudo /etc/init.d/docker stop ; fi"); err != nil {
 		return err
 	}
 
@@ -477,12 +468,12 @@ func (d *Driver) GetDockerConfigDir() string {
 	return dockerConfigDir
 }
 
-func (d *Driver) sshKeyPath() string {
+func (d *Driver) GetSSHKeyPath() string {
 	return filepath.Join(d.storePath, "id_rsa")
 }
 
 func (d *Driver) publicSSHKeyPath() string {
-	return d.sshKeyPath() + ".pub"
+	return d.GetSSHKeyPath() + ".pub"
 }
 
 func (d *Driver) generateDiskImage() error {
@@ -0,0 +1,23 @@
+package drivers
+
+import (
+	"reflect"
+)
+
+// identityTag marks driver fields which identify the machine a driver
+// created or which are derived from the host, rather than set by its
+// options, e.g.
+//
+//	InstanceId string `machine:"identity"`
+//
+// Identity fields are not copied when a machine is created from another.
+const identityTag = "identity"
+
+// IdentityFields returns the paths of the identity fields of a driver in its
+// JSON representation
+func IdentityFields(d Driver) [][]string {
+	if d == nil {
+		return nil
+	}
+	return taggedFields(reflect.TypeOf(d), identityTag, nil, 0)
+}
@@ -0,0 +1,68 @@
+package drivers
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Migration upgrades the persisted config of a driver by one version. The
+// config is the decoded JSON object of the driver struct and is modified in
+// place.
+type Migration func(config map[string]interface{}) error
+
+// MigrateV0 is the first migration of the drivers whose configs were
+// persisted before driver configs were versioned. Their layout is unchanged:
+// the fields added since decode as their zero values, and secret fields
+// which are not encrypted are read as they are. The migration only gives
+// those configs a version, so that later changes of the driver can be
+// migrated.
+func MigrateV0(config map[string]interface{}) error {
+	return nil
+}
+
+// ConfigVersion returns the current config version of the named driver,
+// which is the number of migrations it has registered
+func ConfigVersion(name string) int {
+	driver, exists := drivers[name]
+	if !exists {
+		return 0
+	}
+	return len(driver.Migrations)
+}
+
+// MigrateConfig runs the migrations of the named driver on a config that was
+// persisted at version from and returns the version it was migrated to
+func MigrateConfig(name string, config map[string]interface{}, from int) (int, error) {
+	driver, exists := drivers[name]
+	if !exists {
+		return from, fmt.Errorf("hosts: Unknown driver %q", name)
+	}
+
+	if from > len(driver.Migrations) {
+		return from, fmt.Errorf("%s config version %d is newer than the supported version %d; please upgrade machine",
+			name, from, len(driver.Migrations))
+	}
+
+	for v := from; v < len(driver.Migrations); v++ {
+		if err := driver.Migrations[v](config); err != nil {
+			return v, fmt.Errorf("error migrating %s config from version %d: %s", name, v, err)
+		}
+	}
+
+	return len(driver.Migrations), nil
+}
+
+// GetConfigInt returns the integer stored at key in a decoded config, or 0 if
+// it is not set. Configs should be decoded with UseNumber.
+func GetConfigInt(config map[string]interface{}, key string) (int, error) {
+	switch v := config[key].(type) {
+	case nil:
+		return 0, nil
+	case json.Number:
+		i, err := v.Int64()
+		return int(i), err
+	case float64:
+		return int(v), nil
+	}
+	return 0, fmt.Errorf("invalid value for %s: %v", key, config[key])
+}
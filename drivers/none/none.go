@@ -1,6 +1,7 @@
 package none
 
 import (
+	"errors"
 	"fmt"
 	"os/exec"
 
@@ -17,6 +18,8 @@ type Driver struct {
 	URL string
 }
 
+var errNoSSH = errors.New("hosts without a driver do not support SSH")
+
 func init() {
 	drivers.Register("none", &drivers.RegisteredDriver{
 		New:            NewDriver,
@@ -113,6 +116,22 @@ func (d *Driver) Upgrade() error {
 	return fmt.Errorf("hosts without a driver cannot be upgraded")
 }
 
+func (d *Driver) GetSSHHostname() (string, error) {
+	return "", errNoSSH
+}
+
+func (d *Driver) GetSSHPort() (int, error) {
+	return 0, errNoSSH
+}
+
+func (d *Driver) GetSSHUsername() string {
+	return ""
+}
+
+func (d *Driver) GetSSHKeyPath() string {
+	return ""
+}
+
 func (d *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
-	return nil, fmt.Errorf("hosts without a driver do not support SSH")
+	return nil, errNoSSH
 }
@@ -2,7 +2,9 @@ package openstack
 
 import (
 	"crypto/tls"
+	"fmt"
 	"net/http"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/rackspace/gophercloud"
@@ -131,10 +133,13 @@ func (c *GenericClient) DeleteInstance(d *Driver) error {
 }
 
 func (c *GenericClient) WaitForInstanceStatus(d *Driver, status string, timeout int) error {
-	if err := servers.WaitForStatus(c.Compute, d.MachineId, status, timeout); err != nil {
-		return err
-	}
-	return nil
+	return d.waiter.Until(fmt.Sprintf("the instance to be %s", status), time.Duration(timeout)*time.Second, func() (bool, error) {
+		current, err := c.GetInstanceState(d)
+		if err != nil {
+			return false, err
+		}
+		return current == status, nil
+	})
 }
 
 func (c *GenericClient) GetInstanceIpAddresses(d *Driver) ([]IpAddress, error) {
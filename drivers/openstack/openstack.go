@@ -14,6 +14,7 @@ import (
 	"github.com/docker/machine/drivers"
 	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/state"
+	"github.com/docker/machine/wait"
 )
 
 const (
@@ -21,21 +22,23 @@ const (
 )
 
 type Driver struct {
+	drivers.KnownHosts
+
 	AuthUrl          string
 	Insecure         bool
 	Username         string
-	Password         string
+	Password         string `machine:"secret"`
 	TenantName       string
 	TenantId         string
 	Region           string
 	EndpointType     string
-	MachineName      string
-	MachineId        string
+	MachineName      string `machine:"identity"`
+	MachineId        string `machine:"identity"`
 	FlavorName       string
 	FlavorId         string
 	ImageName        string
 	ImageId          string
-	KeyPairName      string
+	KeyPairName      string `machine:"identity"`
 	NetworkName      string
 	NetworkId        string
 	SecurityGroups   []string
@@ -43,14 +46,15 @@ type Driver struct {
 	FloatingIpPoolId string
 	SSHUser          string
 	SSHPort          int
-	Ip               string
-	CaCertPath       string
-	PrivateKeyPath   string
+	Ip               string `machine:"identity"`
+	CaCertPath       string `machine:"identity"`
+	PrivateKeyPath   string `machine:"identity"`
 	storePath        string
-	SwarmMaster      bool
-	SwarmHost        string
-	SwarmDiscovery   string
+	SwarmMaster      bool   `machine:"identity"`
+	SwarmHost        string `machine:"identity"`
+	SwarmDiscovery   string `machine:"identity"`
 	client           Client
+	waiter           *wait.Waiter
 }
 
 type CreateFlags struct {
@@ -78,6 +82,7 @@ func init() {
 	drivers.Register("openstack", &drivers.RegisteredDriver{
 		New:            NewDriver,
 		GetCreateFlags: GetCreateFlags,
+		Migrations:     []drivers.Migration{drivers.MigrateV0},
 	})
 }
 
@@ -207,6 +212,14 @@ func (d *Driver) DriverName() string {
 	return "openstack"
 }
 
+// MachineSize returns the size of the machine for machine ls
+func (d *Driver) MachineSize() string {
+	if d.FlavorName != "" {
+		return d.FlavorName
+	}
+	return d.FlavorId
+}
+
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.AuthUrl = flags.String("openstack-auth-url")
 	d.Insecure = flags.Bool("openstack-insecure")
@@ -263,19 +276,23 @@ func (d *Driver) GetIP() (string, error) {
 	}
 
 	// Looking for the IP address in a retry loop to deal with OpenStack latency
-	for retryCount := 0; retryCount < 200; retryCount++ {
+	var ip string
+	if err := d.waiter.Until("the IP address of the instance", 400*time.Second, func() (bool, error) {
 		addresses, err := d.client.GetInstanceIpAddresses(d)
 		if err != nil {
-			return "", err
+			return false, err
 		}
 		for _, a := range addresses {
 			if a.AddressType == addressType {
-				return a.Address, nil
+				ip = a.Address
+				return true, nil
 			}
 		}
-		time.Sleep(2 * time.Second)
+		return false, nil
+	}); err != nil {
+		return "", fmt.Errorf("No IP found for the machine: %s", err)
 	}
-	return "", fmt.Errorf("No IP found for the machine")
+	return ip, nil
 }
 
 func (d *Driver) GetState() (state.State, error) {
@@ -405,27 +422,14 @@ func (d *Driver) Kill() error {
 func (d *Driver) Upgrade() error {
 	log.Debugf("Upgrading Docker")
 
-	cmd, err := d.GetSSHCommand("sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
-	if err != nil {
-		return err
-
-	}
-	if err := cmd.Run(); err != nil {
-		return err
-
-	}
-
-	return cmd.Run()
+	_, err := drivers.RunSSHCommandFromDriver(d, "sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
+	return err
 }
 
 func (d *Driver) StartDocker() error {
 	log.Debug("Starting Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker start")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker start"); err != nil {
 		return err
 	}
 
@@ -435,11 +439,7 @@ func (d *Driver) StartDocker() error {
 func (d *Driver) StopDocker() error {
 	log.Debug("Stopping Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker stop")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker stop"); err != nil {
 		return err
 	}
 
@@ -450,19 +450,30 @@ func (d *Driver) GetDockerConfigDir() string {
 	return dockerConfigDir
 }
 
-func (d *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
-	ip, err := d.GetIP()
-	if err != nil {
-		return nil, err
-	}
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+func (d *Driver) GetSSHPort() (int, error) {
+	return d.SSHPort, nil
+}
 
+func (d *Driver) GetSSHUsername() string {
+	return d.SSHUser
+}
+
+func (d *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
 	if len(args) != 0 && d.SSHUser != "root" {
 		cmd := strings.Replace(strings.Join(args, " "), "'", "\\'", -1)
 		args = []string{"sudo", "sh", "-c", fmt.Sprintf("'%s'", cmd)}
 	}
 
 	log.WithField("MachineId", d.MachineId).Debug("Command: %s", args)
-	return ssh.GetSSHCommand(ip, d.SSHPort, d.SSHUser, d.sshKeyPath(), args...), nil
+	return drivers.GetSSHCommandFromDriver(d, args...)
+}
+
+func (d *Driver) SetWaiter(w *wait.Waiter) {
+	d.waiter = w
 }
 
 const (
@@ -624,7 +635,7 @@ func (d *Driver) initNetwork() error {
 
 func (d *Driver) createSSHKey() error {
 	log.WithField("Name", d.KeyPairName).Debug("Creating Key Pair...")
-	if err := ssh.GenerateSSHKey(d.sshKeyPath()); err != nil {
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
 		return err
 	}
 	publicKey, err := ioutil.ReadFile(d.publicSSHKeyPath())
@@ -736,7 +747,7 @@ func (d *Driver) waitForSSHServer() error {
 		"MachineId": d.MachineId,
 		"IP":        ip,
 	}).Debug("Waiting for the SSH server to be started...")
-	return ssh.WaitForTCP(fmt.Sprintf("%s:%d", ip, d.SSHPort))
+	return d.waiter.ForTCP(fmt.Sprintf("%s:%d", ip, d.SSHPort), 0)
 }
 
 func (d *Driver) waitForInstanceToStart() error {
@@ -748,21 +759,17 @@ func (d *Driver) waitForInstanceToStart() error {
 
 func (d *Driver) sshExec(commands []string) error {
 	for _, command := range commands {
-		sshCmd, err := d.GetSSHCommand(command)
-		if err != nil {
-			return err
-		}
-		if err := sshCmd.Run(); err != nil {
+		if _, err := drivers.RunSSHCommandFromDriver(d, command); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (d *Driver) sshKeyPath() string {
+func (d *Driver) GetSSHKeyPath() string {
 	return path.Join(d.storePath, "id_rsa")
 }
 
 func (d *Driver) publicSSHKeyPath() string {
-	return d.sshKeyPath() + ".pub"
+	return d.GetSSHKeyPath() + ".pub"
 }
@@ -17,7 +17,7 @@ const (
 type Driver struct {
 	*openstack.Driver
 
-	APIKey string
+	APIKey string `machine:"secret"`
 }
 
 // CreateFlags stores the command-line arguments given to "machine create".
@@ -42,6 +42,7 @@ func init() {
 	drivers.Register("rackspace", &drivers.RegisteredDriver{
 		New:            NewDriver,
 		GetCreateFlags: GetCreateFlags,
+		Migrations:     []drivers.Migration{drivers.MigrateV0},
 	})
 }
 
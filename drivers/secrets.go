@@ -0,0 +1,75 @@
+package drivers
+
+import (
+	"reflect"
+	"strings"
+)
+
+// secretTag marks driver fields holding credentials, e.g.
+//
+//	SecretKey string `machine:"secret"`
+//
+// Secret fields are encrypted when the config of a host is persisted and
+// redacted when it is displayed.
+const secretTag = "secret"
+
+// SecretFields returns the paths of the secret fields of a driver in its
+// JSON representation, e.g. ["Client", "ApiKey"] for a field nested in a
+// struct.
+func SecretFields(d Driver) [][]string {
+	if d == nil {
+		return nil
+	}
+	return taggedFields(reflect.TypeOf(d), secretTag, nil, 0)
+}
+
+// taggedFields returns the paths of the fields of t with the machine tag
+func taggedFields(t reflect.Type, tag string, prefix []string, depth int) [][]string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || depth > 3 {
+		return nil
+	}
+
+	fields := [][]string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			// unexported
+			continue
+		}
+
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+
+		if f.Tag.Get("machine") == tag {
+			fields = append(fields, appendPath(prefix, name))
+			continue
+		}
+
+		// embedded structs are flattened in JSON
+		if f.Anonymous && f.Tag.Get("json") == "" {
+			fields = append(fields, taggedFields(f.Type, tag, prefix, depth+1)...)
+			continue
+		}
+
+		fields = append(fields, taggedFields(f.Type, tag, appendPath(prefix, name), depth+1)...)
+	}
+
+	return fields
+}
+
+func appendPath(prefix []string, name string) []string {
+	path := make([]string, len(prefix), len(prefix)+1)
+	copy(path, prefix)
+	return append(path, name)
+}
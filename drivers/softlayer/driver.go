@@ -14,6 +14,7 @@ import (
 	"github.com/docker/machine/drivers"
 	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/state"
+	"github.com/docker/machine/wait"
 )
 
 const (
@@ -22,17 +23,20 @@ const (
 )
 
 type Driver struct {
+	drivers.KnownHosts
+
 	storePath      string
-	IPAddress      string
+	IPAddress      string `machine:"identity"`
 	deviceConfig   *deviceConfig
-	Id             int
+	Id             int `machine:"identity"`
 	Client         *Client
-	MachineName    string
-	CaCertPath     string
-	PrivateKeyPath string
-	SwarmMaster    bool
-	SwarmHost      string
-	SwarmDiscovery string
+	MachineName    string `machine:"identity"`
+	CaCertPath     string `machine:"identity"`
+	PrivateKeyPath string `machine:"identity"`
+	SwarmMaster    bool   `machine:"identity"`
+	SwarmHost      string `machine:"identity"`
+	SwarmDiscovery string `machine:"identity"`
+	waiter         *wait.Waiter
 }
 
 type deviceConfig struct {
@@ -52,6 +56,7 @@ func init() {
 	drivers.Register("softlayer", &drivers.RegisteredDriver{
 		New:            NewDriver,
 		GetCreateFlags: GetCreateFlags,
+		Migrations:     []drivers.Migration{drivers.MigrateV0},
 	})
 }
 
@@ -219,11 +224,7 @@ func (d *Driver) DriverName() string {
 func (d *Driver) StartDocker() error {
 	log.Debug("Starting Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker start")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker start"); err != nil {
 		return err
 	}
 
@@ -233,11 +234,7 @@ func (d *Driver) StartDocker() error {
 func (d *Driver) StopDocker() error {
 	log.Debug("Stopping Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker stop")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker stop"); err != nil {
 		return err
 	}
 
@@ -283,8 +280,24 @@ func (d *Driver) GetState() (state.State, error) {
 	return vmState, nil
 }
 
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.IPAddress, nil
+}
+
+func (d *Driver) GetSSHPort() (int, error) {
+	return 22, nil
+}
+
+func (d *Driver) GetSSHUsername() string {
+	return "root"
+}
+
 func (d *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
-	return ssh.GetSSHCommand(d.IPAddress, 22, "root", d.sshKeyPath(), args...), nil
+	return drivers.GetSSHCommandFromDriver(d, args...)
+}
+
+func (d *Driver) SetWaiter(w *wait.Waiter) {
+	d.waiter = w
 }
 
 func (d *Driver) PreCreateCheck() error {
@@ -292,24 +305,20 @@ func (d *Driver) PreCreateCheck() error {
 }
 
 func (d *Driver) Create() error {
-	waitForStart := func() {
+	waitForStart := func() error {
 		log.Infof("Waiting for host to become available")
-		for {
+		return d.waiter.Until("the host to become available", 0, func() (bool, error) {
 			s, err := d.GetState()
 			if err != nil {
-				continue
-			}
-
-			if s == state.Running {
-				break
+				return false, nil
 			}
-			time.Sleep(2 * time.Second)
-		}
+			return s == state.Running, nil
+		})
 	}
 
-	getIp := func() {
+	getIp := func() error {
 		log.Infof("Getting Host IP")
-		for {
+		return d.waiter.Until("the IP of the host", 0, func() (bool, error) {
 			var (
 				ip  string
 				err error
@@ -320,17 +329,16 @@ func (d *Driver) Create() error {
 				ip, err = d.getClient().VirtualGuest().GetPublicIp(d.Id)
 			}
 			if err != nil {
-				time.Sleep(2 * time.Second)
-				continue
+				return false, nil
 			}
 			// not a perfect regex, but should be just fine for our needs
 			exp := regexp.MustCompile(`\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}`)
 			if exp.MatchString(ip) {
 				d.IPAddress = ip
-				break
+				return true, nil
 			}
-			time.Sleep(2 * time.Second)
-		}
+			return false, nil
+		})
 	}
 
 	log.Infof("Creating SSH key...")
@@ -347,9 +355,15 @@ func (d *Driver) Create() error {
 		return fmt.Errorf("Error creating host: %q", err)
 	}
 	d.Id = id
-	getIp()
-	waitForStart()
-	ssh.WaitForTCP(d.IPAddress + ":22")
+	if err := getIp(); err != nil {
+		return err
+	}
+	if err := waitForStart(); err != nil {
+		return err
+	}
+	if err := d.waiter.ForTCP(d.IPAddress+":22", 0); err != nil {
+		return err
+	}
 	if err := d.setupHost(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error setting up host config: %q", err)
 	}
@@ -374,7 +388,7 @@ func (d *Driver) buildHostSpec() *HostSpec {
 }
 
 func (d *Driver) createSSHKey() (*SshKey, error) {
-	if err := ssh.GenerateSSHKey(d.sshKeyPath()); err != nil {
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
 		return nil, err
 	}
 
@@ -392,10 +406,10 @@ func (d *Driver) createSSHKey() (*SshKey, error) {
 }
 
 func (d *Driver) publicSSHKeyPath() string {
-	return d.sshKeyPath() + ".pub"
+	return d.GetSSHKeyPath() + ".pub"
 }
 
-func (d *Driver) sshKeyPath() string {
+func (d *Driver) GetSSHKeyPath() string {
 	return path.Join(d.storePath, "id_rsa")
 }
 
@@ -406,7 +420,9 @@ func (d *Driver) Remove() error {
 	var err error
 	for i := 0; i < 5; i++ {
 		if err = d.getClient().VirtualGuest().Cancel(d.Id); err != nil {
-			time.Sleep(2 * time.Second)
+			if err := d.waiter.Sleep(2 * time.Second); err != nil {
+				return err
+			}
 			continue
 		}
 		break
@@ -426,33 +442,18 @@ func (d *Driver) Stop() error {
 func (d *Driver) Upgrade() error {
 	log.Debugf("Upgrading Docker")
 
-	cmd, err := d.GetSSHCommand("sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
-	if err != nil {
-		return err
-
-	}
-	if err := cmd.Run(); err != nil {
-		return err
-
-	}
-
-	return cmd.Run()
+	_, err := drivers.RunSSHCommandFromDriver(d, "sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
+	return err
 }
 
 func (d *Driver) setupHost() error {
 	log.Infof("Configuring host OS")
-	ssh.WaitForTCP(d.IPAddress + ":22")
-	// Wait to make sure docker is installed
-	for {
-		cmd, err := d.GetSSHCommand(`[ -f "$(which docker)" ] && [ -f "/etc/default/docker" ] || exit 1`)
-		if err != nil {
-			return err
-		}
-		if err := cmd.Run(); err == nil {
-			break
-		}
-		time.Sleep(2 * time.Second)
+	if err := d.waiter.ForTCP(d.IPAddress+":22", 0); err != nil {
+		return err
 	}
-
-	return nil
+	// Wait to make sure docker is installed
+	return d.waiter.Until("Docker to be installed", 0, func() (bool, error) {
+		_, err := drivers.RunSSHCommandFromDriver(d, `[ -f "$(which docker)" ] && [ -f "/etc/default/docker" ] || exit 1`)
+		return err == nil, nil
+	})
 }
@@ -11,7 +11,7 @@ import (
 
 type Client struct {
 	User     string
-	ApiKey   string
+	ApiKey   string `machine:"secret"`
 	Endpoint string
 }
 
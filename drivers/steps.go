@@ -0,0 +1,33 @@
+package drivers
+
+// CreateStep is a resource created by a driver. Drivers record a step for
+// each resource they create, so that a failed create can be rolled back.
+type CreateStep struct {
+	// Resource is the kind of resource, e.g. "instance" or "keypair"
+	Resource string
+	// ID identifies the resource on the provider
+	ID string
+}
+
+// StepRecorder records a step of a create. The step is persisted before it
+// returns, so the resource can be cleaned up even if machine is interrupted.
+type StepRecorder func(step CreateStep) error
+
+// Rollbacker is implemented by drivers which record the resources they
+// create. Drivers which do not implement it are rolled back with Remove.
+type Rollbacker interface {
+	// SetStepRecorder is called before Create with the function to record
+	// the created resources with
+	SetStepRecorder(record StepRecorder)
+
+	// Rollback removes the resource created in the step
+	Rollback(step CreateStep) error
+}
+
+// RecordStep records a step with the recorder, if the driver was given one
+func RecordStep(record StepRecorder, resource string, id string) error {
+	if record == nil {
+		return nil
+	}
+	return record(CreateStep{Resource: resource, ID: id})
+}
@@ -3,9 +3,11 @@ package drivers
 import (
 	"fmt"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 
+	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/utils"
 )
 
@@ -20,15 +22,103 @@ func AddPublicKeyToAuthorizedHosts(d Driver, authorizedKeysPath string) error {
 	}
 	defer f.Close()
 
-	// Use path.Join here, want to create unix path even when running on Windows.
-	cmdString := fmt.Sprintf("mkdir -p %q && cat > %q", authorizedKeysPath,
-		path.Join(authorizedKeysPath, "docker-host.json"))
-	cmd, err := d.GetSSHCommand(cmdString)
+	client, err := GetSSHClientFromDriver(d)
 	if err != nil {
 		return err
 	}
-	cmd.Stdin = f
-	return cmd.Run()
+
+	// Use path.Join here, want to create unix path even when running on Windows.
+	return client.Upload(f, path.Join(authorizedKeysPath, "docker-host.json"), 0644)
+}
+
+// KnownHosts is where the host key of the machine of a driver is pinned.
+// Drivers embed it, so that it is kept with their config and the connections
+// made with GetSSHAddressFromDriver check the host key.
+type KnownHosts struct {
+	// KnownHostsFile is the file the host key is pinned in
+	KnownHostsFile string `machine:"identity"`
+	// HostKeyAlias names the machine in KnownHostsFile
+	HostKeyAlias string `machine:"identity"`
+}
+
+func (k *KnownHosts) SetKnownHosts(file string, alias string) {
+	k.KnownHostsFile = file
+	k.HostKeyAlias = alias
+}
+
+func (k *KnownHosts) GetKnownHosts() (string, string) {
+	return k.KnownHostsFile, k.HostKeyAlias
+}
+
+// KnownHostsKeeper is implemented by the drivers which embed KnownHosts
+type KnownHostsKeeper interface {
+	SetKnownHosts(file string, alias string)
+	GetKnownHosts() (file string, alias string)
+}
+
+// KnownHostsPath returns the file the host key of the machine stored at
+// storePath is pinned in
+func KnownHostsPath(storePath string) string {
+	return filepath.Join(storePath, "known_hosts")
+}
+
+// GetSSHAddressFromDriver returns the address to connect to the machine of
+// the driver with SSH, checking the host key if the driver keeps one
+func GetSSHAddressFromDriver(d Driver) (*ssh.Address, error) {
+	host, err := d.GetSSHHostname()
+	if err != nil {
+		return nil, err
+	}
+	port, err := d.GetSSHPort()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := &ssh.Address{
+		User:    d.GetSSHUsername(),
+		Host:    host,
+		Port:    port,
+		KeyPath: d.GetSSHKeyPath(),
+	}
+	if k, ok := d.(KnownHostsKeeper); ok {
+		addr.KnownHostsFile, addr.HostKeyAlias = k.GetKnownHosts()
+	}
+	return addr, nil
+}
+
+// GetSSHCommandFromDriver returns the ssh command running args on the
+// machine of the driver
+func GetSSHCommandFromDriver(d Driver, args ...string) (*exec.Cmd, error) {
+	addr, err := GetSSHAddressFromDriver(d)
+	if err != nil {
+		return nil, err
+	}
+	return addr.Command(args...), nil
+}
+
+// GetSSHClientFromDriver returns a client connecting to the machine of the
+// driver as GetSSHCommandFromDriver does
+func GetSSHClientFromDriver(d Driver) (ssh.Client, error) {
+	addr, err := GetSSHAddressFromDriver(d)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewClient(addr)
+}
+
+// RunSSHCommandFromDriver runs the command on the machine of the driver and
+// returns its output. Errors include the exit status and the output.
+func RunSSHCommandFromDriver(d Driver, command string) (string, error) {
+	client, err := GetSSHClientFromDriver(d)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := client.Output(command)
+	if err != nil {
+		return output, fmt.Errorf("error running %q over SSH: %s: %s", command, err, output)
+	}
+	return output, nil
 }
 
 func PublicKeyExists() (bool, error) {
@@ -14,7 +14,6 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
@@ -22,6 +21,7 @@ import (
 	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/state"
 	"github.com/docker/machine/utils"
+	"github.com/docker/machine/wait"
 )
 
 const (
@@ -30,17 +30,20 @@ const (
 )
 
 type Driver struct {
-	MachineName    string
-	SSHPort        int
+	drivers.KnownHosts
+
+	MachineName    string `machine:"identity"`
+	SSHPort        int    `machine:"identity"`
 	Memory         int
 	DiskSize       int
 	Boot2DockerURL string
-	CaCertPath     string
-	PrivateKeyPath string
-	SwarmMaster    bool
-	SwarmHost      string
-	SwarmDiscovery string
+	CaCertPath     string `machine:"identity"`
+	PrivateKeyPath string `machine:"identity"`
+	SwarmMaster    bool   `machine:"identity"`
+	SwarmHost      string `machine:"identity"`
+	SwarmDiscovery string `machine:"identity"`
 	storePath      string
+	waiter         *wait.Waiter
 }
 
 type CreateFlags struct {
@@ -53,6 +56,9 @@ func init() {
 	drivers.Register("virtualbox", &drivers.RegisteredDriver{
 		New:            NewDriver,
 		GetCreateFlags: GetCreateFlags,
+		Migrations:     []drivers.Migration{drivers.MigrateV0},
+		// VirtualBox is temperamental about doing things concurrently
+		MaxConcurrency: 1,
 	})
 }
 
@@ -87,6 +93,11 @@ func (d *Driver) DriverName() string {
 	return "virtualbox"
 }
 
+// MachineSize returns the size of the machine for machine ls
+func (d *Driver) MachineSize() string {
+	return fmt.Sprintf("%dMB", d.Memory)
+}
+
 func (d *Driver) GetURL() (string, error) {
 	ip, err := d.GetIP()
 	if err != nil {
@@ -172,7 +183,7 @@ func (d *Driver) Create() error {
 
 	log.Infof("Creating SSH key...")
 
-	if err := ssh.GenerateSSHKey(d.sshKeyPath()); err != nil {
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
 		return err
 	}
 
@@ -321,18 +332,12 @@ func (d *Driver) Create() error {
 		return err
 	}
 
-	cmd, err := d.GetSSHCommand(fmt.Sprintf(
+	if _, err := drivers.RunSSHCommandFromDriver(d, fmt.Sprintf(
 		"sudo hostname %s && echo \"%s\" | sudo tee /var/lib/boot2docker/etc/hostname",
 		d.MachineName,
 		d.MachineName,
-	))
-	if err != nil {
+	)); err != nil {
 		return err
-
-	}
-	if err := cmd.Run(); err != nil {
-		return err
-
 	}
 
 	return nil
@@ -351,25 +356,20 @@ func (d *Driver) Start() error {
 		log.Infof("Waiting for VM to start...")
 	}
 
-	return ssh.WaitForTCP(fmt.Sprintf("localhost:%d", d.SSHPort))
+	return d.waiter.ForTCP(fmt.Sprintf("localhost:%d", d.SSHPort), 0)
 }
 
 func (d *Driver) Stop() error {
 	if err := vbm("controlvm", d.MachineName, "acpipowerbutton"); err != nil {
 		return err
 	}
-	for {
+	return d.waiter.Until("the VM to stop", 0, func() (bool, error) {
 		s, err := d.GetState()
 		if err != nil {
-			return err
+			return false, err
 		}
-		if s == state.Running {
-			time.Sleep(1 * time.Second)
-		} else {
-			break
-		}
-	}
-	return nil
+		return s != state.Running, nil
+	})
 }
 
 func (d *Driver) Remove() error {
@@ -476,19 +476,10 @@ func (d *Driver) GetIP() (string, error) {
 		return "", drivers.ErrHostIsNotRunning
 	}
 
-	cmd, err := d.GetSSHCommand("ip addr show dev eth1")
+	out, err := drivers.RunSSHCommandFromDriver(d, "ip addr show dev eth1")
 	if err != nil {
 		return "", err
 	}
-
-	// reset to nil as if using from Host Stdout is already set when using DEBUG
-	cmd.Stdout = nil
-
-	b, err := cmd.Output()
-	if err != nil {
-		return "", err
-	}
-	out := string(b)
 	log.Debugf("SSH returned: %s\nEND SSH\n", out)
 	// parse to find: inet 192.168.59.103/24 brd 192.168.59.255 scope global eth1
 	lines := strings.Split(out, "\n")
@@ -502,18 +493,30 @@ func (d *Driver) GetIP() (string, error) {
 	return "", fmt.Errorf("No IP address found %s", out)
 }
 
+func (d *Driver) GetSSHHostname() (string, error) {
+	return "localhost", nil
+}
+
+func (d *Driver) GetSSHPort() (int, error) {
+	return d.SSHPort, nil
+}
+
+func (d *Driver) GetSSHUsername() string {
+	return "docker"
+}
+
 func (d *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
-	return ssh.GetSSHCommand("localhost", d.SSHPort, "docker", d.sshKeyPath(), args...), nil
+	return drivers.GetSSHCommandFromDriver(d, args...)
+}
+
+func (d *Driver) SetWaiter(w *wait.Waiter) {
+	d.waiter = w
 }
 
 func (d *Driver) StartDocker() error {
 	log.Debug("Starting Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo /etc/init.d/docker start")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo /etc/init.d/docker start"); err != nil {
 		return err
 	}
 
@@ -523,11 +526,7 @@ func (d *Driver) StartDocker() error {
 func (d *Driver) StopDocker() error {
 	log.Debug("Stopping Docker...")
 
-	cmd, err := d.GetSSHCommand("if [ -e /var/run/docker.pid ]; then sudo /etc/init.d/docker stop ; fi")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "if [ -e /var/run/docker.pid ]; then sudo /etc/init.d/docker stop ; fi"); err != nil {
 		return err
 	}
 
@@ -538,12 +537,12 @@ func (d *Driver) GetDockerConfigDir() string {
 	return dockerConfigDir
 }
 
-func (d *Driver) sshKeyPath() string {
+func (d *Driver) GetSSHKeyPath() string {
 	return filepath.Join(d.storePath, "id_rsa")
 }
 
 func (d *Driver) publicSSHKeyPath() string {
-	return d.sshKeyPath() + ".pub"
+	return d.GetSSHKeyPath() + ".pub"
 }
 
 func (d *Driver) diskPath() string {
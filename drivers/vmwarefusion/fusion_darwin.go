@@ -24,6 +24,7 @@ import (
 	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/state"
 	"github.com/docker/machine/utils"
+	"github.com/docker/machine/wait"
 	cssh "golang.org/x/crypto/ssh"
 )
 
@@ -36,19 +37,22 @@ const (
 
 // Driver for VMware Fusion
 type Driver struct {
-	MachineName    string
-	IPAddress      string
+	drivers.KnownHosts
+
+	MachineName    string `machine:"identity"`
+	IPAddress      string `machine:"identity"`
 	Memory         int
 	DiskSize       int
-	ISO            string
+	ISO            string `machine:"identity"`
 	Boot2DockerURL string
-	CaCertPath     string
-	PrivateKeyPath string
-	SwarmMaster    bool
-	SwarmHost      string
-	SwarmDiscovery string
+	CaCertPath     string `machine:"identity"`
+	PrivateKeyPath string `machine:"identity"`
+	SwarmMaster    bool   `machine:"identity"`
+	SwarmHost      string `machine:"identity"`
+	SwarmDiscovery string `machine:"identity"`
 
 	storePath string
+	waiter    *wait.Waiter
 }
 
 type CreateFlags struct {
@@ -61,6 +65,7 @@ func init() {
 	drivers.Register("vmwarefusion", &drivers.RegisteredDriver{
 		New:            NewDriver,
 		GetCreateFlags: GetCreateFlags,
+		Migrations:     []drivers.Migration{drivers.MigrateV0},
 	})
 }
 
@@ -96,6 +101,11 @@ func (d *Driver) DriverName() string {
 	return "vmwarefusion"
 }
 
+// MachineSize returns the size of the machine for machine ls
+func (d *Driver) MachineSize() string {
+	return fmt.Sprintf("%dMB", d.Memory)
+}
+
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.Memory = flags.Int("vmwarefusion-memory-size")
 	d.DiskSize = flags.Int("vmwarefusion-disk-size")
@@ -197,7 +207,7 @@ func (d *Driver) Create() error {
 	}
 
 	log.Infof("Creating SSH key...")
-	if err := ssh.GenerateSSHKey(d.sshKeyPath()); err != nil {
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
 		return err
 	}
 
@@ -237,18 +247,18 @@ func (d *Driver) Create() error {
 	var ip string
 
 	log.Infof("Waiting for VM to come online...")
-	for i := 1; i <= 60; i++ {
+	if err := d.waiter.Until("the VM to get an IP", 120*time.Second, func() (bool, error) {
 		ip, err = d.getIPfromDHCPLease()
 		if err != nil {
-			log.Debugf("Not there yet %d/%d, error: %s", i, 60, err)
-			time.Sleep(2 * time.Second)
-			continue
+			log.Debugf("Not there yet, error: %s", err)
+			return false, nil
 		}
-
 		if ip != "" {
 			log.Debugf("Got an ip: %s", ip)
-			break
 		}
+		return ip != "", nil
+	}); err != nil {
+		log.Debug(err)
 	}
 
 	if ip == "" {
@@ -285,16 +295,12 @@ func (d *Driver) Create() error {
 	session.Close()
 
 	log.Debugf("Setting hostname: %s", d.MachineName)
-	cmd, err := d.GetSSHCommand(fmt.Sprintf(
+	if _, err := drivers.RunSSHCommandFromDriver(d, fmt.Sprintf(
 		"echo \"127.0.0.1 %s\" | sudo tee -a /etc/hosts && sudo hostname %s && echo \"%s\" | sudo tee /etc/hostname",
 		d.MachineName,
 		d.MachineName,
 		d.MachineName,
-	))
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	)); err != nil {
 		return err
 	}
 
@@ -337,11 +343,7 @@ func (d *Driver) Kill() error {
 func (d *Driver) StartDocker() error {
 	log.Debug("Starting Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo /etc/init.d/docker start")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo /etc/init.d/docker start"); err != nil {
 		return err
 	}
 
@@ -351,11 +353,7 @@ func (d *Driver) StartDocker() error {
 func (d *Driver) StopDocker() error {
 	log.Debug("Stopping Docker...")
 
-	cmd, err := d.GetSSHCommand("if [ -e /var/run/docker.pid ]; then sudo /etc/init.d/docker stop ; fi")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "if [ -e /var/run/docker.pid ]; then sudo /etc/init.d/docker stop ; fi"); err != nil {
 		return err
 	}
 
@@ -370,12 +368,24 @@ func (d *Driver) Upgrade() error {
 	return nil
 }
 
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+func (d *Driver) GetSSHPort() (int, error) {
+	return 22, nil
+}
+
+func (d *Driver) GetSSHUsername() string {
+	return "docker"
+}
+
 func (d *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
-	ip, err := d.GetIP()
-	if err != nil {
-		return nil, err
-	}
-	return ssh.GetSSHCommand(ip, 22, "docker", d.sshKeyPath(), args...), nil
+	return drivers.GetSSHCommandFromDriver(d, args...)
+}
+
+func (d *Driver) SetWaiter(w *wait.Waiter) {
+	d.waiter = w
 }
 
 func (d *Driver) vmxPath() string {
@@ -495,10 +505,10 @@ func (d *Driver) getIPfromDHCPLease() (string, error) {
 
 }
 
-func (d *Driver) sshKeyPath() string {
+func (d *Driver) GetSSHKeyPath() string {
 	return path.Join(d.storePath, "id_rsa")
 }
 
 func (d *Driver) publicSSHKeyPath() string {
-	return d.sshKeyPath() + ".pub"
+	return d.GetSSHKeyPath() + ".pub"
 }
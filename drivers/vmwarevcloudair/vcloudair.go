@@ -19,6 +19,7 @@ import (
 	"github.com/docker/machine/drivers"
 	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/state"
+	"github.com/docker/machine/wait"
 )
 
 const (
@@ -26,8 +27,10 @@ const (
 )
 
 type Driver struct {
+	drivers.KnownHosts
+
 	UserName       string
-	UserPassword   string
+	UserPassword   string `machine:"secret"`
 	ComputeID      string
 	VDCID          string
 	OrgVDCNet      string
@@ -35,19 +38,20 @@ type Driver struct {
 	PublicIP       string
 	Catalog        string
 	CatalogItem    string
-	MachineName    string
+	MachineName    string `machine:"identity"`
 	SSHPort        int
 	DockerPort     int
 	Provision      bool
 	CPUCount       int
 	MemorySize     int
-	CaCertPath     string
-	PrivateKeyPath string
-	SwarmMaster    bool
-	SwarmHost      string
-	SwarmDiscovery string
-	VAppID         string
+	CaCertPath     string `machine:"identity"`
+	PrivateKeyPath string `machine:"identity"`
+	SwarmMaster    bool   `machine:"identity"`
+	SwarmHost      string `machine:"identity"`
+	SwarmDiscovery string `machine:"identity"`
+	VAppID         string `machine:"identity"`
 	storePath      string
+	waiter         *wait.Waiter
 }
 
 type CreateFlags struct {
@@ -72,6 +76,7 @@ func init() {
 	drivers.Register("vmwarevcloudair", &drivers.RegisteredDriver{
 		New:            NewDriver,
 		GetCreateFlags: GetCreateFlags,
+		Migrations:     []drivers.Migration{drivers.MigrateV0},
 	})
 }
 
@@ -171,6 +176,11 @@ func (driver *Driver) DriverName() string {
 	return "vmwarevcloudair"
 }
 
+// MachineSize returns the size of the machine for machine ls
+func (driver *Driver) MachineSize() string {
+	return fmt.Sprintf("%dMB", driver.MemorySize)
+}
+
 func (driver *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 
 	driver.UserName = flags.String("vmwarevcloudair-username")
@@ -391,35 +401,26 @@ func (d *Driver) Create() error {
 
 	log.Infof("Waiting for SSH...")
 
-	if err := ssh.WaitForTCP(fmt.Sprintf("%s:%d", d.PublicIP, d.SSHPort)); err != nil {
+	if err := d.waiter.ForTCP(fmt.Sprintf("%s:%d", d.PublicIP, d.SSHPort), 0); err != nil {
 		return err
 	}
 
 	log.Info("Configuring Machine...")
 
 	log.Debugf("Setting hostname: %s", d.MachineName)
-	cmd, err := d.GetSSHCommand(fmt.Sprintf(
+	if _, err := drivers.RunSSHCommandFromDriver(d, fmt.Sprintf(
 		"echo \"127.0.0.1 %s\" | sudo tee -a /etc/hosts && sudo hostname %s && echo \"%s\" | sudo tee /etc/hostname",
 		d.MachineName,
 		d.MachineName,
 		d.MachineName,
-	))
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	)); err != nil {
 		return err
 	}
 
 	connTest := "ping -c 3 www.google.com >/dev/null 2>&1 && ( echo \"Connectivity and DNS tests passed.\" ) || ( echo \"Connectivity and DNS tests failed, trying to add Nameserver to resolv.conf\"; echo \"nameserver 8.8.8.8\" >> /etc/resolv.conf )"
 
 	log.Debugf("Connectivity and DNS sanity test...")
-	cmd, err = d.GetSSHCommand(connTest)
-	if err != nil {
-		return err
-	}
-
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, connTest); err != nil {
 		return err
 	}
 
@@ -706,11 +707,7 @@ func (d *Driver) Kill() error {
 func (d *Driver) StartDocker() error {
 	log.Debug("Starting Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker start")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker start"); err != nil {
 		return err
 	}
 
@@ -720,11 +717,7 @@ func (d *Driver) StartDocker() error {
 func (d *Driver) StopDocker() error {
 	log.Debug("Stopping Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo service docker stop")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo service docker stop"); err != nil {
 		return err
 	}
 
@@ -738,21 +731,28 @@ func (d *Driver) GetDockerConfigDir() string {
 func (d *Driver) Upgrade() error {
 	log.Debugf("Upgrading Docker")
 
-	cmd, err := d.GetSSHCommand("sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
-	if err != nil {
-		return err
+	_, err := drivers.RunSSHCommandFromDriver(d, "sudo apt-get update && sudo apt-get install --upgrade lxc-docker")
+	return err
+}
 
-	}
-	if err := cmd.Run(); err != nil {
-		return err
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.PublicIP, nil
+}
 
-	}
+func (d *Driver) GetSSHPort() (int, error) {
+	return d.SSHPort, nil
+}
 
-	return cmd.Run()
+func (d *Driver) GetSSHUsername() string {
+	return "root"
 }
 
 func (d *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
-	return ssh.GetSSHCommand(d.PublicIP, d.SSHPort, "root", d.sshKeyPath(), args...), nil
+	return drivers.GetSSHCommandFromDriver(d, args...)
+}
+
+func (d *Driver) SetWaiter(w *wait.Waiter) {
+	d.waiter = w
 }
 
 // Helpers
@@ -764,7 +764,7 @@ func generateVMName() string {
 
 func (d *Driver) createSSHKey() (string, error) {
 
-	if err := ssh.GenerateSSHKey(d.sshKeyPath()); err != nil {
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
 		return "", err
 	}
 
@@ -776,10 +776,10 @@ func (d *Driver) createSSHKey() (string, error) {
 	return string(publicKey), nil
 }
 
-func (d *Driver) sshKeyPath() string {
+func (d *Driver) GetSSHKeyPath() string {
 	return path.Join(d.storePath, "id_rsa")
 }
 
 func (d *Driver) publicSSHKeyPath() string {
-	return d.sshKeyPath() + ".pub"
+	return d.GetSSHKeyPath() + ".pub"
 }
@@ -23,6 +23,7 @@ import (
 	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/state"
 	"github.com/docker/machine/utils"
+	"github.com/docker/machine/wait"
 	cssh "golang.org/x/crypto/ssh"
 )
 
@@ -37,7 +38,9 @@ const (
 )
 
 type Driver struct {
-	MachineName    string
+	drivers.KnownHosts
+
+	MachineName    string `machine:"identity"`
 	SSHPort        int
 	CPU            int
 	Memory         int
@@ -45,21 +48,22 @@ type Driver struct {
 	Boot2DockerURL string
 	IP             string
 	Username       string
-	Password       string
+	Password       string `machine:"secret"`
 	Network        string
 	Datastore      string
 	Datacenter     string
 	Pool           string
 	HostIP         string
-	StorePath      string
-	ISO            string
-	CaCertPath     string
-	PrivateKeyPath string
-	SwarmMaster    bool
-	SwarmHost      string
-	SwarmDiscovery string
+	StorePath      string `machine:"identity"`
+	ISO            string `machine:"identity"`
+	CaCertPath     string `machine:"identity"`
+	PrivateKeyPath string `machine:"identity"`
+	SwarmMaster    bool   `machine:"identity"`
+	SwarmHost      string `machine:"identity"`
+	SwarmDiscovery string `machine:"identity"`
 
 	storePath string
+	waiter    *wait.Waiter
 }
 
 type CreateFlags struct {
@@ -81,6 +85,7 @@ func init() {
 	drivers.Register("vmwarevsphere", &drivers.RegisteredDriver{
 		New:            NewDriver,
 		GetCreateFlags: GetCreateFlags,
+		Migrations:     []drivers.Migration{drivers.MigrateV0},
 	})
 }
 
@@ -162,6 +167,11 @@ func (d *Driver) DriverName() string {
 	return "vmwarevsphere"
 }
 
+// MachineSize returns the size of the machine for machine ls
+func (d *Driver) MachineSize() string {
+	return fmt.Sprintf("%dMB", d.Memory)
+}
+
 func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.SSHPort = 22
 	d.CPU = flags.Int("vmwarevsphere-cpu-count")
@@ -239,10 +249,7 @@ func (d *Driver) Create() error {
 		return err
 	}
 
-	var (
-		isoURL string
-		err    error
-	)
+	var isoURL string
 
 	b2dutils := utils.NewB2dUtils("", "")
 
@@ -302,7 +309,7 @@ func (d *Driver) Create() error {
 	}
 
 	log.Infof("Generating SSH Keypair...")
-	if err := ssh.GenerateSSHKey(d.sshKeyPath()); err != nil {
+	if err := ssh.GenerateSSHKey(d.GetSSHKeyPath()); err != nil {
 		return err
 	}
 
@@ -340,16 +347,12 @@ func (d *Driver) Create() error {
 	}
 
 	log.Debugf("Setting hostname: %s", d.MachineName)
-	cmd, err := d.GetSSHCommand(fmt.Sprintf(
+	if _, err := drivers.RunSSHCommandFromDriver(d, fmt.Sprintf(
 		"echo \"127.0.0.1 %s\" | sudo tee -a /etc/hosts && sudo hostname %s && echo \"%s\" | sudo tee /etc/hostname",
 		d.MachineName,
 		d.MachineName,
 		d.MachineName,
-	))
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	)); err != nil {
 		return err
 	}
 
@@ -461,11 +464,7 @@ func (d *Driver) Kill() error {
 func (d *Driver) StartDocker() error {
 	log.Debug("Starting Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo /etc/init.d/docker start")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo /etc/init.d/docker start"); err != nil {
 		return err
 	}
 
@@ -475,11 +474,7 @@ func (d *Driver) StartDocker() error {
 func (d *Driver) StopDocker() error {
 	log.Debug("Stopping Docker...")
 
-	cmd, err := d.GetSSHCommand("sudo /etc/init.d/docker stop")
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if _, err := drivers.RunSSHCommandFromDriver(d, "sudo /etc/init.d/docker stop"); err != nil {
 		return err
 	}
 
@@ -494,20 +489,32 @@ func (d *Driver) Upgrade() error {
 	return fmt.Errorf("upgrade is not supported for vsphere driver at this moment")
 }
 
+func (d *Driver) GetSSHHostname() (string, error) {
+	return d.GetIP()
+}
+
+func (d *Driver) GetSSHPort() (int, error) {
+	return d.SSHPort, nil
+}
+
+func (d *Driver) GetSSHUsername() string {
+	return "docker"
+}
+
 func (d *Driver) GetSSHCommand(args ...string) (*exec.Cmd, error) {
-	ip, err := d.GetIP()
-	if err != nil {
-		return nil, err
-	}
-	return ssh.GetSSHCommand(ip, d.SSHPort, "docker", d.sshKeyPath(), args...), nil
+	return drivers.GetSSHCommandFromDriver(d, args...)
+}
+
+func (d *Driver) SetWaiter(w *wait.Waiter) {
+	d.waiter = w
 }
 
-func (d *Driver) sshKeyPath() string {
+func (d *Driver) GetSSHKeyPath() string {
 	return filepath.Join(d.StorePath, "id_docker_host_vsphere")
 }
 
 func (d *Driver) publicSSHKeyPath() string {
-	return d.sshKeyPath() + ".pub"
+	return d.GetSSHKeyPath() + ".pub"
 }
 
 func (d *Driver) checkVsphereConfig() error {
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// envShell is how a shell sets and unsets environment variables
+type envShell struct {
+	// Set and Unset are formats taking the name and the value of a variable
+	Set   string
+	Unset string
+}
+
+var (
+	posixShell = envShell{"export %s=\"%s\"", "unset %s"}
+
+	// envShells are the shells env supports, by name
+	envShells = map[string]envShell{
+		"sh":         posixShell,
+		"bash":       posixShell,
+		"zsh":        posixShell,
+		"ksh":        posixShell,
+		"dash":       posixShell,
+		"fish":       {"set -x %s \"%s\"", "set -e %s"},
+		"csh":        {"setenv %s \"%s\"", "unsetenv %s"},
+		"tcsh":       {"setenv %s \"%s\"", "unsetenv %s"},
+		"cmd":        {"SET %s=%s", "SET %s="},
+		"powershell": {"$Env:%s = \"%s\"", "Remove-Item Env:\\%s"},
+		// env is a KEY=VALUE file, e.g. for a docker-compose env_file or a
+		// systemd EnvironmentFile
+		"env": {"%s=%s", "%s="},
+	}
+)
+
+// envVariable is a variable set by env
+type envVariable struct {
+	Name  string
+	Value string
+}
+
+// envVariableNames are the variables env sets, in the order they are set
+var envVariableNames = []string{
+	"DOCKER_TLS_VERIFY",
+	"DOCKER_HOST",
+	"DOCKER_CERT_PATH",
+	"DOCKER_MACHINE_NAME",
+}
+
+// noProxyVariable is set by env --no-proxy
+const noProxyVariable = "NO_PROXY"
+
+func supportedShells() []string {
+	names := []string{}
+	for name := range envShells {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// detectShell returns the shell named by --shell, or else the shell of the
+// user. Shells which are not known are assumed to be POSIX shells.
+func detectShell(name string) (envShell, error) {
+	if name != "" {
+		shell, ok := envShells[strings.ToLower(name)]
+		if !ok {
+			return envShell{}, fmt.Errorf("unsupported shell %q; supported shells are %s", name, strings.Join(supportedShells(), ", "))
+		}
+		return shell, nil
+	}
+
+	userShell := os.Getenv("SHELL")
+	if userShell == "" && runtime.GOOS == "windows" {
+		return envShells["cmd"], nil
+	}
+
+	if shell, ok := envShells[strings.TrimSuffix(filepath.Base(userShell), ".exe")]; ok {
+		return shell, nil
+	}
+	return posixShell, nil
+}
+
+// currentNoProxy returns the value of NO_PROXY, or of no_proxy if it is
+// not set
+func currentNoProxy() string {
+	if current := os.Getenv(noProxyVariable); current != "" {
+		return current
+	}
+	return os.Getenv(strings.ToLower(noProxyVariable))
+}
+
+// noProxyHost returns the host of the Docker URL, as it is listed in
+// NO_PROXY
+func noProxyHost(dockerHost string) (string, error) {
+	u, err := url.Parse(dockerHost)
+	if err != nil {
+		return "", err
+	}
+	if h, _, err := net.SplitHostPort(u.Host); err == nil {
+		return h, nil
+	}
+	return u.Host, nil
+}
+
+// noProxyValue returns the value of NO_PROXY with the host of the Docker URL
+// added to it
+func noProxyValue(dockerHost string) (string, error) {
+	current := currentNoProxy()
+
+	host, err := noProxyHost(dockerHost)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return current, nil
+	}
+
+	if current == "" {
+		return host, nil
+	}
+	for _, entry := range strings.Split(current, ",") {
+		if strings.TrimSpace(entry) == host {
+			return current, nil
+		}
+	}
+	return current + "," + host, nil
+}
+
+// noProxyWithout returns the value of NO_PROXY with the host of the Docker
+// URL removed from it, keeping the other entries
+func noProxyWithout(dockerHost string) (string, error) {
+	host, err := noProxyHost(dockerHost)
+	if err != nil {
+		return "", err
+	}
+
+	entries := []string{}
+	for _, entry := range strings.Split(currentNoProxy(), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" && entry != host {
+			entries = append(entries, entry)
+		}
+	}
+	return strings.Join(entries, ","), nil
+}
+
+// writeEnv writes the commands setting the variables for the shell
+func writeEnv(w io.Writer, shell envShell, variables []envVariable) {
+	for _, v := range variables {
+		fmt.Fprintf(w, shell.Set+"\n", v.Name, v.Value)
+	}
+}
+
+// writeUnsetEnv writes the commands unsetting the variables for the shell
+func writeUnsetEnv(w io.Writer, shell envShell, names []string) {
+	for _, name := range names {
+		fmt.Fprintf(w, shell.Unset+"\n", name)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestDetectShell(t *testing.T) {
+	defer os.Setenv("SHELL", os.Getenv("SHELL"))
+
+	os.Setenv("SHELL", "/usr/local/bin/fish")
+	shell, err := detectShell("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shell != envShells["fish"] {
+		t.Fatalf("expected fish, got %v", shell)
+	}
+
+	shell, err = detectShell("PowerShell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shell != envShells["powershell"] {
+		t.Fatalf("expected powershell, got %v", shell)
+	}
+
+	os.Setenv("SHELL", "/bin/unknown")
+	if shell, err = detectShell(""); err != nil || shell != posixShell {
+		t.Fatalf("expected a POSIX shell, got %v, %v", shell, err)
+	}
+
+	if _, err := detectShell("command.com"); err == nil {
+		t.Fatal("expected an error for an unsupported shell")
+	}
+}
+
+func TestWriteEnv(t *testing.T) {
+	variables := []envVariable{
+		{"DOCKER_HOST", "tcp://1.2.3.4:2376"},
+		{"DOCKER_MACHINE_NAME", "dev"},
+	}
+
+	cases := map[string]string{
+		"bash":       "export DOCKER_HOST=\"tcp://1.2.3.4:2376\"\nexport DOCKER_MACHINE_NAME=\"dev\"\n",
+		"fish":       "set -x DOCKER_HOST \"tcp://1.2.3.4:2376\"\nset -x DOCKER_MACHINE_NAME \"dev\"\n",
+		"tcsh":       "setenv DOCKER_HOST \"tcp://1.2.3.4:2376\"\nsetenv DOCKER_MACHINE_NAME \"dev\"\n",
+		"cmd":        "SET DOCKER_HOST=tcp://1.2.3.4:2376\nSET DOCKER_MACHINE_NAME=dev\n",
+		"powershell": "$Env:DOCKER_HOST = \"tcp://1.2.3.4:2376\"\n$Env:DOCKER_MACHINE_NAME = \"dev\"\n",
+		"env":        "DOCKER_HOST=tcp://1.2.3.4:2376\nDOCKER_MACHINE_NAME=dev\n",
+	}
+
+	for name, expected := range cases {
+		var b bytes.Buffer
+		writeEnv(&b, envShells[name], variables)
+		if b.String() != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, b.String())
+		}
+	}
+}
+
+func TestWriteUnsetEnv(t *testing.T) {
+	cases := map[string]string{
+		"bash":       "unset DOCKER_HOST\n",
+		"fish":       "set -e DOCKER_HOST\n",
+		"csh":        "unsetenv DOCKER_HOST\n",
+		"cmd":        "SET DOCKER_HOST=\n",
+		"powershell": "Remove-Item Env:\\DOCKER_HOST\n",
+		"env":        "DOCKER_HOST=\n",
+	}
+
+	for name, expected := range cases {
+		var b bytes.Buffer
+		writeUnsetEnv(&b, envShells[name], []string{"DOCKER_HOST"})
+		if b.String() != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, b.String())
+		}
+	}
+
+	// every shell which can set variables can unset them
+	for name, shell := range envShells {
+		if shell.Set == "" || shell.Unset == "" {
+			t.Errorf("%s cannot set and unset variables", name)
+		}
+	}
+}
+
+func TestNoProxyValue(t *testing.T) {
+	defer os.Setenv("NO_PROXY", os.Getenv("NO_PROXY"))
+	defer os.Setenv("no_proxy", os.Getenv("no_proxy"))
+	os.Setenv("no_proxy", "")
+
+	cases := []struct {
+		current  string
+		expected string
+	}{
+		{"", "1.2.3.4"},
+		{"localhost", "localhost,1.2.3.4"},
+		{"localhost,1.2.3.4", "localhost,1.2.3.4"},
+	}
+
+	for _, c := range cases {
+		os.Setenv("NO_PROXY", c.current)
+		value, err := noProxyValue("tcp://1.2.3.4:2376")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != c.expected {
+			t.Errorf("NO_PROXY=%q: expected %q, got %q", c.current, c.expected, value)
+		}
+	}
+}
+
+func TestNoProxyWithout(t *testing.T) {
+	defer os.Setenv("NO_PROXY", os.Getenv("NO_PROXY"))
+	defer os.Setenv("no_proxy", os.Getenv("no_proxy"))
+	os.Setenv("no_proxy", "")
+
+	cases := []struct {
+		current  string
+		expected string
+	}{
+		{"", ""},
+		{"1.2.3.4", ""},
+		{"localhost, 1.2.3.4,.internal", "localhost,.internal"},
+		{"localhost,1.2.3.40", "localhost,1.2.3.40"},
+	}
+
+	for _, c := range cases {
+		os.Setenv("NO_PROXY", c.current)
+		value, err := noProxyWithout("tcp://1.2.3.4:2376")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != c.expected {
+			t.Errorf("NO_PROXY=%q: expected %q, got %q", c.current, c.expected, value)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/machine/drivers"
+)
+
+// defaultParallel is the default number of machines an action runs on at
+// the same time, so that cloud providers do not rate limit us
+const defaultParallel = 5
+
+// actionResult is the outcome of an action on a machine
+type actionResult struct {
+	Machine  string
+	Action   string
+	Err      error
+	Duration time.Duration
+}
+
+// executor runs an action on several machines. At most Parallel actions run
+// at the same time, and at most the limit returned by DriverLimit on
+// machines of the same driver. A limit of 0 means no limit.
+type executor struct {
+	Parallel    int
+	DriverLimit func(driverName string) int
+}
+
+func newExecutor(parallel int) *executor {
+	return &executor{
+		Parallel:    parallel,
+		DriverLimit: drivers.GetMaxConcurrency,
+	}
+}
+
+// run runs fn on all machines and returns the results in the order of the
+// machines
+func (e *executor) run(action string, machines []*Host, fn func(machine *Host) error) []actionResult {
+	var (
+		results = make([]actionResult, len(machines))
+		wg      sync.WaitGroup
+		slots   chan struct{}
+	)
+
+	if e.Parallel > 0 {
+		slots = make(chan struct{}, e.Parallel)
+	}
+
+	driverSlots := map[string]chan struct{}{}
+	for _, machine := range machines {
+		if _, ok := driverSlots[machine.DriverName]; ok {
+			continue
+		}
+		var s chan struct{}
+		if e.DriverLimit != nil {
+			if limit := e.DriverLimit(machine.DriverName); limit > 0 {
+				s = make(chan struct{}, limit)
+			}
+		}
+		driverSlots[machine.DriverName] = s
+	}
+
+	for i, machine := range machines {
+		wg.Add(1)
+		go func(i int, machine *Host) {
+			defer wg.Done()
+
+			// take the driver slot first so machines waiting for their
+			// driver do not block machines of other drivers
+			if s := driverSlots[machine.DriverName]; s != nil {
+				s <- struct{}{}
+				defer func() { <-s }()
+			}
+			if slots != nil {
+				slots <- struct{}{}
+				defer func() { <-slots }()
+			}
+
+			start := time.Now()
+			err := fn(machine)
+			results[i] = actionResult{
+				Machine:  machine.Name,
+				Action:   action,
+				Err:      err,
+				Duration: time.Since(start),
+			}
+		}(i, machine)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// failedResults returns the number of results with an error
+func failedResults(results []actionResult) int {
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// printResults writes a summary table of the results
+func printResults(w io.Writer, results []actionResult) {
+	tw := tabwriter.NewWriter(w, 5, 1, 3, ' ', 0)
+	fmt.Fprintln(tw, "MACHINE\tACTION\tRESULT\tDURATION")
+	for _, result := range results {
+		status := "OK"
+		if result.Err != nil {
+			status = fmt.Sprintf("Error: %s", result.Err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+			result.Machine, result.Action, status, result.Duration-result.Duration%time.Millisecond)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyCounter tracks the highest number of concurrent calls, overall
+// and per driver
+type concurrencyCounter struct {
+	sync.Mutex
+	running   map[string]int
+	total     int
+	maxTotal  int
+	maxDriver map[string]int
+}
+
+func (c *concurrencyCounter) run(machine *Host) error {
+	c.Lock()
+	c.running[machine.DriverName]++
+	c.total++
+	if c.total > c.maxTotal {
+		c.maxTotal = c.total
+	}
+	if c.running[machine.DriverName] > c.maxDriver[machine.DriverName] {
+		c.maxDriver[machine.DriverName] = c.running[machine.DriverName]
+	}
+	c.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	c.Lock()
+	c.running[machine.DriverName]--
+	c.total--
+	c.Unlock()
+
+	if machine.Name == "fail" {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func TestExecutorLimits(t *testing.T) {
+	machines := []*Host{}
+	for i := 0; i < 10; i++ {
+		machines = append(machines, &Host{Name: "cloud", DriverName: "cloud"})
+	}
+	for i := 0; i < 3; i++ {
+		machines = append(machines, &Host{Name: "local", DriverName: "local"})
+	}
+	machines = append(machines, &Host{Name: "fail", DriverName: "cloud"})
+
+	counter := &concurrencyCounter{running: map[string]int{}, maxDriver: map[string]int{}}
+	e := &executor{
+		Parallel: 4,
+		DriverLimit: func(driverName string) int {
+			if driverName == "local" {
+				return 1
+			}
+			return 0
+		},
+	}
+
+	results := e.run("start", machines, counter.run)
+
+	if len(results) != len(machines) {
+		t.Fatalf("expected %d results; received %d", len(machines), len(results))
+	}
+	for i, result := range results {
+		if result.Machine != machines[i].Name || result.Action != "start" {
+			t.Fatalf("unexpected result %d: %+v", i, result)
+		}
+	}
+	if counter.maxTotal > 4 {
+		t.Fatalf("expected at most 4 concurrent actions; received %d", counter.maxTotal)
+	}
+	if counter.maxDriver["local"] != 1 {
+		t.Fatalf("expected at most 1 concurrent local action; received %d", counter.maxDriver["local"])
+	}
+	if failed := failedResults(results); failed != 1 {
+		t.Fatalf("expected 1 failed result; received %d", failed)
+	}
+}
+
+func TestPrintResults(t *testing.T) {
+	var buf bytes.Buffer
+	printResults(&buf, []actionResult{
+		{Machine: "dev", Action: "stop", Duration: 1500 * time.Millisecond},
+		{Machine: "staging", Action: "stop", Err: errors.New("timed out"), Duration: time.Second},
+	})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected summary:\n%s", buf.String())
+	}
+	if fields := strings.Fields(lines[1]); len(fields) != 4 || fields[0] != "dev" || fields[2] != "OK" || fields[3] != "1.5s" {
+		t.Fatalf("unexpected summary line: %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "Error: timed out") {
+		t.Fatalf("unexpected summary line: %q", lines[2])
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/machine/utils"
+)
+
+const (
+	exportManifestName = "manifest.json"
+	exportCaCertName   = "ca.pem"
+	exportMachineDir   = "machine"
+
+	exportClientCertName = "cert.pem"
+	exportClientKeyName  = "key.pem"
+)
+
+// exportManifest describes an exported machine. The paths are the ones of
+// the exporting workstation and are rewritten on import.
+type exportManifest struct {
+	Name           string
+	StorePath      string
+	CertDir        string
+	EncryptedFiles []string
+}
+
+// isSecretFile returns whether an exported file holds secrets that are
+// encrypted when exporting with a passphrase
+func isSecretFile(name string) bool {
+	base := path.Base(name)
+	return base == "config.json" || base == "id_rsa" || strings.HasSuffix(base, "key.pem")
+}
+
+// errExportPassphrase is returned when exporting without a passphrase. The
+// archive holds the keys of the machine and the client key of this
+// workstation, which must not be written in plaintext.
+var errExportPassphrase = errors.New("a passphrase is required to export a machine, as the export contains its keys and the client key of this workstation")
+
+// exportHost writes a gzipped tarball of the host directory, the CA
+// certificate of the host and the client certificate signed by it to w. The
+// files holding secrets are encrypted with passphrase, which is required.
+func exportHost(host *Host, w io.Writer, passphrase string) error {
+	if passphrase == "" {
+		return errExportPassphrase
+	}
+
+	manifest := exportManifest{
+		Name:      host.Name,
+		StorePath: host.storePath,
+		CertDir:   filepath.Dir(host.CaCertPath),
+	}
+
+	type exportFile struct {
+		name string
+		mode os.FileMode
+		data []byte
+	}
+	files := []exportFile{}
+	exported := map[string]bool{}
+
+	addFile := func(name string, mode os.FileMode, data []byte) error {
+		if isSecretFile(name) {
+			var err error
+			if data, err = utils.Encrypt(data, passphrase); err != nil {
+				return err
+			}
+			manifest.EncryptedFiles = append(manifest.EncryptedFiles, name)
+		}
+		files = append(files, exportFile{name: name, mode: mode, data: data})
+		exported[name] = true
+		return nil
+	}
+
+	err := filepath.Walk(host.storePath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		// config backups are not part of the identity of the machine
+		if strings.HasSuffix(p, ".bak") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(host.storePath, p)
+		if err != nil {
+			return err
+		}
+		name := path.Join(exportMachineDir, filepath.ToSlash(rel))
+
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return addFile(name, info.Mode(), data)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the client cert is signed by the CA of this workstation, so it goes
+	// with the machine unless its directory already holds a copy
+	if host.CaCertPath != "" && !exported[path.Join(exportMachineDir, exportClientCertName)] {
+		for _, name := range []string{exportClientCertName, exportClientKeyName} {
+			data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(host.CaCertPath), name))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return fmt.Errorf("error reading client certificate: %s", err)
+			}
+			if err := addFile(path.Join(exportMachineDir, name), 0600, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	if host.CaCertPath != "" {
+		caCert, err := ioutil.ReadFile(host.CaCertPath)
+		if err != nil {
+			return fmt.Errorf("error reading CA certificate: %s", err)
+		}
+		files = append(files, exportFile{name: exportCaCertName, mode: 0600, data: caCert})
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	// the manifest goes first so that it is known when importing the files
+	files = append([]exportFile{{name: exportManifestName, mode: 0600, data: manifestData}}, files...)
+
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name: f.name,
+			Mode: int64(f.mode.Perm()),
+			Size: int64(len(f.data)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// importHost restores a host exported with exportHost into the store as
+// name, or the exported name if empty. The files of the host are kept in the
+// directory returned by hostPathFor. Paths in the config that pointed into
+// the exporting store and cert directories are rewritten accordingly.
+func importHost(store Store, r io.Reader, name string, hostPathFor func(name string) string, certDir string, passphrase string) (_ *Host, err error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != exportManifestName {
+		return nil, fmt.Errorf("not a machine export: missing %s", exportManifestName)
+	}
+
+	manifest := exportManifest{}
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = manifest.Name
+	}
+	if _, err := ValidateHostName(name); err != nil {
+		return nil, err
+	}
+
+	if err := store.Lock(name); err != nil {
+		return nil, err
+	}
+	defer store.Unlock(name)
+
+	exists, err := store.Exists(name)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("Machine %s already exists", name)
+	}
+
+	encrypted := map[string]bool{}
+	for _, f := range manifest.EncryptedFiles {
+		encrypted[f] = true
+	}
+	if len(encrypted) > 0 && passphrase == "" {
+		return nil, fmt.Errorf("the export contains encrypted files; please specify the passphrase")
+	}
+
+	hostPath := hostPathFor(name)
+
+	if err := os.MkdirAll(hostPath, 0700); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(hostPath)
+		}
+	}()
+
+	var (
+		config []byte
+		caCert []byte
+	)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		if encrypted[hdr.Name] {
+			if data, err = utils.Decrypt(data, passphrase); err != nil {
+				return nil, fmt.Errorf("error decrypting %s: %s", hdr.Name, err)
+			}
+		}
+
+		switch {
+		case hdr.Name == exportCaCertName:
+			caCert = data
+		case hdr.Name == path.Join(exportMachineDir, "config.json"):
+			config = data
+		case strings.HasPrefix(hdr.Name, exportMachineDir+"/"):
+			rel := strings.TrimPrefix(hdr.Name, exportMachineDir+"/")
+			if strings.Contains(rel, "..") {
+				return nil, fmt.Errorf("invalid file name in export: %s", hdr.Name)
+			}
+			dest := filepath.Join(hostPath, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+				return nil, err
+			}
+			if err := ioutil.WriteFile(dest, data, os.FileMode(hdr.Mode).Perm()); err != nil {
+				return nil, err
+			}
+		default:
+			log.Debugf("ignoring unknown file in export: %s", hdr.Name)
+		}
+	}
+
+	if config == nil {
+		return nil, fmt.Errorf("not a machine export: missing config.json")
+	}
+
+	caCertPath := filepath.Join(hostPath, "ca.pem")
+	if caCert != nil {
+		if err := ioutil.WriteFile(caCertPath, caCert, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	config, _, _, err = migrateHostConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err = rewriteConfigPaths(config, map[string]string{
+		manifest.StorePath: hostPath,
+		manifest.CertDir:   certDir,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	host := &Host{Name: name, storePath: hostPath, store: store}
+	if err := host.decodeConfig(config); err != nil {
+		return nil, err
+	}
+	if caCert != nil {
+		host.CaCertPath = caCertPath
+	}
+	// the Docker client authenticates with the client cert of the exporting
+	// workstation, which the machine trusts, rather than with our own
+	clientCertPath := filepath.Join(hostPath, exportClientCertName)
+	if _, err := os.Stat(clientCertPath); err == nil {
+		host.ClientCertPath = clientCertPath
+	}
+
+	if err := store.Save(host); err != nil {
+		return nil, err
+	}
+
+	return host, nil
+}
+
+// rewriteConfigPaths replaces path prefixes in all string values of a JSON
+// config, including the ones of the driver. The config may come from a
+// workstation with another path separator, so both / and \ separate the
+// path elements, and the rewritten paths use the separator of this one.
+func rewriteConfigPaths(data []byte, prefixes map[string]string) ([]byte, error) {
+	var config interface{}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&config); err != nil {
+		return nil, err
+	}
+
+	var rewrite func(v interface{}) interface{}
+	rewrite = func(v interface{}) interface{} {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			for k, e := range val {
+				val[k] = rewrite(e)
+			}
+		case []interface{}:
+			for i, e := range val {
+				val[i] = rewrite(e)
+			}
+		case string:
+			slashed := toSlash(val)
+			for from, to := range prefixes {
+				from = strings.TrimRight(toSlash(from), "/")
+				if from == "" || from == "." {
+					continue
+				}
+				if slashed == from || strings.HasPrefix(slashed, from+"/") {
+					return to + filepath.FromSlash(strings.TrimPrefix(slashed, from))
+				}
+			}
+		}
+		return v
+	}
+
+	return json.Marshal(rewrite(config))
+}
+
+// toSlash returns p with both / and \ replaced by /, whatever the
+// separator of this workstation
+func toSlash(p string) string {
+	return strings.Replace(p, "\\", "/", -1)
+}
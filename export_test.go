@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func getTestExportHost(t *testing.T) (*Filestore, *Host) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certDir := filepath.Join(tmpDir, "certs")
+	if err := os.MkdirAll(certDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	caCertPath := filepath.Join(certDir, "ca.pem")
+	if err := ioutil.WriteFile(caCertPath, []byte("test ca"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{"cert.pem": "client cert", "key.pem": "client key"} {
+		if err := ioutil.WriteFile(filepath.Join(certDir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := NewFilestore(filepath.Join(tmpDir, "machines"), caCertPath, filepath.Join(certDir, "ca-key.pem"))
+	host, err := store.Create("test", "none", getDefaultTestDriverFlags(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(host.storePath, "id_rsa"), []byte("secret key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	host.ServerCertPath = filepath.Join(host.storePath, "server.pem")
+	if err := host.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	return store, host
+}
+
+func TestExportImport(t *testing.T) {
+	store, host := getTestExportHost(t)
+	defer os.RemoveAll(filepath.Dir(store.Path))
+
+	var buf bytes.Buffer
+	if err := exportHost(host, &buf, "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	newStore := NewFilestore(filepath.Join(tmpDir, "machines"), "", "")
+	certDir := filepath.Join(tmpDir, "certs")
+
+	imported, err := importHost(newStore, &buf, "imported", newStore.hostPath, certDir, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := newStore.Load("imported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Name != "imported" {
+		t.Fatalf("expected name imported; received %s", loaded.Name)
+	}
+
+	expected := filepath.Join(newStore.Path, "imported", "server.pem")
+	if loaded.ServerCertPath != expected {
+		t.Fatalf("expected server cert path %s; received %s", expected, loaded.ServerCertPath)
+	}
+
+	expected = filepath.Join(newStore.Path, "imported", "ca.pem")
+	if loaded.CaCertPath != expected {
+		t.Fatalf("expected ca cert path %s; received %s", expected, loaded.CaCertPath)
+	}
+	caCert, err := ioutil.ReadFile(loaded.CaCertPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(caCert) != "test ca" {
+		t.Fatalf("unexpected ca cert: %q", caCert)
+	}
+
+	expected = filepath.Join(certDir, "ca-key.pem")
+	if loaded.PrivateKeyPath != expected {
+		t.Fatalf("expected private key path %s; received %s", expected, loaded.PrivateKeyPath)
+	}
+
+	key, err := ioutil.ReadFile(filepath.Join(imported.storePath, "id_rsa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) != "secret key" {
+		t.Fatalf("unexpected ssh key: %q", key)
+	}
+
+	expected = filepath.Join(newStore.Path, "imported", "cert.pem")
+	if loaded.ClientCertPath != expected {
+		t.Fatalf("expected client cert path %s; received %s", expected, loaded.ClientCertPath)
+	}
+	for name, data := range map[string]string{"cert.pem": "client cert", "key.pem": "client key"} {
+		content, err := ioutil.ReadFile(filepath.Join(imported.storePath, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != data {
+			t.Fatalf("unexpected %s: %q", name, content)
+		}
+	}
+
+	url, err := loaded.GetURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "unix:///var/run/docker.sock" {
+		t.Fatalf("unexpected url: %s", url)
+	}
+}
+
+func TestExportRequiresPassphrase(t *testing.T) {
+	store, host := getTestExportHost(t)
+	defer os.RemoveAll(filepath.Dir(store.Path))
+
+	var buf bytes.Buffer
+	if err := exportHost(host, &buf, ""); err != errExportPassphrase {
+		t.Fatalf("expected export without passphrase to fail; received %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatal("expected nothing to be exported without passphrase")
+	}
+}
+
+func TestExportImportEncrypted(t *testing.T) {
+	store, host := getTestExportHost(t)
+	defer os.RemoveAll(filepath.Dir(store.Path))
+
+	var buf bytes.Buffer
+	if err := exportHost(host, &buf, "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	newStore := NewFilestore(filepath.Join(tmpDir, "machines"), "", "")
+	certDir := filepath.Join(tmpDir, "certs")
+
+	if _, err := importHost(newStore, bytes.NewReader(archive), "", newStore.hostPath, certDir, ""); err == nil {
+		t.Fatal("expected import without passphrase to fail")
+	}
+
+	_, err = importHost(newStore, bytes.NewReader(archive), "", newStore.hostPath, certDir, "wrong")
+	if err == nil || !strings.Contains(err.Error(), "decrypting") {
+		t.Fatalf("expected import with wrong passphrase to fail; received %v", err)
+	}
+	if exists, _ := newStore.Exists("test"); exists {
+		t.Fatal("expected failed import to be cleaned up")
+	}
+
+	if _, err := importHost(newStore, bytes.NewReader(archive), "", newStore.hostPath, certDir, "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ioutil.ReadFile(filepath.Join(newStore.Path, "test", "id_rsa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(key) != "secret key" {
+		t.Fatalf("unexpected ssh key: %q", key)
+	}
+
+	clientKey, err := ioutil.ReadFile(filepath.Join(newStore.Path, "test", "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(clientKey) != "client key" {
+		t.Fatalf("unexpected client key: %q", clientKey)
+	}
+}
+
+func TestRewriteConfigPathsSeparators(t *testing.T) {
+	// a config exported on Windows, with mixed separators
+	data := []byte(`{"ServerCertPath": "C:\\Users\\test\\.docker\\machine\\machines\\dev\\server.pem", "Driver": {"SSHKeyPath": "C:/Users/test/.docker/machine/machines/dev/id_rsa", "Other": "C:\\Users\\test\\.docker\\machine\\machines\\development"}}`)
+
+	storePath := filepath.Join("home", "test", "machines", "dev")
+	rewritten, err := rewriteConfigPaths(data, map[string]string{
+		`C:\Users\test\.docker\machine\machines\dev\`: storePath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := struct {
+		ServerCertPath string
+		Driver         struct{ SSHKeyPath, Other string }
+	}{}
+	if err := json.Unmarshal(rewritten, &config); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := filepath.Join(storePath, "server.pem"); config.ServerCertPath != expected {
+		t.Fatalf("expected server cert path %s; received %s", expected, config.ServerCertPath)
+	}
+	if expected := filepath.Join(storePath, "id_rsa"); config.Driver.SSHKeyPath != expected {
+		t.Fatalf("expected ssh key path %s; received %s", expected, config.Driver.SSHKeyPath)
+	}
+	if expected := `C:\Users\test\.docker\machine\machines\development`; config.Driver.Other != expected {
+		t.Fatalf("expected %s not to be rewritten; received %s", expected, config.Driver.Other)
+	}
+}
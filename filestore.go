@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/machine/drivers"
+	"github.com/docker/machine/utils"
+	"github.com/docker/machine/wait"
+)
+
+// Filestore persists hosts on the filesystem, one directory per host
+type Filestore struct {
+	Path           string
+	CaCertPath     string
+	PrivateKeyPath string
+}
+
+func NewFilestore(rootPath string, caCert string, privateKey string) *Filestore {
+	if rootPath == "" {
+		rootPath = utils.GetMachineDir()
+	}
+
+	return &Filestore{Path: rootPath, CaCertPath: caCert, PrivateKeyPath: privateKey}
+}
+
+func (s *Filestore) Create(name string, driverName string, flags drivers.DriverOptions, waiter *wait.Waiter) (*Host, error) {
+	return createHost(s, s.hostPath(name), name, driverName, s.CaCertPath, s.PrivateKeyPath, flags, waiter)
+}
+
+func (s *Filestore) Remove(name string, force bool) error {
+	if err := s.Lock(name); err != nil {
+		return err
+	}
+	defer s.Unlock(name)
+
+	if err := clearActive(s, name); err != nil {
+		return err
+	}
+
+	host, err := s.Load(name)
+	if err != nil {
+		return err
+	}
+	return host.Remove(force)
+}
+
+func (s *Filestore) Delete(name string) error {
+	return os.RemoveAll(s.hostPath(name))
+}
+
+func (s *Filestore) List() ([]Host, error) {
+	dir, err := ioutil.ReadDir(s.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	hosts := []Host{}
+
+	for _, file := range dir {
+		// don't load hidden dirs; used for configs
+		if file.IsDir() && strings.Index(file.Name(), ".") != 0 {
+			host, err := s.Load(file.Name())
+			if err != nil {
+				log.Errorf("error loading host %q: %s", file.Name(), err)
+				continue
+			}
+			hosts = append(hosts, *host)
+		}
+	}
+	return hosts, nil
+}
+
+func (s *Filestore) Exists(name string) (bool, error) {
+	_, err := os.Stat(s.hostPath(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err == nil {
+		return true, nil
+	}
+	return false, err
+}
+
+func (s *Filestore) Load(name string) (*Host, error) {
+	hostPath := s.hostPath(name)
+	if _, err := os.Stat(hostPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("Host %q does not exist", name)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(hostPath, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	return loadHost(s, name, hostPath, data, func(version int, data []byte) error {
+		backupPath := filepath.Join(hostPath, fmt.Sprintf("config.json.v%d.bak", version))
+		return utils.WriteFileAtomic(backupPath, data, 0600)
+	})
+}
+
+func (s *Filestore) Save(host *Host) error {
+	data, err := host.marshalConfig()
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(s.hostPath(host.Name), "config.json"), data, 0600)
+}
+
+func (s *Filestore) Lock(name string) error {
+	return lockHost(s.Path, name)
+}
+
+func (s *Filestore) Unlock(name string) error {
+	return unlockHost(s.Path, name)
+}
+
+func (s *Filestore) GetActive() (*Host, error) {
+	hostName, err := ioutil.ReadFile(s.activePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return s.Load(string(hostName))
+}
+
+func (s *Filestore) IsActive(host *Host) (bool, error) {
+	return isActive(s, host)
+}
+
+func (s *Filestore) SetActive(host *Host) error {
+	if err := os.MkdirAll(filepath.Dir(s.activePath()), 0700); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(s.activePath(), []byte(host.Name), 0600)
+}
+
+func (s *Filestore) RemoveActive() error {
+	return os.Remove(s.activePath())
+}
+
+// hostPath returns the directory holding the config and files of a host
+func (s *Filestore) hostPath(name string) string {
+	return filepath.Join(s.Path, name)
+}
+
+// activePath returns the path to the file that stores the name of the
+// active host
+func (s *Filestore) activePath() string {
+	return filepath.Join(s.Path, ".active")
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/machine/state"
+)
+
+// hostFilter reports whether a host is selected by a --filter option
+type hostFilter func(host *Host) bool
+
+// hostSelector selects the machines a command operates on
+type hostSelector struct {
+	// Names are machine names or glob patterns such as web-*
+	Names []string
+	// All selects all machines, ignoring Names
+	All bool
+	// Filters are given as type=value, see parseFilters
+	Filters []string
+}
+
+// isBulk reports whether the selector selects machines other than by their
+// exact names
+func (s hostSelector) isBulk() bool {
+	if s.All || len(s.Filters) > 0 {
+		return true
+	}
+	for _, name := range s.Names {
+		if isGlob(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectHosts loads the machines selected by the selector. If there are
+// filters but no names, the filters are applied to all machines.
+func (s hostSelector) selectHosts(store Store) ([]*Host, error) {
+	// the states are only queried for the machines selected by the other
+	// filters
+	otherFilters, stateFilters := splitStateFilters(s.Filters)
+	filters, err := parseFilters(otherFilters, store)
+	if err != nil {
+		return nil, err
+	}
+
+	var hostList []Host
+	listHosts := func() ([]Host, error) {
+		if hostList == nil {
+			if hostList, err = store.List(); err != nil {
+				return nil, err
+			}
+		}
+		return hostList, nil
+	}
+
+	candidates := []*Host{}
+	if s.All || (len(s.Names) == 0 && len(s.Filters) > 0) {
+		hosts, err := listHosts()
+		if err != nil {
+			return nil, err
+		}
+		for i := range hosts {
+			candidates = append(candidates, &hosts[i])
+		}
+	} else {
+		seen := map[string]bool{}
+		for _, name := range s.Names {
+			if !isGlob(name) {
+				if seen[name] {
+					continue
+				}
+				host, err := store.Load(name)
+				if err != nil {
+					return nil, err
+				}
+				seen[name] = true
+				candidates = append(candidates, host)
+				continue
+			}
+
+			hosts, err := listHosts()
+			if err != nil {
+				return nil, err
+			}
+			matched := false
+			for i := range hosts {
+				ok, err := path.Match(name, hosts[i].Name)
+				if err != nil {
+					return nil, fmt.Errorf("invalid pattern %q: %s", name, err)
+				}
+				if !ok {
+					continue
+				}
+				matched = true
+				if !seen[hosts[i].Name] {
+					seen[hosts[i].Name] = true
+					candidates = append(candidates, &hosts[i])
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("No machines match %q", name)
+			}
+		}
+	}
+
+	if len(s.Filters) == 0 {
+		return candidates, nil
+	}
+
+	selected := []*Host{}
+	for _, host := range candidates {
+		if matchesFilters(host, filters) {
+			selected = append(selected, host)
+		}
+	}
+	if len(stateFilters) > 0 {
+		selected = filterStates(selected, stateFilters)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("No machines match the filters")
+	}
+
+	return selected, nil
+}
+
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// parseFilters parses filters given as type=value. The supported filters are
+//
+//	label=key        hosts which have the label
+//	label=key=value  hosts which have the label with the value
+//	driver=name      hosts created with the driver
+//	swarm=name       the swarm master with the name and its nodes
+//	swarm=url        the hosts in the swarm with the discovery URL
+//	name=pattern     hosts with names matching the glob pattern
+//
+// State filters (state=state, e.g. Running or Stopped) need the states of
+// the hosts, which are queried in parallel. They are split off with
+// splitStateFilters and applied with filterStates or matchesStates.
+func parseFilters(filters []string, store Store) ([]hostFilter, error) {
+	parsed := []hostFilter{}
+	for _, filter := range filters {
+		parts := strings.SplitN(filter, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid filter %q; filters must be given as type=value", filter)
+		}
+
+		switch parts[0] {
+		case "label":
+			parsed = append(parsed, labelFilter(parts[1]))
+		case "driver":
+			parsed = append(parsed, driverFilter(parts[1]))
+		case "swarm":
+			if strings.Contains(parts[1], "://") {
+				parsed = append(parsed, discoveryFilter(parts[1]))
+				continue
+			}
+			f, err := swarmFilter(parts[1], store)
+			if err != nil {
+				return nil, err
+			}
+			parsed = append(parsed, f)
+		case "name":
+			f, err := nameFilter(parts[1])
+			if err != nil {
+				return nil, err
+			}
+			parsed = append(parsed, f)
+		default:
+			return nil, fmt.Errorf("unsupported filter %q", parts[0])
+		}
+	}
+	return parsed, nil
+}
+
+func labelFilter(label string) hostFilter {
+	parts := strings.SplitN(label, "=", 2)
+	return func(host *Host) bool {
+		value, ok := host.Labels[parts[0]]
+		if !ok {
+			return false
+		}
+		return len(parts) == 1 || value == parts[1]
+	}
+}
+
+func driverFilter(driverName string) hostFilter {
+	return func(host *Host) bool {
+		return host.DriverName == driverName
+	}
+}
+
+// filterStates returns the hosts whose state matches all state filters. The
+// states are queried in parallel, as by ls, each with the list timeout.
+func filterStates(hosts []*Host, states []string) []*Host {
+	matches := make([]bool, len(hosts))
+
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host *Host) {
+			defer wg.Done()
+			current, err := host.getStateWithTimeout(stateCacheUse, defaultListTimeout)
+			if err != nil {
+				log.Debugf("error getting state for host %s: %s", host.Name, err)
+				return
+			}
+			matches[i] = matchesStates(current.State, states)
+		}(i, host)
+	}
+	wg.Wait()
+
+	selected := []*Host{}
+	for i, host := range hosts {
+		if matches[i] {
+			selected = append(selected, host)
+		}
+	}
+	return selected
+}
+
+// splitStateFilters splits the state filters off the other filters, so that
+// commands which query the states anyway can apply them with matchesStates
+func splitStateFilters(filters []string) (others []string, states []string) {
+	for _, filter := range filters {
+		if strings.HasPrefix(filter, "state=") && len(filter) > len("state=") {
+			states = append(states, strings.TrimPrefix(filter, "state="))
+			continue
+		}
+		others = append(others, filter)
+	}
+	return others, states
+}
+
+// matchesStates reports whether the state matches all state filters
+func matchesStates(current state.State, states []string) bool {
+	for _, s := range states {
+		if !strings.EqualFold(current.String(), s) {
+			return false
+		}
+	}
+	return true
+}
+
+func swarmFilter(masterName string, store Store) (hostFilter, error) {
+	master, err := store.Load(masterName)
+	if err != nil {
+		return nil, err
+	}
+	if !master.SwarmMaster {
+		return nil, fmt.Errorf("%s is not a swarm master", masterName)
+	}
+
+	return func(host *Host) bool {
+		return host.SwarmDiscovery != "" && host.SwarmDiscovery == master.SwarmDiscovery
+	}, nil
+}
+
+func discoveryFilter(discovery string) hostFilter {
+	return func(host *Host) bool {
+		return host.SwarmDiscovery == discovery
+	}
+}
+
+func nameFilter(pattern string) (hostFilter, error) {
+	// check the pattern once, so that matching cannot fail
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+	}
+
+	return func(host *Host) bool {
+		ok, _ := path.Match(pattern, host.Name)
+		return ok
+	}, nil
+}
+
+// matchesFilters reports whether the host is selected by all filters
+func matchesFilters(host *Host, filters []hostFilter) bool {
+	for _, filter := range filters {
+		if !filter(host) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/state"
+)
+
+func TestParseFilters(t *testing.T) {
+	host := &Host{
+		Name:           "web-1",
+		DriverName:     "none",
+		SwarmDiscovery: "token://1234",
+		Labels:         map[string]string{"env": "staging", "role": "web"},
+	}
+
+	tests := []struct {
+		filters []string
+		match   bool
+	}{
+		{[]string{}, true},
+		{[]string{"label=env"}, true},
+		{[]string{"label=env=staging"}, true},
+		{[]string{"label=env=staging", "label=role=web"}, true},
+		{[]string{"label=env=production"}, false},
+		{[]string{"label=env=staging", "label=role=db"}, false},
+		{[]string{"label=team"}, false},
+		{[]string{"driver=none"}, true},
+		{[]string{"driver=virtualbox"}, false},
+		{[]string{"name=web-*"}, true},
+		{[]string{"name=db-*"}, false},
+		{[]string{"swarm=token://1234"}, true},
+		{[]string{"swarm=token://5678"}, false},
+	}
+
+	for _, test := range tests {
+		filters, err := parseFilters(test.filters, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match := matchesFilters(host, filters); match != test.match {
+			t.Fatalf("expected %v to match %v; received %v", test.filters, test.match, match)
+		}
+	}
+
+	for _, filter := range []string{"label", "label=", "name=[", "color=red"} {
+		if _, err := parseFilters([]string{filter}, nil); err == nil {
+			t.Fatalf("expected error for filter %q", filter)
+		}
+	}
+}
+
+func TestSplitStateFilters(t *testing.T) {
+	others, states := splitStateFilters([]string{"label=env", "state=Running", "state=", "driver=none"})
+	if !reflect.DeepEqual(others, []string{"label=env", "state=", "driver=none"}) {
+		t.Fatalf("unexpected other filters %v", others)
+	}
+	if !reflect.DeepEqual(states, []string{"Running"}) {
+		t.Fatalf("unexpected state filters %v", states)
+	}
+
+	if !matchesStates(state.Running, states) {
+		t.Fatal("expected Running to match state=Running")
+	}
+	if matchesStates(state.Timeout, states) {
+		t.Fatal("expected Timeout not to match state=Running")
+	}
+	if !matchesStates(state.Stopped, nil) {
+		t.Fatal("expected any state to match without state filters")
+	}
+}
+
+func getTestSelectorStore(t *testing.T) *Filestore {
+	store, err := getTestStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"web-1", "web-2", "db-1"} {
+		flags := getTestDriverFlags()
+		flags.Data["label"] = []string{"role=" + name[:len(name)-2]}
+		if _, err := store.Create(name, hostTestDriverName, flags, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return store
+}
+
+func selectedNames(t *testing.T, store Store, selector hostSelector) []string {
+	hosts, err := selector.selectHosts(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, host := range hosts {
+		names = append(names, host.Name)
+	}
+	return names
+}
+
+func TestHostSelector(t *testing.T) {
+	store := getTestSelectorStore(t)
+	defer os.RemoveAll(store.Path)
+
+	tests := []struct {
+		selector hostSelector
+		expected []string
+		bulk     bool
+	}{
+		{hostSelector{Names: []string{"web-2", "db-1"}}, []string{"web-2", "db-1"}, false},
+		{hostSelector{Names: []string{"web-*"}}, []string{"web-1", "web-2"}, true},
+		{hostSelector{Names: []string{"web-1", "web-*"}}, []string{"web-1", "web-2"}, true},
+		{hostSelector{All: true}, []string{"db-1", "web-1", "web-2"}, true},
+		{hostSelector{Filters: []string{"label=role=db"}}, []string{"db-1"}, true},
+		{hostSelector{Names: []string{"web-*"}, Filters: []string{"state=Running"}}, nil, true},
+		{hostSelector{Names: []string{"*-1"}, Filters: []string{"driver=none"}}, []string{"db-1", "web-1"}, true},
+	}
+
+	for _, test := range tests {
+		if bulk := test.selector.isBulk(); bulk != test.bulk {
+			t.Fatalf("expected %+v bulk to be %v", test.selector, test.bulk)
+		}
+		if test.expected == nil {
+			if _, err := test.selector.selectHosts(store); err == nil {
+				t.Fatalf("expected %+v to fail", test.selector)
+			}
+			continue
+		}
+		if names := selectedNames(t, store, test.selector); !reflect.DeepEqual(names, test.expected) {
+			t.Fatalf("expected %+v to select %v; received %v", test.selector, test.expected, names)
+		}
+	}
+
+	for _, selector := range []hostSelector{
+		{Names: []string{"app-*"}},
+		{Names: []string{"missing"}},
+		{Filters: []string{"label=role=app"}},
+		{Filters: []string{"swarm=web-1"}},
+	} {
+		if _, err := selector.selectHosts(store); err == nil {
+			t.Fatalf("expected %+v to fail", selector)
+		}
+	}
+}
+
+func TestHostSelectorStates(t *testing.T) {
+	store := getTestSelectorStore(t)
+	defer os.RemoveAll(store.Path)
+
+	// the cached states are used rather than the ones of the driver
+	for _, name := range []string{"web-1", "db-1"} {
+		host, err := store.Load(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		host.saveStateCache(&hostState{State: state.Running, UpdatedAt: time.Now()})
+	}
+
+	tests := []struct {
+		selector hostSelector
+		expected []string
+	}{
+		{hostSelector{Filters: []string{"state=Running"}}, []string{"db-1", "web-1"}},
+		{hostSelector{Filters: []string{"state=Running", "label=role=web"}}, []string{"web-1"}},
+		{hostSelector{Names: []string{"web-*"}, Filters: []string{"state=running"}}, []string{"web-1"}},
+	}
+
+	for _, test := range tests {
+		if names := selectedNames(t, store, test.selector); !reflect.DeepEqual(names, test.expected) {
+			t.Fatalf("expected %+v to select %v; received %v", test.selector, test.expected, names)
+		}
+	}
+}
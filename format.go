@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/template"
+
+	"github.com/codegangsta/cli"
+)
+
+// jsonFormat is the --format value which prints the output as JSON
+const jsonFormat = "json"
+
+var formatFlag = cli.StringFlag{
+	Name:  "format",
+	Usage: "Print the output as json, or with a Go template, e.g. '{{.Name}} {{.URL}}'",
+	Value: "",
+}
+
+// The JSON schemas of the --format json output. Fields are only ever added
+// to them, so scripts can rely on the existing ones.
+
+// listItemJSON is an entry of the ls output
+type listItemJSON struct {
+	Name        string `json:"name"`
+	Active      bool   `json:"active"`
+	Driver      string `json:"driver"`
+	State       string `json:"state"`
+	URL         string `json:"url"`
+	Swarm       string `json:"swarm"`
+	SwarmMaster bool   `json:"swarmMaster"`
+	Error       string `json:"error"`
+	IP          string `json:"ip"`
+	Created     string `json:"created"`
+	Docker      string `json:"docker"`
+	Size        string `json:"size"`
+}
+
+// inspectJSON is the inspect output
+type inspectJSON struct {
+	Name            string            `json:"name"`
+	Driver          string            `json:"driver"`
+	Labels          map[string]string `json:"labels"`
+	Swarm           swarmJSON         `json:"swarm"`
+	TLS             tlsJSON           `json:"tls"`
+	CompletedPhases []string          `json:"completedPhases"`
+}
+
+type swarmJSON struct {
+	Enabled   bool   `json:"enabled"`
+	Master    bool   `json:"master"`
+	Discovery string `json:"discovery"`
+	Host      string `json:"host"`
+	Addr      string `json:"addr"`
+}
+
+type tlsJSON struct {
+	CaCert     string `json:"caCert"`
+	ServerCert string `json:"serverCert"`
+	ServerKey  string `json:"serverKey"`
+	ClientCert string `json:"clientCert"`
+}
+
+// ipJSON is the ip output
+type ipJSON struct {
+	Name string `json:"name"`
+	IP   string `json:"ip"`
+}
+
+// urlJSON is the url output
+type urlJSON struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// configJSON is the config output
+type configJSON struct {
+	Name       string `json:"name"`
+	Host       string `json:"host"`
+	TLSCaCert  string `json:"tlsCaCert"`
+	TLSCert    string `json:"tlsCert"`
+	TLSKey     string `json:"tlsKey"`
+	SwarmAgent bool   `json:"swarm"`
+}
+
+func newInspectJSON(host *Host) inspectJSON {
+	labels := host.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	phases := host.CompletedPhases
+	if phases == nil {
+		phases = []string{}
+	}
+
+	return inspectJSON{
+		Name:   host.Name,
+		Driver: host.DriverName,
+		Labels: labels,
+		Swarm: swarmJSON{
+			Enabled:   host.Swarm,
+			Master:    host.SwarmMaster,
+			Discovery: host.SwarmDiscovery,
+			Host:      host.SwarmHost,
+			Addr:      host.SwarmAddr,
+		},
+		TLS: tlsJSON{
+			CaCert:     host.CaCertPath,
+			ServerCert: host.ServerCertPath,
+			ServerKey:  host.ServerKeyPath,
+			ClientCert: host.ClientCertPath,
+		},
+		CompletedPhases: phases,
+	}
+}
+
+// parseTemplate parses a --format template
+func parseTemplate(format string) (*template.Template, error) {
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid format: %s", err)
+	}
+	return tmpl, nil
+}
+
+// writeTemplate executes the template with data and ends the output with a
+// newline
+func writeTemplate(w io.Writer, tmpl *template.Template, data interface{}) error {
+	if err := tmpl.Execute(w, data); err != nil {
+		return fmt.Errorf("error executing format: %s", err)
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+func writeJSON(w io.Writer, data interface{}) error {
+	out, err := json.MarshalIndent(data, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(out))
+	return err
+}
+
+// writeFormatted writes data as JSON if format is json, and otherwise with
+// format as a template
+func writeFormatted(w io.Writer, format string, data interface{}) error {
+	if format == jsonFormat {
+		return writeJSON(w, data)
+	}
+
+	tmpl, err := parseTemplate(format)
+	if err != nil {
+		return err
+	}
+	return writeTemplate(w, tmpl, data)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/machine/drivers/amazonec2"
+	"github.com/docker/machine/state"
+)
+
+func TestWriteFormattedJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeFormatted(&out, jsonFormat, urlJSON{Name: "test", URL: "tcp://1.2.3.4:2376"}); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := map[string]string{}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["name"] != "test" || decoded["url"] != "tcp://1.2.3.4:2376" {
+		t.Fatalf("unexpected JSON output: %s", out.String())
+	}
+}
+
+func TestWriteFormattedTemplate(t *testing.T) {
+	var out bytes.Buffer
+	item := hostListItem{Name: "test", State: state.Running, URL: "tcp://1.2.3.4:2376"}
+	if err := writeFormatted(&out, "{{.Name}} {{.State}} {{.URL}}", item); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "test Running tcp://1.2.3.4:2376\n"; out.String() != expected {
+		t.Fatalf("expected %q; received %q", expected, out.String())
+	}
+
+	if err := writeFormatted(&out, "{{.Name", item); err == nil {
+		t.Fatal("expected an error for an invalid template")
+	}
+}
+
+func TestInspectJSON(t *testing.T) {
+	host, err := NewHost("test", "amazonec2", "", "", "", false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.Labels = map[string]string{"env": "prod"}
+
+	var out bytes.Buffer
+	if err := writeJSON(&out, newInspectJSON(host)); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"name", "driver", "labels", "swarm", "tls", "completedPhases"} {
+		if _, ok := decoded[key]; !ok {
+			t.Fatalf("expected key %s in %s", key, out.String())
+		}
+	}
+	if _, ok := decoded["Driver"]; ok {
+		t.Fatalf("expected no driver internals in %s", out.String())
+	}
+}
+
+func TestInspectTemplateRedactsSecrets(t *testing.T) {
+	host, err := NewHost("test", "amazonec2", "", "", "", false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.Driver.(*amazonec2.Driver).SecretKey = "secret"
+
+	data, err := json.Marshal(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err = redactSecrets(data, host.Driver); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := writeInspectTemplate(&out, "{{.Name}} {{.DriverName}} {{.Driver.SecretKey}}", host.Name, data); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "test amazonec2 " + redactedSecret + "\n"; out.String() != expected {
+		t.Fatalf("expected %q; received %q", expected, out.String())
+	}
+}
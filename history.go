@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/docker/machine/drivers"
+)
+
+// hostEvent is an entry of the event log of a host
+type hostEvent struct {
+	Time   time.Time
+	Action string
+	Error  string `json:",omitempty"`
+}
+
+func (h *Host) eventLogPath() string {
+	return filepath.Join(h.storePath, "events.log")
+}
+
+// recordEvent appends an action and its outcome to the event log of the
+// host. The log is kept next to the config, so it is removed with the host.
+func (h *Host) recordEvent(action string, actionErr error) {
+	event := hostEvent{Time: time.Now(), Action: action}
+	if actionErr != nil {
+		event.Error = actionErr.Error()
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Debugf("error encoding event of host %s: %s", h.Name, err)
+		return
+	}
+
+	f, err := os.OpenFile(h.eventLogPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Debugf("error opening event log of host %s: %s", h.Name, err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Debugf("error writing event log of host %s: %s", h.Name, err)
+	}
+}
+
+// events returns the event log of the host, oldest first
+func (h *Host) events() ([]hostEvent, error) {
+	events := []hostEvent{}
+
+	f, err := os.Open(h.eventLogPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return events, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event hostEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// skip lines torn by an interrupted write
+			log.Debugf("error reading event log of host %s: %s", h.Name, err)
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// flagLister is implemented by driver options which can list their flags,
+// such as the *cli.Context of create
+type flagLister interface {
+	FlagNames() []string
+	IsSet(name string) bool
+	Generic(name string) interface{}
+}
+
+// createFlags returns the create flags given on the command line and the
+// flags of the driver, with the values of the secrets of the driver
+// redacted. It returns nil if the flags cannot be listed.
+func createFlags(flags drivers.DriverOptions, driverName string, d drivers.Driver) map[string][]string {
+	lister, ok := flags.(flagLister)
+	if !ok {
+		return nil
+	}
+
+	secrets := map[string]bool{}
+	if data, err := json.Marshal(d); err == nil {
+		// wrap the driver config like a host config for mapSecrets
+		data = []byte(fmt.Sprintf(`{"Driver":%s}`, data))
+		mapSecrets(data, d, func(value string) (string, error) {
+			if value != "" {
+				secrets[value] = true
+			}
+			return value, nil
+		})
+	}
+
+	recorded := map[string][]string{}
+	for _, name := range lister.FlagNames() {
+		if !lister.IsSet(name) && !strings.HasPrefix(name, driverName+"-") {
+			continue
+		}
+
+		var values []string
+		switch value := lister.Generic(name).(type) {
+		case *cli.StringSlice:
+			values = append(values, []string(*value)...)
+		case fmt.Stringer:
+			values = []string{value.String()}
+		default:
+			continue
+		}
+
+		for i, value := range values {
+			if secrets[value] {
+				values[i] = redactedSecret
+			}
+		}
+		recorded[name] = values
+	}
+	return recorded
+}
+
+// formatCreateFlags formats recorded create flags as command line options
+func formatCreateFlags(flags map[string][]string) string {
+	names := []string{}
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	options := []string{}
+	for _, name := range names {
+		for _, value := range flags[name] {
+			options = append(options, fmt.Sprintf("--%s=%s", name, value))
+		}
+	}
+	return strings.Join(options, " ")
+}
+
+// historyJSON is the history output
+type historyJSON struct {
+	Name           string              `json:"name"`
+	Created        string              `json:"created"`
+	MachineVersion string              `json:"machineVersion"`
+	CreateFlags    map[string][]string `json:"createFlags"`
+	Events         []eventJSON         `json:"events"`
+}
+
+type eventJSON struct {
+	Time   string `json:"time"`
+	Action string `json:"action"`
+	Error  string `json:"error"`
+}
+
+func cmdHistory(c *cli.Context) {
+	host := getHost(c)
+
+	events, err := host.events()
+	if err != nil {
+		log.Fatalf("error reading event log: %s", err)
+	}
+
+	created := ""
+	if !host.CreatedAt.IsZero() {
+		created = host.CreatedAt.Local().Format(createdLayout)
+	}
+
+	if format := c.String("format"); format != "" {
+		history := historyJSON{
+			Name:           host.Name,
+			Created:        created,
+			MachineVersion: host.MachineVersion,
+			CreateFlags:    host.CreateFlags,
+			Events:         []eventJSON{},
+		}
+		if history.CreateFlags == nil {
+			history.CreateFlags = map[string][]string{}
+		}
+		for _, event := range events {
+			history.Events = append(history.Events, eventJSON{
+				Time:   event.Time.Format(time.RFC3339),
+				Action: event.Action,
+				Error:  event.Error,
+			})
+		}
+		if err := writeFormatted(os.Stdout, format, history); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if created == "" {
+		fmt.Println("Created:        unknown")
+	} else {
+		fmt.Printf("Created:        %s with machine %s (%s driver)\n",
+			created, host.MachineVersion, host.DriverName)
+	}
+	if len(host.CreateFlags) > 0 {
+		fmt.Printf("Create options: %s\n", formatCreateFlags(host.CreateFlags))
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 5, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tRESULT")
+	for _, event := range events {
+		result := "OK"
+		if event.Error != "" {
+			result = fmt.Sprintf("Error: %s", event.Error)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", event.Time.Local().Format(createdLayout), event.Action, result)
+	}
+	w.Flush()
+}
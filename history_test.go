@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/codegangsta/cli"
+	"github.com/docker/machine/drivers/amazonec2"
+)
+
+func TestEventLog(t *testing.T) {
+	host := getTestCacheHost(t)
+	defer os.RemoveAll(host.storePath)
+
+	events, err := host.events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no events; received %v", events)
+	}
+
+	host.recordEvent("create", nil)
+	host.recordEvent("stop", errors.New("host not found"))
+
+	events, err = host.events()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events; received %v", events)
+	}
+	if events[0].Action != "create" || events[0].Error != "" {
+		t.Fatalf("unexpected first event %v", events[0])
+	}
+	if events[1].Action != "stop" || events[1].Error != "host not found" {
+		t.Fatalf("unexpected second event %v", events[1])
+	}
+}
+
+func TestCreateFlags(t *testing.T) {
+	host, err := NewHost("test", "amazonec2", "", "", "", false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.Driver.(*amazonec2.Driver).SecretKey = "s3cret"
+
+	command := cli.Command{
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "amazonec2-secret-key"},
+			cli.StringFlag{Name: "amazonec2-region", Value: "us-east-1"},
+			cli.StringFlag{Name: "virtualbox-memory", Value: "1024"},
+			cli.StringSliceFlag{Name: "label", Value: &cli.StringSlice{}},
+			cli.BoolFlag{Name: "swarm"},
+		},
+	}
+	set := flag.NewFlagSet("create", flag.ContinueOnError)
+	for _, f := range command.Flags {
+		f.Apply(set)
+	}
+	if err := set.Parse([]string{"--amazonec2-secret-key=s3cret", "--label=env=prod", "--label=role=web"}); err != nil {
+		t.Fatal(err)
+	}
+	c := cli.NewContext(nil, set, nil)
+	c.Command = command
+
+	recorded := createFlags(c, "amazonec2", host.Driver)
+
+	expected := "--amazonec2-region=us-east-1 --amazonec2-secret-key=" + redactedSecret + " --label=env=prod --label=role=web"
+	if options := formatCreateFlags(recorded); options != expected {
+		t.Fatalf("expected %q; received %q", expected, options)
+	}
+}
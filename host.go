@@ -18,7 +18,9 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/machine/drivers"
+	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/utils"
+	"github.com/docker/machine/wait"
 )
 
 var (
@@ -28,23 +30,39 @@ var (
 )
 
 const (
+	// machineStep is the create step recorded for drivers which do not
+	// record the resources they create; it is rolled back with Remove
+	machineStep = "machine"
+
 	swarmDockerImage              = "swarm:latest"
 	swarmDiscoveryServiceEndpoint = "https://discovery-stage.hub.docker.com/v1"
 )
 
 type Host struct {
-	Name           string `json:"-"`
-	DriverName     string
-	Driver         drivers.Driver
-	CaCertPath     string
-	ServerCertPath string
-	ServerKeyPath  string
-	PrivateKeyPath string
-	ClientCertPath string
-	SwarmMaster    bool
-	SwarmHost      string
-	SwarmDiscovery string
-	storePath      string
+	Name                string `json:"-"`
+	ConfigVersion       int
+	DriverName          string
+	DriverConfigVersion int
+	Driver              drivers.Driver
+	CaCertPath          string
+	ServerCertPath      string
+	ServerKeyPath       string
+	PrivateKeyPath      string
+	ClientCertPath      string
+	SwarmMaster         bool
+	SwarmHost           string
+	SwarmDiscovery      string
+	Swarm               bool
+	SwarmAddr           string
+	Labels              map[string]string
+	CompletedPhases     []string
+	CreatedAt           time.Time
+	MachineVersion      string
+	CreateFlags         map[string][]string
+	CreateSteps         []drivers.CreateStep `json:",omitempty"`
+	storePath           string
+	store               Store
+	waiter              *wait.Waiter
 }
 
 type DockerConfig struct {
@@ -56,17 +74,16 @@ type hostConfig struct {
 	DriverName string
 }
 
-func waitForDocker(addr string) error {
-	for {
+func (h *Host) waitForDocker(addr string) error {
+	return h.waiter.Until(fmt.Sprintf("Docker to listen on %s", addr), 0, func() (bool, error) {
 		conn, err := net.DialTimeout("tcp", addr, time.Second*5)
 		if err != nil {
-			time.Sleep(time.Second * 5)
-			continue
+			log.Debugf("error connecting to Docker on %s: %s", addr, err)
+			return false, nil
 		}
 		conn.Close()
-		break
-	}
-	return nil
+		return true, nil
+	})
 }
 
 func NewHost(name, driverName, storePath, caCert, privateKey string, swarmMaster bool, swarmHost string, swarmDiscovery string) (*Host, error) {
@@ -75,28 +92,22 @@ func NewHost(name, driverName, storePath, caCert, privateKey string, swarmMaster
 		return nil, err
 	}
 	return &Host{
-		Name:           name,
-		DriverName:     driverName,
-		Driver:         driver,
-		CaCertPath:     caCert,
-		PrivateKeyPath: privateKey,
-		SwarmMaster:    swarmMaster,
-		SwarmHost:      swarmHost,
-		SwarmDiscovery: swarmDiscovery,
-		storePath:      storePath,
+		Name:                name,
+		ConfigVersion:       hostConfigVersion,
+		DriverName:          driverName,
+		DriverConfigVersion: drivers.ConfigVersion(driverName),
+		Driver:              driver,
+		CaCertPath:          caCert,
+		PrivateKeyPath:      privateKey,
+		SwarmMaster:         swarmMaster,
+		SwarmHost:           swarmHost,
+		SwarmDiscovery:      swarmDiscovery,
+		storePath:           storePath,
 	}, nil
 }
 
 func LoadHost(name string, storePath string) (*Host, error) {
-	if _, err := os.Stat(storePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("Host %q does not exist", name)
-	}
-
-	host := &Host{Name: name, storePath: storePath}
-	if err := host.LoadConfig(); err != nil {
-		return nil, err
-	}
-	return host, nil
+	return NewFilestore(filepath.Dir(storePath), "", "").Load(name)
 }
 
 func ValidateHostName(name string) (string, error) {
@@ -138,15 +149,16 @@ func (h *Host) ConfigureSwarm(discovery string, master bool, host string, addr s
 	parts := strings.Split(u.Host, ":")
 	port := parts[1]
 
-	if err := waitForDocker(addr); err != nil {
+	if err := h.waitForDocker(addr); err != nil {
 		return err
 	}
 
-	cmd, err := d.GetSSHCommand(fmt.Sprintf("sudo docker pull %s", swarmDockerImage))
+	client, err := h.sshClient()
 	if err != nil {
 		return err
 	}
-	if err := cmd.Run(); err != nil {
+
+	if err := client.Run(fmt.Sprintf("sudo docker pull %s", swarmDockerImage)); err != nil {
 		return err
 	}
 
@@ -154,12 +166,8 @@ func (h *Host) ConfigureSwarm(discovery string, master bool, host string, addr s
 	if master {
 		log.Debug("launching swarm master")
 		log.Debugf("master args: %s", masterArgs)
-		cmd, err = d.GetSSHCommand(fmt.Sprintf("sudo docker run -d -p %s:%s --restart=always --name swarm-agent-master -v %s:%s %s manage %s",
-			port, port, d.GetDockerConfigDir(), d.GetDockerConfigDir(), swarmDockerImage, masterArgs))
-		if err != nil {
-			return err
-		}
-		if err := cmd.Run(); err != nil {
+		if err := client.Run(fmt.Sprintf("sudo docker rm -f swarm-agent-master >/dev/null 2>&1; sudo docker run -d -p %s:%s --restart=always --name swarm-agent-master -v %s:%s %s manage %s",
+			port, port, d.GetDockerConfigDir(), d.GetDockerConfigDir(), swarmDockerImage, masterArgs)); err != nil {
 			return err
 		}
 	}
@@ -167,12 +175,8 @@ func (h *Host) ConfigureSwarm(discovery string, master bool, host string, addr s
 	// start node agent
 	log.Debug("launching swarm node")
 	log.Debugf("node args: %s", nodeArgs)
-	cmd, err = d.GetSSHCommand(fmt.Sprintf("sudo docker run -d --restart=always --name swarm-agent -v %s:%s %s join %s",
-		d.GetDockerConfigDir(), d.GetDockerConfigDir(), swarmDockerImage, nodeArgs))
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if err := client.Run(fmt.Sprintf("sudo docker rm -f swarm-agent >/dev/null 2>&1; sudo docker run -d --restart=always --name swarm-agent -v %s:%s %s join %s",
+		d.GetDockerConfigDir(), d.GetDockerConfigDir(), swarmDockerImage, nodeArgs)); err != nil {
 		return err
 	}
 
@@ -202,27 +206,16 @@ func (h *Host) ConfigureAuth() error {
 		log.Fatalf("Error copying key.pem to machine dir: %s", err)
 	}
 
-	var (
-		ip         = ""
-		ipErr      error
-		maxRetries = 4
-	)
-
-	for i := 0; i < maxRetries; i++ {
-		ip, ipErr = h.Driver.GetIP()
-		if ip != "" {
-			break
+	var ip string
+	if err := h.waiter.Until(fmt.Sprintf("the IP of %s", h.Name), time.Minute, func() (bool, error) {
+		var err error
+		if ip, err = h.Driver.GetIP(); ip == "" {
+			log.Debugf("waiting for ip: %s", err)
+			return false, nil
 		}
-		log.Debugf("waiting for ip: %s", ipErr)
-		time.Sleep(5 * time.Second)
-	}
-
-	if ipErr != nil {
-		return ipErr
-	}
-
-	if ip == "" {
-		return fmt.Errorf("unable to get machine IP")
+		return true, nil
+	}); err != nil {
+		return fmt.Errorf("unable to get machine IP: %s", err)
 	}
 
 	serverCertPath := filepath.Join(h.storePath, "server.pem")
@@ -238,7 +231,8 @@ func (h *Host) ConfigureAuth() error {
 		org,
 	)
 
-	if err := utils.GenerateCert([]string{ip}, serverCertPath, serverKeyPath, h.CaCertPath, h.PrivateKeyPath, org, bits); err != nil {
+	// the cert is also valid for the local end of tunnel --docker
+	if err := utils.GenerateCert([]string{ip, tunnelLocalHost, "localhost"}, serverCertPath, serverKeyPath, h.CaCertPath, h.PrivateKeyPath, org, bits); err != nil {
 		return fmt.Errorf("error generating server cert: %s", err)
 	}
 
@@ -246,11 +240,12 @@ func (h *Host) ConfigureAuth() error {
 		return err
 	}
 
-	cmd, err := d.GetSSHCommand(fmt.Sprintf("sudo mkdir -p %s", d.GetDockerConfigDir()))
+	client, err := h.sshClient()
 	if err != nil {
 		return err
 	}
-	if err := cmd.Run(); err != nil {
+
+	if err := client.Run(fmt.Sprintf("sudo mkdir -p %s", d.GetDockerConfigDir())); err != nil {
 		return err
 	}
 
@@ -276,27 +271,15 @@ func (h *Host) ConfigureAuth() error {
 	}
 	machineServerKeyPath := path.Join(d.GetDockerConfigDir(), "server-key.pem")
 
-	cmd, err = d.GetSSHCommand(fmt.Sprintf("echo \"%s\" | sudo tee -a %s", string(caCert), machineCaCertPath))
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if err := client.Run(fmt.Sprintf("echo \"%s\" | sudo tee %s", string(caCert), machineCaCertPath)); err != nil {
 		return err
 	}
 
-	cmd, err = d.GetSSHCommand(fmt.Sprintf("echo \"%s\" | sudo tee -a %s", string(serverKey), machineServerKeyPath))
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if err := client.Run(fmt.Sprintf("echo \"%s\" | sudo tee %s", string(serverKey), machineServerKeyPath)); err != nil {
 		return err
 	}
 
-	cmd, err = d.GetSSHCommand(fmt.Sprintf("echo \"%s\" | sudo tee -a %s", string(serverCert), machineServerCertPath))
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if err := client.Run(fmt.Sprintf("echo \"%s\" | sudo tee %s", string(serverCert), machineServerCertPath)); err != nil {
 		return err
 	}
 
@@ -320,11 +303,7 @@ func (h *Host) ConfigureAuth() error {
 
 	cfg := h.generateDockerConfig(dockerPort, machineCaCertPath, machineServerKeyPath, machineServerCertPath)
 
-	cmd, err = d.GetSSHCommand(fmt.Sprintf("echo \"%s\" | sudo tee -a %s", cfg.EngineConfig, cfg.EngineConfigPath))
-	if err != nil {
-		return err
-	}
-	if err := cmd.Run(); err != nil {
+	if err := client.Run(fmt.Sprintf("echo \"%s\" | sudo tee -a %s", cfg.EngineConfig, cfg.EngineConfigPath)); err != nil {
 		return err
 	}
 
@@ -382,22 +361,46 @@ func (h *Host) Create(name string) error {
 		return err
 	}
 
-	// create the instance
-	if err := h.Driver.Create(); err != nil {
-		return err
-	}
+	return h.runPhases()
+}
 
-	// install docker
-	if err := h.Provision(); err != nil {
-		return err
+// setWaiter limits the waits of the host and its driver to those of w, see
+// wait.New
+func (h *Host) setWaiter(w *wait.Waiter) {
+	h.waiter = w
+	if limited, ok := h.Driver.(drivers.WaitLimited); ok {
+		limited.SetWaiter(w)
 	}
+}
 
-	// save to store
-	if err := h.SaveConfig(); err != nil {
-		return err
+// knownHostsPath returns the file the host key of the machine is pinned in
+func (h *Host) knownHostsPath() string {
+	return drivers.KnownHostsPath(h.storePath)
+}
+
+// sshAddress returns the SSH address of the machine as given by its driver,
+// with the host key pinned on the first connection and checked on the others.
+// The host key is pinned where the driver keeps it, or next to the config of
+// the host for drivers which do not.
+func (h *Host) sshAddress() (*ssh.Address, error) {
+	addr, err := drivers.GetSSHAddressFromDriver(h.Driver)
+	if err != nil {
+		return nil, err
+	}
+	if addr.KnownHostsFile == "" && h.storePath != "" {
+		addr.KnownHostsFile = h.knownHostsPath()
+		addr.HostKeyAlias = h.Name
 	}
+	return addr, nil
+}
 
-	return nil
+// sshClient returns a client running commands on the machine
+func (h *Host) sshClient() (ssh.Client, error) {
+	addr, err := h.sshAddress()
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewClient(addr)
 }
 
 func (h *Host) Provision() error {
@@ -409,19 +412,14 @@ func (h *Host) Provision() error {
 
 	// install docker - until cloudinit we use ubuntu everywhere so we
 	// just install it using the docker repos
-	cmd, err := h.Driver.GetSSHCommand("if [ ! -e /usr/bin/docker ]; then curl -sSL https://get.docker.com | sh -; fi")
+	client, err := h.sshClient()
 	if err != nil {
 		return err
 	}
 
-	// HACK: the script above will output debug to stderr; we save it and
-	// then check if the command returned an error; if so, we show the debug
-
-	var buf bytes.Buffer
-	cmd.Stderr = &buf
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("error installing docker: %s\n%s\n", err, string(buf.Bytes()))
+	// the script above outputs debug; it is only shown if it fails
+	if out, err := client.Output("if [ ! -e /usr/bin/docker ]; then curl -sSL https://get.docker.com | sh -; fi"); err != nil {
+		return fmt.Errorf("error installing docker: %s\n%s\n", err, out)
 	}
 
 	return nil
@@ -440,7 +438,7 @@ func (h *Host) Upgrade() error {
 }
 
 func (h *Host) Remove(force bool) error {
-	if err := h.Driver.Remove(); err != nil {
+	if err := h.removeFromProvider(); err != nil {
 		if !force {
 			return err
 		}
@@ -448,6 +446,52 @@ func (h *Host) Remove(force bool) error {
 	return h.removeStorePath()
 }
 
+// removeFromProvider removes the host from the provider. The resources of a
+// host whose create did not finish are removed by rolling back the create.
+func (h *Host) removeFromProvider() error {
+	if len(h.CreateSteps) > 0 {
+		return h.rollbackCreate(nil)
+	}
+	return h.Driver.Remove()
+}
+
+func (h *Host) recordCreateStep(step drivers.CreateStep) error {
+	h.CreateSteps = append(h.CreateSteps, step)
+	return h.SaveConfig()
+}
+
+// rollbackCreate undoes the recorded steps of an unfinished create in reverse
+// order, waiting with waiter. Undone steps are removed from the config, so an
+// interrupted rollback can be resumed.
+func (h *Host) rollbackCreate(waiter *wait.Waiter) error {
+	h.setWaiter(waiter)
+	for len(h.CreateSteps) > 0 {
+		step := h.CreateSteps[len(h.CreateSteps)-1]
+
+		if step.Resource == machineStep {
+			log.Infof("Removing machine %s...", h.Name)
+			if err := h.Driver.Remove(); err != nil {
+				return fmt.Errorf("error removing machine: %s", err)
+			}
+		} else {
+			rollbacker, ok := h.Driver.(drivers.Rollbacker)
+			if !ok {
+				return fmt.Errorf("driver %s cannot remove %s %s", h.DriverName, step.Resource, step.ID)
+			}
+			log.Infof("Removing %s %s...", step.Resource, step.ID)
+			if err := rollbacker.Rollback(step); err != nil {
+				return fmt.Errorf("error removing %s %s: %s", step.Resource, step.ID, err)
+			}
+		}
+
+		h.CreateSteps = h.CreateSteps[:len(h.CreateSteps)-1]
+		if err := h.SaveConfig(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (h *Host) removeStorePath() error {
 	file, err := os.Stat(h.storePath)
 	if err != nil {
@@ -463,8 +507,45 @@ func (h *Host) GetURL() (string, error) {
 	return h.Driver.GetURL()
 }
 
+// getStore returns the store the host was loaded from. Hosts that were not
+// loaded through a store are kept on the filesystem next to their siblings.
+func (h *Host) getStore() Store {
+	if h.store == nil {
+		h.store = NewFilestore(filepath.Dir(h.storePath), h.CaCertPath, h.PrivateKeyPath)
+	}
+	return h.store
+}
+
 func (h *Host) LoadConfig() error {
-	data, err := ioutil.ReadFile(filepath.Join(h.storePath, "config.json"))
+	host, err := h.getStore().Load(h.Name)
+	if err != nil {
+		return err
+	}
+	*h = *host
+	return nil
+}
+
+func (h *Host) SaveConfig() error {
+	return h.getStore().Save(h)
+}
+
+// marshalConfig returns the config of the host as it is persisted
+func (h *Host) marshalConfig() ([]byte, error) {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase := getSecretPassphrase()
+	if passphrase == "" {
+		return data, warnPlaintextSecrets(data, h.Driver)
+	}
+	return encryptSecrets(data, h.Driver, passphrase)
+}
+
+// decodeConfig loads the host and its driver from a persisted config
+func (h *Host) decodeConfig(data []byte) error {
+	data, err := decryptSecrets(data, getSecretPassphrase())
 	if err != nil {
 		return err
 	}
@@ -489,13 +570,15 @@ func (h *Host) LoadConfig() error {
 	return nil
 }
 
-func (h *Host) SaveConfig() error {
-	data, err := json.Marshal(h)
-	if err != nil {
-		return err
-	}
-	if err := ioutil.WriteFile(filepath.Join(h.storePath, "config.json"), data, 0600); err != nil {
-		return err
+// decodeConfigMap decodes a persisted config for inspection and migration
+// without loading it into a host
+func decodeConfigMap(data []byte) (map[string]interface{}, error) {
+	config := map[string]interface{}{}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&config); err != nil {
+		return nil, err
 	}
-	return nil
+	return config, nil
 }
@@ -19,7 +19,7 @@ const (
 	hostTestPrivateKey = "test-key"
 )
 
-func getTestStore() (*Store, error) {
+func getTestStore() (*Filestore, error) {
 	tmpDir, err := ioutil.TempDir("", "machine-test-")
 	if err != nil {
 		fmt.Println(err)
@@ -27,7 +27,7 @@ func getTestStore() (*Store, error) {
 	}
 	os.Setenv("MACHINE_STORAGE_PATH", tmpDir)
 
-	return NewStore(tmpDir, hostTestCaCert, hostTestPrivateKey), nil
+	return NewFilestore(tmpDir, hostTestCaCert, hostTestPrivateKey), nil
 }
 
 func getTestDriverFlags() *DriverOptionsMock {
@@ -40,6 +40,9 @@ func getTestDriverFlags() *DriverOptionsMock {
 			"swarm-host":      "",
 			"swarm-master":    false,
 			"swarm-discovery": "",
+			"label":           []string{},
+			"keep-on-failure": false,
+			"swarm-addr":      "",
 		},
 	}
 	return flags
@@ -161,7 +164,7 @@ func TestMachinePort(t *testing.T) {
 	}
 	flags := getTestDriverFlags()
 
-	_, err = store.Create(hostTestName, hostTestDriverName, flags)
+	_, err = store.Create(hostTestName, hostTestDriverName, flags, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -201,7 +204,7 @@ func TestMachineCustomPort(t *testing.T) {
 	}
 	flags := getTestDriverFlags()
 
-	_, err = store.Create(hostTestName, hostTestDriverName, flags)
+	_, err = store.Create(hostTestName, hostTestDriverName, flags, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -239,7 +242,7 @@ func TestHostConfig(t *testing.T) {
 	}
 
 	flags := getTestDriverFlags()
-	host, err := store.Create(hostTestName, hostTestDriverName, flags)
+	host, err := store.Create(hostTestName, hostTestDriverName, flags, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
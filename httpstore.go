@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/machine/drivers"
+	"github.com/docker/machine/utils"
+	"github.com/docker/machine/wait"
+)
+
+const (
+	httpStoreMachinesKey = "machines"
+	httpStoreActiveKey   = "active"
+	httpStoreBackupsKey  = "backups"
+	httpStoreLocksKey    = "locks"
+	httpStoreFilesKey    = "files"
+
+	// httpStoreMaxFileSize is the size up to which the files of a machine
+	// are kept in the store. Larger files, such as disk images, stay on the
+	// workstation which created the machine.
+	httpStoreMaxFileSize = 1 << 20
+)
+
+// httpStoreLocalFiles are the files of a machine which describe its use on
+// one workstation, and are not shared through the store
+var httpStoreLocalFiles = map[string]bool{
+	"events.log":  true,
+	"state.json":  true,
+	"tunnel.json": true,
+}
+
+var errKeyNotFound = errors.New("key not found")
+
+// HTTPStore keeps host configs in a remote key/value store that speaks a
+// consul-style HTTP API:
+//
+//	GET    <url>/<key>?raw         returns the raw value (404 if missing)
+//	GET    <url>/<key>             returns the entry as JSON, with its ModifyIndex
+//	PUT    <url>/<key>             stores the request body
+//	PUT    <url>/<key>?cas=<index> stores the body only if the ModifyIndex of
+//	                               the key is index (0: the key does not
+//	                               exist), returning true or false
+//	DELETE <url>/<key>             removes the key
+//	DELETE <url>/<key>?cas=<index> removes the key only if its ModifyIndex is
+//	                               index, returning true or false
+//	GET    <url>/<prefix>?keys     returns a JSON array of keys under prefix
+//
+// Hosts are locked with keys created with cas=0, so that workstations
+// sharing the store do not change a machine at the same time. The files the
+// drivers create in the directory of a machine under Path (SSH keys,
+// certificates) are kept in the store as well and fetched by the other
+// workstations, encrypted with the secret passphrase if one is set.
+type HTTPStore struct {
+	URL            string
+	Path           string
+	CaCertPath     string
+	PrivateKeyPath string
+	client         *http.Client
+
+	mu sync.Mutex
+	// pushed records the files stored by this process, so that they are
+	// only stored again when they change
+	pushed map[string]os.FileInfo
+}
+
+// kvEntry is an entry as returned by GET <url>/<key>
+type kvEntry struct {
+	Value       []byte
+	ModifyIndex uint64
+}
+
+func NewHTTPStore(storeURL string, rootPath string, caCert string, privateKey string) *HTTPStore {
+	if rootPath == "" {
+		rootPath = utils.GetMachineDir()
+	}
+
+	return &HTTPStore{
+		URL:            strings.TrimRight(storeURL, "/"),
+		Path:           rootPath,
+		CaCertPath:     caCert,
+		PrivateKeyPath: privateKey,
+		client:         http.DefaultClient,
+		pushed:         map[string]os.FileInfo{},
+	}
+}
+
+func (s *HTTPStore) Create(name string, driverName string, flags drivers.DriverOptions, waiter *wait.Waiter) (*Host, error) {
+	return createHost(s, filepath.Join(s.Path, name), name, driverName, s.CaCertPath, s.PrivateKeyPath, flags, waiter)
+}
+
+func (s *HTTPStore) Remove(name string, force bool) error {
+	if err := s.Lock(name); err != nil {
+		return err
+	}
+	defer s.Unlock(name)
+
+	if err := clearActive(s, name); err != nil {
+		return err
+	}
+
+	host, err := s.Load(name)
+	if err != nil {
+		return err
+	}
+
+	if err := host.removeFromProvider(); err != nil {
+		if !force {
+			return err
+		}
+	}
+
+	return s.Delete(name)
+}
+
+func (s *HTTPStore) Delete(name string) error {
+	// the machine may have been created on another workstation, so there
+	// is not necessarily anything stored locally
+	if err := os.RemoveAll(filepath.Join(s.Path, name)); err != nil {
+		return err
+	}
+
+	keys, err := s.keys(s.filesKey(name))
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if rel, ok := s.relativeFileKey(name, key); ok {
+			if err := s.delete(s.fileKey(name, rel)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.delete(s.hostKey(name))
+}
+
+func (s *HTTPStore) List() ([]Host, error) {
+	keys, err := s.keys(httpStoreMachinesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := []Host{}
+
+	for _, key := range keys {
+		name := path.Base(key)
+		host, err := s.Load(name)
+		if err != nil {
+			log.Errorf("error loading host %q: %s", name, err)
+			continue
+		}
+		hosts = append(hosts, *host)
+	}
+	return hosts, nil
+}
+
+func (s *HTTPStore) Exists(name string) (bool, error) {
+	if _, err := s.get(s.hostKey(name)); err != nil {
+		if err == errKeyNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *HTTPStore) Load(name string) (*Host, error) {
+	data, err := s.get(s.hostKey(name))
+	if err != nil {
+		if err == errKeyNotFound {
+			return nil, fmt.Errorf("Host %q does not exist", name)
+		}
+		return nil, err
+	}
+
+	// the machine may have been created on another workstation
+	if err := s.pullFiles(name); err != nil {
+		return nil, fmt.Errorf("error fetching the files of host %q: %s", name, err)
+	}
+
+	return loadHost(s, name, filepath.Join(s.Path, name), data, func(version int, data []byte) error {
+		return s.put(path.Join(httpStoreBackupsKey, name, fmt.Sprintf("config.json.v%d", version)), data)
+	})
+}
+
+func (s *HTTPStore) Save(host *Host) error {
+	data, err := host.marshalConfig()
+	if err != nil {
+		return err
+	}
+	if err := s.put(s.hostKey(host.Name), data); err != nil {
+		return err
+	}
+	return s.pushFiles(host.Name)
+}
+
+// Lock locks the host for all workstations sharing the store. A lock left
+// behind by a process of this workstation which no longer exists is taken
+// over.
+func (s *HTTPStore) Lock(name string) error {
+	key := s.lockKey(name)
+	owner := httpStoreLockOwner()
+
+	// the lock is tried again if it was released or removed meanwhile
+	for attempt := 0; attempt < 3; attempt++ {
+		ok, err := s.cas("PUT", key, 0, []byte(owner))
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		entry, err := s.entry(key)
+		if err == errKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		holder := string(entry.Value)
+		if holder == owner {
+			return fmt.Errorf("machine %s is already locked by this process", name)
+		}
+		if !httpStoreLockStale(holder) {
+			return fmt.Errorf("machine %s is locked by %s", name, describeLockOwner(holder))
+		}
+		// the index makes sure that only the stale lock is removed, not a
+		// lock another process took over meanwhile
+		if _, err := s.cas("DELETE", key, entry.ModifyIndex, nil); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("machine %s is locked by another process", name)
+}
+
+// Unlock releases the lock of the host. It fails without releasing it if
+// the lock is held by another process, e.g. after it was taken over.
+func (s *HTTPStore) Unlock(name string) error {
+	key := s.lockKey(name)
+
+	entry, err := s.entry(key)
+	if err == errKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	holder := string(entry.Value)
+	if holder != httpStoreLockOwner() {
+		return fmt.Errorf("machine %s is locked by %s", name, describeLockOwner(holder))
+	}
+	ok, err := s.cas("DELETE", key, entry.ModifyIndex, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("the lock of machine %s was taken over", name)
+	}
+	return nil
+}
+
+// httpStoreLockOwner identifies this process in the locks it takes as
+// hostname:pid
+func httpStoreLockOwner() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
+// splitLockOwner returns the hostname and pid of a lock owner
+func splitLockOwner(owner string) (string, int, bool) {
+	i := strings.LastIndex(owner, ":")
+	if i < 0 {
+		return "", 0, false
+	}
+	pid, err := strconv.Atoi(owner[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return owner[:i], pid, true
+}
+
+func describeLockOwner(owner string) string {
+	if hostname, pid, ok := splitLockOwner(owner); ok {
+		return fmt.Sprintf("pid %d on %s", pid, hostname)
+	}
+	return owner
+}
+
+// httpStoreLockStale returns whether the lock owner is a process of this
+// workstation which no longer exists. Processes of other workstations
+// cannot be checked, so their locks are never stale.
+func httpStoreLockStale(owner string) bool {
+	lockHostname, pid, ok := splitLockOwner(owner)
+	if !ok {
+		return false
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname != lockHostname {
+		return false
+	}
+	return !utils.ProcessExists(pid)
+}
+
+// pushFiles stores the files in the directory of the host which changed
+// since this process stored them
+func (s *HTTPStore) pushFiles(name string) error {
+	hostPath := filepath.Join(s.Path, name)
+	return filepath.Walk(hostPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(hostPath, file)
+		if err != nil {
+			return err
+		}
+		if httpStoreLocalFiles[rel] {
+			return nil
+		}
+		if info.Size() > httpStoreMaxFileSize {
+			log.Debugf("not storing %s of host %q in the store: it is larger than %d bytes", rel, name, httpStoreMaxFileSize)
+			return nil
+		}
+
+		key := s.fileKey(name, rel)
+		if !s.fileChanged(key, info) {
+			return nil
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if passphrase := getSecretPassphrase(); passphrase != "" {
+			if data, err = utils.Encrypt(data, passphrase); err != nil {
+				return err
+			}
+		}
+		if err := s.put(key, data); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.pushed[key] = info
+		s.mu.Unlock()
+		return nil
+	})
+}
+
+func (s *HTTPStore) fileChanged(key string, info os.FileInfo) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pushed, ok := s.pushed[key]
+	return !ok || pushed.Size() != info.Size() || !pushed.ModTime().Equal(info.ModTime())
+}
+
+// pullFiles fetches the files of the host which are missing locally, e.g.
+// because it was created on another workstation. Local files are kept.
+func (s *HTTPStore) pullFiles(name string) error {
+	keys, err := s.keys(s.filesKey(name))
+	if err != nil {
+		return err
+	}
+
+	hostPath := filepath.Join(s.Path, name)
+	for _, key := range keys {
+		rel, ok := s.relativeFileKey(name, key)
+		if !ok {
+			continue
+		}
+		file := filepath.Join(hostPath, filepath.FromSlash(rel))
+		if _, err := os.Stat(file); err == nil {
+			continue
+		}
+
+		data, err := s.get(s.fileKey(name, rel))
+		if err != nil {
+			return err
+		}
+		if utils.IsEncrypted(data) {
+			passphrase := getSecretPassphrase()
+			if passphrase == "" {
+				return fmt.Errorf("%s is encrypted; set %s to decrypt it", rel, secretPassphraseEnv)
+			}
+			if data, err = utils.Decrypt(data, passphrase); err != nil {
+				return fmt.Errorf("error decrypting %s: %s", rel, err)
+			}
+		}
+
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+		if err := utils.WriteFileAtomic(file, data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *HTTPStore) GetActive() (*Host, error) {
+	hostName, err := s.get(httpStoreActiveKey)
+	if err != nil {
+		if err == errKeyNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s.Load(string(hostName))
+}
+
+func (s *HTTPStore) IsActive(host *Host) (bool, error) {
+	return isActive(s, host)
+}
+
+func (s *HTTPStore) SetActive(host *Host) error {
+	return s.put(httpStoreActiveKey, []byte(host.Name))
+}
+
+func (s *HTTPStore) RemoveActive() error {
+	return s.delete(httpStoreActiveKey)
+}
+
+func (s *HTTPStore) hostKey(name string) string {
+	return path.Join(httpStoreMachinesKey, name)
+}
+
+func (s *HTTPStore) lockKey(name string) string {
+	return path.Join(httpStoreLocksKey, name)
+}
+
+func (s *HTTPStore) filesKey(name string) string {
+	return path.Join(httpStoreFilesKey, name)
+}
+
+func (s *HTTPStore) fileKey(name string, rel string) string {
+	return path.Join(s.filesKey(name), filepath.ToSlash(rel))
+}
+
+// relativeFileKey returns the path of a file of the host, relative to the
+// directory of the host, from its key as listed by the store. Paths which
+// leave the directory are rejected.
+func (s *HTTPStore) relativeFileKey(name string, key string) (string, bool) {
+	prefix := s.filesKey(name) + "/"
+	i := strings.Index(key, prefix)
+	if i < 0 {
+		return "", false
+	}
+	rel := path.Clean(key[i+len(prefix):])
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+		return "", false
+	}
+	return rel, true
+}
+
+func (s *HTTPStore) keyURL(key string) string {
+	return fmt.Sprintf("%s/%s", s.URL, key)
+}
+
+func (s *HTTPStore) do(method string, url string, body []byte) ([]byte, error) {
+	log.Debugf("store: %s %s", method, url)
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errKeyNotFound
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, fmt.Errorf("unexpected response from store for %s %s: %s", method, url, resp.Status)
+	}
+
+	return data, nil
+}
+
+func (s *HTTPStore) get(key string) ([]byte, error) {
+	return s.do("GET", s.keyURL(key)+"?raw", nil)
+}
+
+func (s *HTTPStore) put(key string, value []byte) error {
+	_, err := s.do("PUT", s.keyURL(key), value)
+	return err
+}
+
+// entry returns the value of the key with its modify index
+func (s *HTTPStore) entry(key string) (*kvEntry, error) {
+	data, err := s.do("GET", s.keyURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	entries := []kvEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid entry of %s in store: %s", key, err)
+	}
+	if len(entries) == 0 {
+		return nil, errKeyNotFound
+	}
+	return &entries[0], nil
+}
+
+// cas puts or deletes the key if its modify index is index, returning
+// whether it did
+func (s *HTTPStore) cas(method string, key string, index uint64, value []byte) (bool, error) {
+	data, err := s.do(method, fmt.Sprintf("%s?cas=%d", s.keyURL(key), index), value)
+	if err == errKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) == "true", nil
+}
+
+func (s *HTTPStore) delete(key string) error {
+	if _, err := s.do("DELETE", s.keyURL(key), nil); err != nil && err != errKeyNotFound {
+		return err
+	}
+	return nil
+}
+
+func (s *HTTPStore) keys(prefix string) ([]string, error) {
+	data, err := s.do("GET", s.keyURL(prefix)+"/?keys", nil)
+	if err != nil {
+		if err == errKeyNotFound {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	keys := []string{}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
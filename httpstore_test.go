@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	_ "github.com/docker/machine/drivers/none"
+)
+
+// kvServer is a minimal in-memory stand-in for a consul-style key/value store
+type kvServer struct {
+	sync.Mutex
+	data    map[string][]byte
+	indexes map[string]uint64
+	index   uint64
+}
+
+func (kv *kvServer) set(key string, value []byte) {
+	kv.index++
+	kv.data[key] = value
+	kv.indexes[key] = kv.index
+}
+
+func (kv *kvServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kv.Lock()
+	defer kv.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+
+	// cas requests only succeed if the key is at the given index, 0 meaning
+	// that it does not exist
+	if cas, ok := query["cas"]; ok {
+		index, err := strconv.ParseUint(cas[0], 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if kv.indexes[key] != index {
+			w.Write([]byte("false"))
+			return
+		}
+	}
+
+	switch r.Method {
+	case "GET":
+		if _, ok := query["keys"]; ok {
+			keys := []string{}
+			for k := range kv.data {
+				if strings.HasPrefix(k, key) {
+					keys = append(keys, k)
+				}
+			}
+			if len(keys) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			sort.Strings(keys)
+			json.NewEncoder(w).Encode(keys)
+			return
+		}
+		value, ok := kv.data[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if _, ok := query["raw"]; ok {
+			w.Write(value)
+			return
+		}
+		json.NewEncoder(w).Encode([]kvEntry{{Value: value, ModifyIndex: kv.indexes[key]}})
+	case "PUT":
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		kv.set(key, value)
+		w.Write([]byte("true"))
+	case "DELETE":
+		delete(kv.data, key)
+		delete(kv.indexes, key)
+		w.Write([]byte("true"))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func getTestHTTPStore(t *testing.T) (*HTTPStore, *kvServer, func()) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv := &kvServer{data: make(map[string][]byte), indexes: make(map[string]uint64)}
+	server := httptest.NewServer(kv)
+
+	store := NewHTTPStore(server.URL+"/v1/kv/machine", tmpDir, "", "")
+
+	return store, kv, func() {
+		server.Close()
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func TestHTTPStoreCreateLoad(t *testing.T) {
+	store, kv, cleanup := getTestHTTPStore(t)
+	defer cleanup()
+
+	expectedURL := "unix:///foo/baz"
+	flags := getDefaultTestDriverFlags()
+	flags.Data["url"] = expectedURL
+
+	if _, err := store.Create("test", "none", flags, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := kv.data["v1/kv/machine/machines/test"]; !ok {
+		t.Fatal("expected host config to be stored in the key/value store")
+	}
+
+	exists, err := store.Exists("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Fatal("Exists returned false when it should have been true")
+	}
+
+	host, err := store.Load("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.Name != "test" {
+		t.Fatalf("Host name is incorrect, got: %s", host.Name)
+	}
+	actualURL, err := host.GetURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actualURL != expectedURL {
+		t.Fatalf("GetURL is not %q, got %q", expectedURL, actualURL)
+	}
+
+	// saving through the host goes back to the key/value store
+	if err := host.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if err := host.LoadConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPStoreListRemove(t *testing.T) {
+	store, _, cleanup := getTestHTTPStore(t)
+	defer cleanup()
+
+	flags := getDefaultTestDriverFlags()
+
+	for _, name := range []string{"test-a", "test-b"} {
+		if _, err := store.Create(name, "none", flags, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hosts, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("List returned %d items", len(hosts))
+	}
+
+	if err := store.Remove("test-a", false); err != nil {
+		t.Fatal(err)
+	}
+
+	exists, err := store.Exists("test-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Fatal("Exists returned true after remove")
+	}
+
+	hosts, err = store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0].Name != "test-b" {
+		t.Fatalf("expected only test-b to remain, got %v", hosts)
+	}
+}
+
+func TestHTTPStoreGetSetActive(t *testing.T) {
+	store, _, cleanup := getTestHTTPStore(t)
+	defer cleanup()
+
+	host, err := store.GetActive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != nil {
+		t.Fatal("GetActive: Active host should not exist")
+	}
+
+	originalHost, err := store.Create("test", "none", getDefaultTestDriverFlags(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.SetActive(originalHost); err != nil {
+		t.Fatal(err)
+	}
+
+	isActive, err := store.IsActive(originalHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isActive {
+		t.Fatal("IsActive: Active host is not test")
+	}
+
+	// removing the active host clears the active marker
+	if err := store.Remove("test", false); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err = store.GetActive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != nil {
+		t.Fatalf("Active host %s is not nil", host.Name)
+	}
+}
+
+func TestHTTPStoreLock(t *testing.T) {
+	store, kv, cleanup := getTestHTTPStore(t)
+	defer cleanup()
+
+	if err := store.Lock("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Lock("test"); err == nil || !strings.Contains(err.Error(), "already locked by this process") {
+		t.Fatalf("expected the lock not to be reentrant, got %v", err)
+	}
+	if err := store.Unlock("test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kv.data["v1/kv/machine/locks/test"]; ok {
+		t.Fatal("expected the lock to be removed from the key/value store")
+	}
+
+	// a process of another workstation holds the lock
+	kv.Lock()
+	kv.set("v1/kv/machine/locks/test", []byte("other-workstation:1"))
+	kv.Unlock()
+
+	if err := store.Lock("test"); err == nil || !strings.Contains(err.Error(), "locked by pid 1 on other-workstation") {
+		t.Fatalf("expected the lock of another workstation to be kept, got %v", err)
+	}
+	if err := store.Unlock("test"); err == nil {
+		t.Fatal("expected the lock of another workstation not to be released")
+	}
+}
+
+func TestHTTPStoreLockStale(t *testing.T) {
+	store, kv, cleanup := getTestHTTPStore(t)
+	defer cleanup()
+
+	// a pid which cannot belong to a running process of this workstation
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv.Lock()
+	kv.set("v1/kv/machine/locks/test", []byte(fmt.Sprintf("%s:%d", hostname, 1<<30)))
+	kv.Unlock()
+
+	if err := store.Lock("test"); err != nil {
+		t.Fatalf("expected the stale lock to be taken over, got %v", err)
+	}
+	if owner := string(kv.data["v1/kv/machine/locks/test"]); owner != httpStoreLockOwner() {
+		t.Fatalf("expected the lock to be owned by %s, got %s", httpStoreLockOwner(), owner)
+	}
+}
+
+func TestHTTPStoreFiles(t *testing.T) {
+	store, kv, cleanup := getTestHTTPStore(t)
+	defer cleanup()
+
+	host, err := store.Create("test", "none", getDefaultTestDriverFlags(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("private key")
+	if err := ioutil.WriteFile(filepath.Join(store.Path, "test", "id_rsa"), key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// state of the machine on this workstation only
+	if err := ioutil.WriteFile(filepath.Join(store.Path, "test", "state.json"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(host); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kv.data["v1/kv/machine/files/test/state.json"]; ok {
+		t.Fatal("expected state.json not to be stored in the key/value store")
+	}
+
+	// another workstation sharing the store
+	otherPath, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(otherPath)
+	other := NewHTTPStore(store.URL, otherPath, "", "")
+
+	if _, err := other.Load("test"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(otherPath, "test", "id_rsa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(key) {
+		t.Fatalf("expected id_rsa to be fetched from the store, got %q", data)
+	}
+
+	if err := store.Remove("test", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kv.data["v1/kv/machine/files/test/id_rsa"]; ok {
+		t.Fatal("expected the files of the host to be removed from the key/value store")
+	}
+}
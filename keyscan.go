@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/docker/machine/ssh"
+)
+
+// scanHostKey gets the host key of the machine and checks it against the
+// pinned key, pinning it if there is none. With reset the pinned key is
+// replaced, e.g. after the machine was re-created at the provider.
+func (h *Host) scanHostKey(reset bool) (string, error) {
+	addr, err := h.sshAddress()
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ssh.ScanHostKey(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if addr.KnownHostsFile != "" {
+		if reset {
+			if err := os.Remove(addr.KnownHostsFile); err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+		}
+		if err := addr.CheckHostKey(key); err != nil {
+			return "", err
+		}
+	}
+	return addr.KnownHostsLine(key), nil
+}
+
+func cmdSshKeyscan(c *cli.Context) {
+	host := getHost(c)
+	reset := c.Bool("reset")
+
+	line, err := host.scanHostKey(reset)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(line)
+	if reset {
+		log.Infof("Pinned the host key of %s", host.Name)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/machine/drivers"
+)
+
+// parseLabel splits a label given as key=value
+func parseLabel(label string) (string, string, error) {
+	parts := strings.SplitN(label, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("invalid label %q; labels must be given as key=value", label)
+	}
+	return parts[0], parts[1], nil
+}
+
+// parseLabels parses labels given as key=value
+func parseLabels(labels []string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, label := range labels {
+		key, value, err := parseLabel(label)
+		if err != nil {
+			return nil, err
+		}
+		parsed[key] = value
+	}
+	return parsed, nil
+}
+
+// SetLabels replaces the labels of the host and applies them on the provider
+// if the driver supports it
+func (h *Host) SetLabels(labels map[string]string) error {
+	if labeler, ok := h.Driver.(drivers.Labeler); ok {
+		if err := labeler.SetLabels(labels); err != nil {
+			return fmt.Errorf("error setting labels on the provider: %s", err)
+		}
+	}
+
+	if len(labels) == 0 {
+		labels = nil
+	}
+	h.Labels = labels
+	return nil
+}
+
+// updateLabels adds and removes labels of the named host while holding its
+// lock
+func updateLabels(store Store, name string, add map[string]string, remove []string) error {
+	if err := store.Lock(name); err != nil {
+		return err
+	}
+	defer store.Unlock(name)
+
+	host, err := store.Load(name)
+	if err != nil {
+		return err
+	}
+
+	labels := map[string]string{}
+	for k, v := range host.Labels {
+		labels[k] = v
+	}
+	for k, v := range add {
+		labels[k] = v
+	}
+	for _, k := range remove {
+		delete(labels, k)
+	}
+
+	if err := host.SetLabels(labels); err != nil {
+		return err
+	}
+
+	return store.Save(host)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := parseLabels([]string{"env=staging", "role=", "url=a=b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 3 || labels["env"] != "staging" || labels["role"] != "" || labels["url"] != "a=b" {
+		t.Fatalf("unexpected labels: %v", labels)
+	}
+
+	for _, label := range []string{"env", "=staging"} {
+		if _, err := parseLabels([]string{label}); err == nil {
+			t.Fatalf("expected error for label %q", label)
+		}
+	}
+}
+
+func TestCreateAndUpdateLabels(t *testing.T) {
+	store, err := getTestStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store.Path)
+
+	flags := getTestDriverFlags()
+	flags.Data["label"] = []string{"env=staging", "role=web"}
+
+	if _, err := store.Create(hostTestName, hostTestDriverName, flags, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := store.Load(hostTestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(host.Labels) != 2 || host.Labels["env"] != "staging" {
+		t.Fatalf("unexpected labels: %v", host.Labels)
+	}
+
+	if err := updateLabels(store, hostTestName, map[string]string{"env": "production"}, []string{"role"}); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err = store.Load(hostTestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(host.Labels) != 1 || host.Labels["env"] != "production" {
+		t.Fatalf("unexpected labels: %v", host.Labels)
+	}
+}
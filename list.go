@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/drivers"
+)
+
+// The optional columns of ls
+const (
+	ipColumn      = "ip"
+	createdColumn = "created"
+	dockerColumn  = "docker"
+	sizeColumn    = "size"
+)
+
+var listColumns = []string{ipColumn, createdColumn, dockerColumn, sizeColumn}
+
+// createdLayout is how ls shows the creation time of a machine
+const createdLayout = "2006-01-02 15:04:05"
+
+// parseColumns parses a comma separated list of optional ls columns
+func parseColumns(value string) ([]string, error) {
+	columns := []string{}
+	if value == "" {
+		return columns, nil
+	}
+
+	for _, column := range strings.Split(value, ",") {
+		column = strings.ToLower(strings.TrimSpace(column))
+		valid := false
+		for _, c := range listColumns {
+			if column == c {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown column %q; valid columns are %s", column, strings.Join(listColumns, ", "))
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+func hasColumn(columns []string, column string) bool {
+	for _, c := range columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// columnHeader returns the header of an optional column
+func columnHeader(column string) string {
+	if column == dockerColumn {
+		return "DOCKER"
+	}
+	return strings.ToUpper(column)
+}
+
+// columnValue returns the value of an optional column of an item
+func (item hostListItem) columnValue(column string) string {
+	switch column {
+	case ipColumn:
+		return item.IP
+	case createdColumn:
+		if item.CreatedAt.IsZero() {
+			return ""
+		}
+		return item.CreatedAt.Local().Format(createdLayout)
+	case dockerColumn:
+		return item.DockerVersion
+	case sizeColumn:
+		return item.Size
+	}
+	return ""
+}
+
+// hostSize returns the driver specific size of the host, if the driver
+// can describe it
+func hostSize(h *Host) string {
+	if sizer, ok := h.Driver.(drivers.Sizer); ok {
+		return sizer.MachineSize()
+	}
+	return ""
+}
+
+// getDockerVersion returns the version of Docker installed on the host
+func (h *Host) getDockerVersion() (string, error) {
+	client, err := h.sshClient()
+	if err != nil {
+		return "", err
+	}
+	out, err := client.Output("docker -v")
+	if err != nil {
+		return "", fmt.Errorf("error getting Docker version: %s", err)
+	}
+
+	// Docker version 1.5.0, build a8a31ef
+	fields := strings.Fields(out)
+	if len(fields) < 3 {
+		return "", fmt.Errorf("unexpected Docker version %q", strings.TrimSpace(out))
+	}
+	return strings.TrimSuffix(fields[2], ","), nil
+}
+
+// getRemoteColumns fills in the optional columns of the item which are
+// queried from the running host, within timeout. A timeout of 0 means no
+// deadline.
+func (item *hostListItem) getRemoteColumns(h *Host, columns []string, timeout time.Duration) error {
+	type result struct {
+		ip, version string
+		err         error
+	}
+
+	// buffered, so the query does not leak when it answers too late
+	results := make(chan result, 1)
+	go func() {
+		var r result
+		if hasColumn(columns, ipColumn) {
+			if r.ip, r.err = h.Driver.GetIP(); r.err != nil {
+				results <- r
+				return
+			}
+		}
+		if hasColumn(columns, dockerColumn) {
+			r.version, r.err = h.getDockerVersion()
+		}
+		results <- r
+	}()
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+
+	select {
+	case r := <-results:
+		item.IP, item.DockerVersion = r.ip, r.version
+		return r.err
+	case <-deadline:
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// hostListItemSorter sorts ls items by a key, and by name if the keys are
+// equal
+type hostListItemSorter struct {
+	items []hostListItem
+	less  func(a, b *hostListItem) bool
+}
+
+func (s hostListItemSorter) Len() int {
+	return len(s.items)
+}
+
+func (s hostListItemSorter) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+}
+
+func (s hostListItemSorter) Less(i, j int) bool {
+	a, b := &s.items[i], &s.items[j]
+	if s.less(a, b) {
+		return true
+	}
+	if s.less(b, a) {
+		return false
+	}
+	return a.Name < b.Name
+}
+
+// listSortKeys are the keys ls can sort by
+var listSortKeys = map[string]func(a, b *hostListItem) bool{
+	"name": func(a, b *hostListItem) bool {
+		return a.Name < b.Name
+	},
+	"driver": func(a, b *hostListItem) bool {
+		return a.DriverName < b.DriverName
+	},
+	"state": func(a, b *hostListItem) bool {
+		return a.State.String() < b.State.String()
+	},
+	"created": func(a, b *hostListItem) bool {
+		return a.CreatedAt.Before(b.CreatedAt)
+	},
+}
+
+// sortHostListItems sorts the items by the key
+func sortHostListItems(items []hostListItem, key string) error {
+	less, ok := listSortKeys[key]
+	if !ok {
+		return fmt.Errorf("unknown sort key %q; valid keys are name, driver, state and created", key)
+	}
+	sort.Sort(hostListItemSorter{items, less})
+	return nil
+}
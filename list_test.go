@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/machine/state"
+)
+
+func TestParseColumns(t *testing.T) {
+	columns, err := parseColumns("IP, size")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(columns) != 2 || columns[0] != ipColumn || columns[1] != sizeColumn {
+		t.Fatalf("unexpected columns %v", columns)
+	}
+
+	if columns, err := parseColumns(""); err != nil || len(columns) != 0 {
+		t.Fatalf("expected no columns; received %v, %v", columns, err)
+	}
+
+	if _, err := parseColumns("ip,color"); err == nil {
+		t.Fatal("expected an error for an unknown column")
+	}
+}
+
+func TestSortHostListItems(t *testing.T) {
+	now := time.Now()
+	items := []hostListItem{
+		{Name: "c", DriverName: "none", State: state.Running, CreatedAt: now.Add(-time.Hour)},
+		{Name: "a", DriverName: "virtualbox", State: state.Stopped, CreatedAt: now},
+		{Name: "b", DriverName: "none", State: state.Running, CreatedAt: now.Add(-2 * time.Hour)},
+	}
+
+	tests := []struct {
+		key   string
+		order string
+	}{
+		{"name", "abc"},
+		{"driver", "bca"},
+		{"state", "bca"},
+		{"created", "bca"},
+	}
+
+	for _, test := range tests {
+		if err := sortHostListItems(items, test.key); err != nil {
+			t.Fatal(err)
+		}
+		order := ""
+		for _, item := range items {
+			order += item.Name
+		}
+		if order != test.order {
+			t.Fatalf("expected order %s when sorting by %s; received %s", test.order, test.key, order)
+		}
+	}
+
+	if err := sortHostListItems(items, "color"); err == nil {
+		t.Fatal("expected an error for an unknown sort key")
+	}
+}
+
+func TestCreatedColumn(t *testing.T) {
+	item := hostListItem{}
+	if value := item.columnValue(createdColumn); value != "" {
+		t.Fatalf("expected no created time for a legacy machine; received %q", value)
+	}
+
+	item.CreatedAt = time.Date(2015, 3, 1, 12, 0, 0, 0, time.Local)
+	if value := item.columnValue(createdColumn); value != "2015-03-01 12:00:00" {
+		t.Fatalf("unexpected created time %q", value)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
+	"github.com/docker/machine/ssh"
 	"github.com/docker/machine/utils"
 )
 
@@ -38,6 +39,12 @@ func main() {
 			Value:  utils.GetMachineRoot(),
 			Usage:  "Configures storage path",
 		},
+		cli.StringFlag{
+			EnvVar: "MACHINE_STORAGE_URL",
+			Name:   "storage-url",
+			Usage:  "URL of a consul-style key/value store to keep machine configs in instead of the storage path",
+			Value:  "",
+		},
 		cli.StringFlag{
 			EnvVar: "MACHINE_TLS_CA_CERT",
 			Name:   "tls-ca-cert",
@@ -62,6 +69,39 @@ func main() {
 			Usage:  "Private key used in client TLS auth",
 			Value:  filepath.Join(utils.GetMachineCertDir(), "key.pem"),
 		},
+		cli.StringFlag{
+			EnvVar: "MACHINE_SSH_CLIENT",
+			Name:   "ssh-client",
+			Usage:  "SSH client to run commands on machines with: native, or external to use the ssh binary",
+			Value:  string(ssh.Native),
+		},
+		cli.StringFlag{
+			EnvVar: "MACHINE_CONFIG",
+			Name:   "config",
+			Usage:  "Config file with defaults for the options, " + defaultConfigPath() + " if not given",
+			Value:  "",
+		},
+		cli.StringFlag{
+			EnvVar: "MACHINE_PROFILE",
+			Name:   "profile",
+			Usage:  "Profile of the config file to take defaults from",
+			Value:  "",
+		},
+	}
+
+	// the defaults must be set before the flags are parsed
+	defaults, err := loadConfigDefaults(
+		preScanFlag(os.Args[1:], "config", "MACHINE_CONFIG"),
+		preScanFlag(os.Args[1:], "profile", "MACHINE_PROFILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := applyConfigDefaults(app, defaults); err != nil {
+		log.Fatal(err)
+	}
+
+	app.Before = func(c *cli.Context) error {
+		return ssh.SetDefaultClientType(c.GlobalString("ssh-client"))
 	}
 
 	app.Run(os.Args)
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/machine/drivers"
+)
+
+// hostMigration upgrades a decoded host config by one version
+type hostMigration func(config map[string]interface{}) error
+
+// hostMigrations upgrade persisted host configs, where hostMigrations[n]
+// upgrades a config from version n to n+1. The current version of the host
+// config is the number of migrations.
+var hostMigrations = []hostMigration{
+	migrateHostV0,
+	migrateHostV1,
+}
+
+// hostConfigVersion is the version of host configs written by this machine
+var hostConfigVersion = len(hostMigrations)
+
+// migrateHostV0 upgrades configs written before they were versioned. The
+// layout is unchanged, the configs only get a ConfigVersion.
+func migrateHostV0(config map[string]interface{}) error {
+	return nil
+}
+
+// migrateHostV1 records the create phases of hosts created before the phases
+// were recorded. Their create completed, unless it left create steps behind.
+func migrateHostV1(config map[string]interface{}) error {
+	if steps, ok := config["CreateSteps"].([]interface{}); ok && len(steps) > 0 {
+		return nil
+	}
+
+	phases := []interface{}{}
+	for _, phase := range createPhases {
+		phases = append(phases, phase.Name)
+	}
+	config["CompletedPhases"] = phases
+
+	discovery, _ := config["SwarmDiscovery"].(string)
+	config["Swarm"] = discovery != ""
+
+	return nil
+}
+
+// migrateHostConfig upgrades a persisted host config, including the config of
+// its driver, to the current versions. It returns the version the config was
+// persisted with and whether it was migrated.
+func migrateHostConfig(data []byte) ([]byte, int, bool, error) {
+	config, err := decodeConfigMap(data)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	version, err := drivers.GetConfigInt(config, "ConfigVersion")
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if version > hostConfigVersion {
+		return nil, version, false, fmt.Errorf("config version %d is newer than the supported version %d; please upgrade machine",
+			version, hostConfigVersion)
+	}
+
+	driverVersion, err := drivers.GetConfigInt(config, "DriverConfigVersion")
+	if err != nil {
+		return nil, version, false, err
+	}
+
+	driverName, _ := config["DriverName"].(string)
+	if version == hostConfigVersion && driverVersion == drivers.ConfigVersion(driverName) {
+		return data, version, false, nil
+	}
+
+	for v := version; v < hostConfigVersion; v++ {
+		log.Debugf("migrating host config from version %d", v)
+		if err := hostMigrations[v](config); err != nil {
+			return nil, version, false, fmt.Errorf("error migrating host config from version %d: %s", v, err)
+		}
+	}
+
+	driverConfig, ok := config["Driver"].(map[string]interface{})
+	if !ok {
+		driverConfig = map[string]interface{}{}
+	}
+	newDriverVersion, err := drivers.MigrateConfig(driverName, driverConfig, driverVersion)
+	if err != nil {
+		return nil, version, false, err
+	}
+
+	config["ConfigVersion"] = hostConfigVersion
+	config["DriverConfigVersion"] = newDriverVersion
+	config["Driver"] = driverConfig
+
+	migrated, err := json.Marshal(config)
+	if err != nil {
+		return nil, version, false, err
+	}
+
+	return migrated, version, true, nil
+}
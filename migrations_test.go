@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/machine/drivers"
+	"github.com/docker/machine/drivers/amazonec2"
+	_ "github.com/docker/machine/drivers/digitalocean"
+	"github.com/docker/machine/drivers/virtualbox"
+)
+
+// installFixture copies a config fixture into the store as the given host
+func installFixture(t *testing.T, store *Filestore, name string, fixture string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "configs", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostPath := filepath.Join(store.Path, name)
+	if err := os.MkdirAll(hostPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(hostPath, "config.json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestMigrateUnversionedConfig(t *testing.T) {
+	store, err := getTestStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store.Path)
+
+	original := installFixture(t, store, "dev", "v0-virtualbox.json")
+
+	host, err := store.Load("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if host.ConfigVersion != hostConfigVersion {
+		t.Fatalf("expected config version %d; received %d", hostConfigVersion, host.ConfigVersion)
+	}
+	if host.DriverConfigVersion != drivers.ConfigVersion("virtualbox") {
+		t.Fatalf("expected driver config version %d; received %d", drivers.ConfigVersion("virtualbox"), host.DriverConfigVersion)
+	}
+
+	d, ok := host.Driver.(*virtualbox.Driver)
+	if !ok {
+		t.Fatalf("expected virtualbox driver; received %T", host.Driver)
+	}
+	if d.SSHPort != 55834 || d.Memory != 1024 || d.DiskSize != 20000 {
+		t.Fatalf("driver config was not loaded correctly: %+v", d)
+	}
+
+	backup, err := ioutil.ReadFile(filepath.Join(store.Path, "dev", "config.json.v0.bak"))
+	if err != nil {
+		t.Fatalf("expected a backup of the original config: %s", err)
+	}
+	if string(backup) != string(original) {
+		t.Fatal("backup does not match the original config")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(store.Path, "dev", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := struct{ ConfigVersion int }{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.ConfigVersion != hostConfigVersion {
+		t.Fatalf("expected rewritten config with version %d; received %d", hostConfigVersion, config.ConfigVersion)
+	}
+}
+
+func TestMigrateLockedHost(t *testing.T) {
+	store, err := getTestStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store.Path)
+
+	original := installFixture(t, store, "dev", "v0-virtualbox.json")
+
+	if err := store.Lock("dev"); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Unlock("dev")
+
+	host, err := store.Load("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.ConfigVersion != hostConfigVersion {
+		t.Fatalf("expected config version %d; received %d", hostConfigVersion, host.ConfigVersion)
+	}
+
+	// the config of a locked host is backed up but not rewritten
+	if _, err := os.Stat(filepath.Join(store.Path, "dev", "config.json.v0.bak")); err != nil {
+		t.Fatalf("expected a backup of the original config: %s", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(store.Path, "dev", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(original) {
+		t.Fatal("expected the config of the locked host not to be rewritten")
+	}
+}
+
+func TestMigrateKeepsDriverConfig(t *testing.T) {
+	store, err := getTestStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store.Path)
+
+	installFixture(t, store, "staging", "v0-amazonec2.json")
+
+	host, err := store.Load("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, ok := host.Driver.(*amazonec2.Driver)
+	if !ok {
+		t.Fatalf("expected amazonec2 driver; received %T", host.Driver)
+	}
+	if d.InstanceId != "i-0123abcd" || d.RootSize != 16 || d.Region != "us-east-1" {
+		t.Fatalf("driver config was not loaded correctly: %+v", d)
+	}
+	if !host.SwarmMaster || host.SwarmDiscovery != "token://1234" || !host.Swarm {
+		t.Fatal("swarm config was not loaded correctly")
+	}
+	if len(host.CompletedPhases) != len(createPhases) {
+		t.Fatalf("expected all create phases to be completed; received %v", host.CompletedPhases)
+	}
+
+	// loading again does not migrate (or back up) again
+	if err := os.Remove(filepath.Join(store.Path, "staging", "config.json.v0.bak")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load("staging"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Path, "staging", "config.json.v0.bak")); !os.IsNotExist(err) {
+		t.Fatal("expected current config not to be migrated")
+	}
+}
+
+// TestMigrateDriverConfigRoundTrip checks that the driver configs persisted
+// before the configs were versioned are rewritten at the current driver
+// version without losing any of their fields
+func TestMigrateDriverConfigRoundTrip(t *testing.T) {
+	store, err := getTestStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store.Path)
+
+	for _, fixture := range []string{"v0-virtualbox.json", "v0-amazonec2.json", "v0-digitalocean.json"} {
+		original := struct {
+			DriverName string
+			Driver     map[string]interface{}
+		}{}
+		if err := json.Unmarshal(installFixture(t, store, fixture, fixture), &original); err != nil {
+			t.Fatal(err)
+		}
+
+		if drivers.ConfigVersion(original.DriverName) == 0 {
+			t.Fatalf("%s: expected the %s driver to register migrations", fixture, original.DriverName)
+		}
+
+		if _, err := store.Load(fixture); err != nil {
+			t.Fatalf("%s: %s", fixture, err)
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(store.Path, fixture, "config.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		migrated := struct {
+			DriverConfigVersion int
+			Driver              map[string]interface{}
+		}{}
+		if err := json.Unmarshal(data, &migrated); err != nil {
+			t.Fatal(err)
+		}
+
+		if migrated.DriverConfigVersion != drivers.ConfigVersion(original.DriverName) {
+			t.Fatalf("%s: expected driver config version %d; received %d", fixture,
+				drivers.ConfigVersion(original.DriverName), migrated.DriverConfigVersion)
+		}
+		for key, value := range original.Driver {
+			if !reflect.DeepEqual(migrated.Driver[key], value) {
+				t.Fatalf("%s: expected %s to be %v after the migration; received %v", fixture, key, value, migrated.Driver[key])
+			}
+		}
+	}
+}
+
+func TestMigrateNewerConfig(t *testing.T) {
+	data := []byte(`{"ConfigVersion": 1000, "DriverName": "none", "Driver": {}}`)
+
+	if _, _, _, err := migrateHostConfig(data); err == nil {
+		t.Fatal("expected an error for a config newer than supported")
+	}
+}
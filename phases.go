@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/machine/drivers"
+	"github.com/docker/machine/wait"
+)
+
+// createPhase is a named part of creating a host. All phases but the first
+// can be run again on an existing host with the provision command.
+type createPhase struct {
+	Name string
+	Run  func(h *Host) error
+}
+
+// createPhases are the phases of a create in the order they run. The
+// completed phases are recorded in the config of the host, so that an
+// interrupted create can be resumed.
+var createPhases = []createPhase{
+	{"create", (*Host).createMachine},
+	{"provision", (*Host).Provision},
+	{"auth", (*Host).ConfigureAuth},
+	{"swarm", (*Host).configureSwarmPhase},
+}
+
+// requiredPhase is the last phase needed for a working host. A create that
+// fails before it has completed is rolled back.
+const requiredPhase = "auth"
+
+// createMachine creates the machine at the provider, recording the created
+// resources so they can be removed if the create fails
+func (h *Host) createMachine() error {
+	if rollbacker, ok := h.Driver.(drivers.Rollbacker); ok {
+		rollbacker.SetStepRecorder(h.recordCreateStep)
+	} else if err := h.recordCreateStep(drivers.CreateStep{Resource: machineStep}); err != nil {
+		return err
+	}
+
+	return h.Driver.Create()
+}
+
+func (h *Host) configureSwarmPhase() error {
+	if !h.Swarm {
+		return nil
+	}
+
+	log.Info("Configuring Swarm...")
+	return h.ConfigureSwarm(h.SwarmDiscovery, h.SwarmMaster, h.SwarmHost, h.SwarmAddr)
+}
+
+func (h *Host) phaseCompleted(name string) bool {
+	for _, phase := range h.CompletedPhases {
+		if phase == name {
+			return true
+		}
+	}
+	return false
+}
+
+// runPhases runs the create phases which have not completed yet, recording
+// each phase in the config once it has completed
+func (h *Host) runPhases() error {
+	for _, phase := range createPhases {
+		if h.phaseCompleted(phase.Name) {
+			continue
+		}
+
+		log.Debugf("running phase %s of host %s", phase.Name, h.Name)
+		if err := phase.Run(h); err != nil {
+			return err
+		}
+
+		h.CompletedPhases = append(h.CompletedPhases, phase.Name)
+		if phase.Name == requiredPhase {
+			// the host works, so a failure no longer rolls it back
+			h.CreateSteps = nil
+		}
+		if err := h.SaveConfig(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeCreate runs the phases of an existing host which have not completed,
+// or all phases from the named phase on if from is given, waiting with waiter
+func (h *Host) resumeCreate(from string, waiter *wait.Waiter) error {
+	h.setWaiter(waiter)
+
+	if !h.phaseCompleted(createPhases[0].Name) {
+		return fmt.Errorf("machine %s was not created at the provider; remove it and create it again", h.Name)
+	}
+
+	if from != "" {
+		index := -1
+		for i, phase := range createPhases[1:] {
+			if phase.Name == from {
+				index = i + 1
+			}
+		}
+		if index < 0 {
+			return fmt.Errorf("unknown phase %q; valid phases are %s", from, strings.Join(resumablePhases(), ", "))
+		}
+
+		completed := []string{}
+		for _, phase := range createPhases[:index] {
+			if h.phaseCompleted(phase.Name) {
+				completed = append(completed, phase.Name)
+			}
+		}
+		h.CompletedPhases = completed
+	}
+
+	return h.runPhases()
+}
+
+// resumablePhases returns the names of the phases the provision command can
+// start from
+func resumablePhases() []string {
+	names := []string{}
+	for _, phase := range createPhases[1:] {
+		names = append(names, phase.Name)
+	}
+	return names
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCreateRecordsPhases(t *testing.T) {
+	store, err := getTestStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store.Path)
+
+	if _, err := store.Create(hostTestName, hostTestDriverName, getTestDriverFlags(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := store.Load(hostTestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := resumablePhases(); !reflect.DeepEqual(host.CompletedPhases[1:], expected) || host.CompletedPhases[0] != "create" {
+		t.Fatalf("expected all phases to be completed; received %v", host.CompletedPhases)
+	}
+	if len(host.CreateSteps) != 0 {
+		t.Fatalf("expected no create steps; received %v", host.CreateSteps)
+	}
+}
+
+func TestResumeCreate(t *testing.T) {
+	store, err := getTestStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store.Path)
+
+	if _, err := store.Create(hostTestName, hostTestDriverName, getTestDriverFlags(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := store.Load(hostTestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an interrupted create
+	host.CompletedPhases = []string{"create", "provision"}
+	if err := host.resumeCreate("", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(host.CompletedPhases) != len(createPhases) {
+		t.Fatalf("expected all phases to be completed; received %v", host.CompletedPhases)
+	}
+
+	if err := host.resumeCreate("auth", nil); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"create", "provision", "auth", "swarm"}; !reflect.DeepEqual(host.CompletedPhases, expected) {
+		t.Fatalf("expected phases %v; received %v", expected, host.CompletedPhases)
+	}
+
+	loaded, err := store.Load(hostTestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.CompletedPhases, host.CompletedPhases) {
+		t.Fatalf("expected the phases to be saved; received %v", loaded.CompletedPhases)
+	}
+
+	for _, from := range []string{"create", "unknown"} {
+		if err := host.resumeCreate(from, nil); err == nil {
+			t.Fatalf("expected resuming from %q to fail", from)
+		}
+	}
+
+	host.CompletedPhases = nil
+	if err := host.resumeCreate("", nil); err == nil {
+		t.Fatal("expected resuming a host which was not created to fail")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/docker/machine/ssh"
+)
+
+// scpPath is a path given to scp, on a machine or local if Machine is empty
+type scpPath struct {
+	Machine string
+	Path    string
+}
+
+// parseSCPPath parses a path given as [machine:]path. Local paths containing
+// a colon can be given as ./path.
+func parseSCPPath(arg string) scpPath {
+	i := strings.Index(arg, ":")
+	if i <= 0 || strings.ContainsAny(arg[:i], `/\`) {
+		return scpPath{Path: arg}
+	}
+	if runtime.GOOS == "windows" && i == 1 {
+		// a drive letter
+		return scpPath{Path: arg}
+	}
+	return scpPath{Machine: arg[:i], Path: arg[i+1:]}
+}
+
+// scpArg returns the argument of scp for the path
+func scpArg(p scpPath, addresses map[string]*ssh.Address) string {
+	if p.Machine == "" {
+		return p.Path
+	}
+	return addresses[p.Machine].Remote(p.Path)
+}
+
+// copyBetweenMachines copies src on a machine to dst on a machine through a
+// local directory, as the machines cannot reach each other with our keys
+func copyBetweenMachines(src scpPath, dst scpPath, addresses map[string]*ssh.Address, recursive bool) error {
+	dir, err := ioutil.TempDir("", "machine-scp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ssh.GetSCPCommand(addresses[src.Machine], recursive, scpArg(src, addresses), dir).Run(); err != nil {
+		return fmt.Errorf("error copying from %s: %s", src.Machine, err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		paths = append(paths, filepath.Join(dir, entry.Name()))
+	}
+	paths = append(paths, scpArg(dst, addresses))
+
+	if err := ssh.GetSCPCommand(addresses[dst.Machine], recursive, paths...).Run(); err != nil {
+		return fmt.Errorf("error copying to %s: %s", dst.Machine, err)
+	}
+	return nil
+}
+
+func cmdScp(c *cli.Context) {
+	if len(c.Args()) != 2 {
+		cli.ShowCommandHelp(c, "scp")
+		log.Fatal("You must specify a source and a destination")
+	}
+
+	src := parseSCPPath(c.Args()[0])
+	dst := parseSCPPath(c.Args()[1])
+	if src.Machine == "" && dst.Machine == "" {
+		log.Fatal("One of the paths must be on a machine, given as machine:path")
+	}
+
+	store := getStore(c)
+	addresses := map[string]*ssh.Address{}
+	for _, name := range []string{src.Machine, dst.Machine} {
+		if name == "" || addresses[name] != nil {
+			continue
+		}
+		host, err := store.Load(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		addr, err := host.sshAddress()
+		if err != nil {
+			log.Fatalf("error getting SSH address of %s: %s", name, err)
+		}
+		addresses[name] = addr
+	}
+
+	recursive := c.Bool("recursive")
+
+	if src.Machine != "" && dst.Machine != "" {
+		if err := copyBetweenMachines(src, dst, addresses, recursive); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	machine := src.Machine
+	if machine == "" {
+		machine = dst.Machine
+	}
+
+	cmd := ssh.GetSCPCommand(addresses[machine], recursive, scpArg(src, addresses), scpArg(dst, addresses))
+	if err := cmd.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
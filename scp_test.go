@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseSCPPath(t *testing.T) {
+	cases := []struct {
+		arg      string
+		expected scpPath
+	}{
+		{"dev:/etc/hosts", scpPath{"dev", "/etc/hosts"}},
+		{"dev:", scpPath{"dev", ""}},
+		{"logs", scpPath{"", "logs"}},
+		{"./dev:file", scpPath{"", "./dev:file"}},
+		{"/tmp/a:b", scpPath{"", "/tmp/a:b"}},
+		{":file", scpPath{"", ":file"}},
+	}
+
+	for _, c := range cases {
+		if p := parseSCPPath(c.arg); p != c.expected {
+			t.Errorf("%s: expected %v, got %v", c.arg, c.expected, p)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/machine/drivers"
+	"github.com/docker/machine/utils"
+)
+
+const (
+	// secretPassphraseEnv names the environment variable holding the
+	// passphrase used to encrypt driver credentials in host configs. If it
+	// is not set, credentials are stored in plain text with a warning.
+	secretPassphraseEnv = "MACHINE_SECRET_PASSPHRASE"
+
+	encryptedSecretPrefix = "encrypted:"
+	redactedSecret        = "<redacted>"
+)
+
+// plaintextSecretsWarning warns once per run that credentials are stored in
+// plain text, as configs are saved many times by some commands
+var plaintextSecretsWarning sync.Once
+
+func getSecretPassphrase() string {
+	return os.Getenv(secretPassphraseEnv)
+}
+
+// warnPlaintextSecrets warns that the secret fields of the driver in a host
+// config are stored in plain text, if any of them is set
+func warnPlaintextSecrets(data []byte, d drivers.Driver) error {
+	found := false
+	if _, err := mapSecrets(data, d, func(value string) (string, error) {
+		found = found || value != ""
+		return value, nil
+	}); err != nil {
+		return err
+	}
+
+	if found {
+		plaintextSecretsWarning.Do(func() {
+			log.Warnf("The credentials of the %s driver are stored in plain text; set %s to encrypt them", d.DriverName(), secretPassphraseEnv)
+		})
+	}
+	return nil
+}
+
+// encryptSecrets encrypts the secret fields of the driver in a host config
+func encryptSecrets(data []byte, d drivers.Driver, passphrase string) ([]byte, error) {
+	return mapSecrets(data, d, func(value string) (string, error) {
+		if value == "" || strings.HasPrefix(value, encryptedSecretPrefix) {
+			return value, nil
+		}
+		encrypted, err := utils.Encrypt([]byte(value), passphrase)
+		if err != nil {
+			return "", err
+		}
+		return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(encrypted), nil
+	})
+}
+
+// decryptSecrets decrypts all encrypted values in the driver of a host
+// config. Unlike encryption this does not need to know the driver, so it can
+// run before the driver is loaded.
+func decryptSecrets(data []byte, passphrase string) ([]byte, error) {
+	if !bytes.Contains(data, []byte(encryptedSecretPrefix)) {
+		return data, nil
+	}
+
+	config, err := decodeConfigMap(data)
+	if err != nil {
+		return nil, err
+	}
+
+	driverConfig, ok := config["Driver"].(map[string]interface{})
+	if !ok {
+		return data, nil
+	}
+
+	var decrypt func(v interface{}) (interface{}, error)
+	decrypt = func(v interface{}) (interface{}, error) {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			for k, e := range val {
+				d, err := decrypt(e)
+				if err != nil {
+					return nil, err
+				}
+				val[k] = d
+			}
+		case string:
+			if !strings.HasPrefix(val, encryptedSecretPrefix) {
+				return val, nil
+			}
+			if passphrase == "" {
+				return nil, fmt.Errorf("the config contains encrypted credentials; please set %s", secretPassphraseEnv)
+			}
+			encrypted, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(val, encryptedSecretPrefix))
+			if err != nil {
+				return nil, err
+			}
+			plain, err := utils.Decrypt(encrypted, passphrase)
+			if err != nil {
+				return nil, err
+			}
+			return string(plain), nil
+		}
+		return v, nil
+	}
+
+	if _, err := decrypt(driverConfig); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(config)
+}
+
+// redactSecrets hides the values of the secret fields of the driver in a
+// host config
+func redactSecrets(data []byte, d drivers.Driver) ([]byte, error) {
+	return mapSecrets(data, d, func(value string) (string, error) {
+		if value == "" {
+			return value, nil
+		}
+		return redactedSecret, nil
+	})
+}
+
+// mapSecrets replaces the values of the secret fields of the driver in a
+// host config with the result of fn
+func mapSecrets(data []byte, d drivers.Driver, fn func(value string) (string, error)) ([]byte, error) {
+	fields := drivers.SecretFields(d)
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	config, err := decodeConfigMap(data)
+	if err != nil {
+		return nil, err
+	}
+
+	driverConfig, ok := config["Driver"].(map[string]interface{})
+	if !ok {
+		return data, nil
+	}
+
+	for _, field := range fields {
+		parent := driverConfig
+		for _, name := range field[:len(field)-1] {
+			if parent, ok = parent[name].(map[string]interface{}); !ok {
+				break
+			}
+		}
+		if parent == nil {
+			continue
+		}
+
+		name := field[len(field)-1]
+		value, ok := parent[name].(string)
+		if !ok {
+			continue
+		}
+		if parent[name], err = fn(value); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(config)
+}
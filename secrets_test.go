@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/machine/drivers/amazonec2"
+)
+
+func TestEncryptedSecrets(t *testing.T) {
+	store, err := getTestStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store.Path)
+
+	os.Setenv(secretPassphraseEnv, "test passphrase")
+	defer os.Unsetenv(secretPassphraseEnv)
+
+	installFixture(t, store, "staging", "v0-amazonec2.json")
+
+	// loading migrates the config, which saves it with encrypted secrets
+	host, err := store.Load("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(store.Path, "staging", "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := struct {
+		Driver struct {
+			AccessKey string
+			SecretKey string
+			Region    string
+		}
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(config.Driver.AccessKey, encryptedSecretPrefix) || !strings.HasPrefix(config.Driver.SecretKey, encryptedSecretPrefix) {
+		t.Fatalf("expected credentials to be encrypted: %s", data)
+	}
+	if config.Driver.Region != "us-east-1" {
+		t.Fatalf("expected other fields not to be encrypted: %s", data)
+	}
+
+	host, err = store.Load("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := host.Driver.(*amazonec2.Driver)
+	if d.AccessKey != "AKIAEXAMPLE" || d.SecretKey != "secret" {
+		t.Fatalf("credentials were not decrypted: %+v", d)
+	}
+
+	os.Unsetenv(secretPassphraseEnv)
+	if _, err := store.Load("staging"); err == nil || !strings.Contains(err.Error(), secretPassphraseEnv) {
+		t.Fatalf("expected load without passphrase to fail; received %v", err)
+	}
+}
+
+func TestPlaintextSecretsWarning(t *testing.T) {
+	store, err := getTestStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store.Path)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	plaintextSecretsWarning = sync.Once{}
+
+	installFixture(t, store, "staging", "v0-amazonec2.json")
+
+	// loading migrates the config, which saves it with plain text secrets
+	host, err := store.Load("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), secretPassphraseEnv) {
+		t.Fatalf("expected a warning about the plain text credentials; received %q", buf.String())
+	}
+
+	// the warning is only given once
+	buf.Reset()
+	if err := store.Save(host); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("expected no second warning; received %q", buf.String())
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	host, err := NewHost("test", "amazonec2", "", "", "", false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := host.Driver.(*amazonec2.Driver)
+	d.AccessKey = "AKIAEXAMPLE"
+	d.SecretKey = "secret"
+	d.Region = "us-east-1"
+
+	data, err := json.Marshal(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redacted, err := redactSecrets(data, host.Driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := struct {
+		Driver struct {
+			AccessKey    string
+			SecretKey    string
+			SessionToken string
+			Region       string
+		}
+	}{}
+	if err := json.Unmarshal(redacted, &config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Driver.AccessKey != redactedSecret || config.Driver.SecretKey != redactedSecret {
+		t.Fatalf("expected credentials to be redacted: %s", redacted)
+	}
+	// empty secrets are left alone
+	if config.Driver.SessionToken != "" {
+		t.Fatalf("expected empty session token: %s", redacted)
+	}
+	if config.Driver.Region != "us-east-1" {
+		t.Fatalf("expected other fields to be kept: %s", redacted)
+	}
+}
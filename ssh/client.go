@@ -0,0 +1,306 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// ClientType selects how commands are run on machines
+type ClientType string
+
+const (
+	// Native runs commands with the SSH client built into machine
+	Native ClientType = "native"
+	// External runs commands with the ssh binary
+	External ClientType = "external"
+)
+
+// defaultClientType is the type of the clients returned by NewClient
+var defaultClientType = Native
+
+// SetDefaultClientType sets the type of the clients returned by NewClient
+func SetDefaultClientType(clientType string) error {
+	switch ClientType(clientType) {
+	case Native, External:
+		defaultClientType = ClientType(clientType)
+		return nil
+	}
+	return fmt.Errorf("unknown SSH client %q; the SSH client must be %s or %s", clientType, Native, External)
+}
+
+// Client runs commands on a machine
+type Client interface {
+	// Output runs the command and returns its combined stdout and stderr
+	Output(command string) (string, error)
+
+	// Run runs the command. Its stderr goes to the stderr of machine, and
+	// its stdout too in debug mode.
+	Run(command string) error
+
+	// Shell starts an interactive shell, or runs the command given as args
+	// with the standard streams of machine attached to it
+	Shell(args ...string) error
+
+	// Upload writes the content of src to the file at path, creating its
+	// directory
+	Upload(src io.Reader, path string, mode os.FileMode) error
+}
+
+// NewClient returns a client of the default type for the machine at addr
+func NewClient(addr *Address) (Client, error) {
+	if defaultClientType == External {
+		return NewExternalClient(addr), nil
+	}
+	return NewNativeClient(addr)
+}
+
+// ExitStatus returns the exit status of a command which failed with err,
+// and false if it did not exit with a status, e.g. if it could not be run
+func ExitStatus(err error) (int, bool) {
+	switch err := err.(type) {
+	case *ssh.ExitError:
+		return err.ExitStatus(), true
+	case *exec.ExitError:
+		if status, ok := err.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus(), true
+		}
+	}
+	return 0, false
+}
+
+// uploadCommand returns the shell command writing its stdin to the file
+func uploadCommand(file string, mode os.FileMode) string {
+	return fmt.Sprintf("mkdir -p %s && cat > %s && chmod %o %s",
+		quote(path.Dir(file)), quote(file), mode.Perm(), quote(file))
+}
+
+// quote quotes s for a POSIX shell
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// NativeClient runs commands with the SSH client of golang.org/x/crypto/ssh
+type NativeClient struct {
+	Address *Address
+	Config  *ssh.ClientConfig
+}
+
+// NewNativeClient returns a native client authenticating with the key of
+// the address
+func NewNativeClient(addr *Address) (*NativeClient, error) {
+	key, err := ioutil.ReadFile(addr.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading SSH key: %s", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SSH key %s: %s", addr.KeyPath, err)
+	}
+
+	return &NativeClient{
+		Address: addr,
+		Config: &ssh.ClientConfig{
+			User:            addr.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: addr.hostKeyCallback(),
+		},
+	}, nil
+}
+
+// dialTimeout is how long connecting to a machine and the SSH handshake may
+// take, so that a machine which accepts connections but never answers does
+// not hang machine
+var dialTimeout = 30 * time.Second
+
+// dial connects to the SSH server at hostPort, giving up after dialTimeout
+func dial(hostPort string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := net.DialTimeout("tcp", hostPort, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, hostPort, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// session connects to the machine and opens a session. Closing the returned
+// client closes the session.
+func (c *NativeClient) session() (*ssh.Client, *ssh.Session, error) {
+	hostPort := net.JoinHostPort(c.Address.Host, strconv.Itoa(c.Address.Port))
+	client, err := dial(hostPort, c.Config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to %s: %s", hostPort, err)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("error opening session on %s: %s", hostPort, err)
+	}
+	return client, session, nil
+}
+
+func (c *NativeClient) run(command string, stdin io.Reader, stdout io.Writer, stderr io.Writer) error {
+	client, session, err := c.session()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session.Stdin = stdin
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	log.Debugf("running on %s: %s", c.Address.Host, command)
+	return session.Run(command)
+}
+
+// syncBuffer collects the stdout and stderr of a session, which are copied
+// concurrently
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (c *NativeClient) Output(command string) (string, error) {
+	var out syncBuffer
+	err := c.run(command, nil, &out, &out)
+	return out.String(), err
+}
+
+func (c *NativeClient) Run(command string) error {
+	var stdout io.Writer
+	if os.Getenv("DEBUG") != "" {
+		stdout = os.Stdout
+	}
+	return c.run(command, nil, stdout, os.Stderr)
+}
+
+func (c *NativeClient) Shell(args ...string) error {
+	if len(args) > 0 {
+		return c.run(strings.Join(args, " "), os.Stdin, os.Stdout, os.Stderr)
+	}
+
+	client, session, err := c.session()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer terminal.Restore(fd, state)
+
+		width, height, err := terminal.GetSize(fd)
+		if err != nil {
+			width, height = 80, 24
+		}
+
+		term := os.Getenv("TERM")
+		if term == "" {
+			term = "xterm"
+		}
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+		if err := session.RequestPty(term, height, width, modes); err != nil {
+			return fmt.Errorf("error requesting a terminal: %s", err)
+		}
+	}
+
+	if err := session.Shell(); err != nil {
+		return err
+	}
+	return session.Wait()
+}
+
+func (c *NativeClient) Upload(src io.Reader, path string, mode os.FileMode) error {
+	var out syncBuffer
+	if err := c.run(uploadCommand(path, mode), src, &out, &out); err != nil {
+		return fmt.Errorf("error uploading %s: %s: %s", path, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// ExternalClient runs commands with the ssh binary, as GetSSHCommand does
+type ExternalClient struct {
+	Address *Address
+}
+
+func NewExternalClient(addr *Address) *ExternalClient {
+	return &ExternalClient{Address: addr}
+}
+
+func (c *ExternalClient) command(args ...string) *exec.Cmd {
+	return c.Address.Command(args...)
+}
+
+func (c *ExternalClient) Output(command string) (string, error) {
+	cmd := c.command(command)
+	cmd.Stdout = nil
+	cmd.Stderr = nil
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func (c *ExternalClient) Run(command string) error {
+	return c.command(command).Run()
+}
+
+func (c *ExternalClient) Shell(args ...string) error {
+	cmd := c.command(args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func (c *ExternalClient) Upload(src io.Reader, path string, mode os.FileMode) error {
+	cmd := c.command(uploadCommand(path, mode))
+	cmd.Stdin = src
+	return cmd.Run()
+}
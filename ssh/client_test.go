@@ -0,0 +1,406 @@
+package ssh
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an SSH server which runs the commands it is sent with sh
+type testServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+}
+
+func newTestServer(t *testing.T) *testServer {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{listener, config}
+	go s.serve()
+	return s
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+			if err != nil {
+				return
+			}
+			go ssh.DiscardRequests(requests)
+			for newChannel := range channels {
+				if newChannel.ChannelType() == "direct-tcpip" {
+					go handleTestForward(newChannel)
+					continue
+				}
+				channel, requests, err := newChannel.Accept()
+				if err != nil {
+					return
+				}
+				go handleTestSession(channel, requests)
+			}
+		}()
+	}
+}
+
+func handleTestSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		req.Reply(true, nil)
+
+		length := binary.BigEndian.Uint32(req.Payload)
+		cmd := exec.Command("sh", "-c", string(req.Payload[4:4+length]))
+		cmd.Stdin = channel
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+
+		status := uint32(0)
+		if err := cmd.Run(); err != nil {
+			code, _ := ExitStatus(err)
+			status = uint32(code)
+		}
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
+
+// handleTestForward connects a forwarded channel to the address it asks for
+func handleTestForward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	// half-close the target at the end of the channel, as sshd does
+	go func() {
+		io.Copy(conn, channel)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+	conn.Close()
+}
+
+func (s *testServer) Close() {
+	s.listener.Close()
+}
+
+// address returns the address of the server, with a client key in dir
+func (s *testServer) address(t *testing.T, dir string) *Address {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_rsa")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(keyPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tcpAddr := s.listener.Addr().(*net.TCPAddr)
+	return &Address{User: "docker", Host: tcpAddr.IP.String(), Port: tcpAddr.Port, KeyPath: keyPath}
+}
+
+func newTestClient(t *testing.T) (*NativeClient, string, func()) {
+	dir, err := ioutil.TempDir("", "machine-ssh-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTestServer(t)
+
+	client, err := NewNativeClient(server.address(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, dir, func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNativeClientOutput(t *testing.T) {
+	client, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	out, err := client.Output("echo hello; echo world >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "hello\n") || !strings.Contains(out, "world\n") {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
+
+func TestNativeClientExitStatus(t *testing.T) {
+	client, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := client.Run("exit 3")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if status, ok := ExitStatus(err); !ok || status != 3 {
+		t.Fatalf("expected exit status 3, got %d (%v)", status, err)
+	}
+
+	if err := client.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNativeClientUpload(t *testing.T) {
+	client, dir, cleanup := newTestClient(t)
+	defer cleanup()
+
+	// the test server runs the commands locally
+	path := filepath.Join(dir, "certs", "ca.pem")
+	if err := client.Upload(strings.NewReader("certificate"), path, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "certificate" {
+		t.Fatalf("unexpected content %q", data)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("unexpected mode %s", info.Mode())
+	}
+}
+
+func TestNativeClientBadKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "machine-ssh-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyPath := filepath.Join(dir, "id_rsa")
+	if err := ioutil.WriteFile(keyPath, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewNativeClient(&Address{KeyPath: keyPath}); err == nil {
+		t.Fatal("expected an error for an invalid key")
+	}
+}
+
+func TestNativeClientDialTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { dialTimeout = timeout }(dialTimeout)
+	dialTimeout = 100 * time.Millisecond
+
+	client, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	// a server which accepts connections but never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	client.Address.Port = listener.Addr().(*net.TCPAddr).Port
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Output("true")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection did not time out")
+	}
+}
+
+func TestSetDefaultClientType(t *testing.T) {
+	defer SetDefaultClientType(string(Native))
+
+	if err := SetDefaultClientType("external"); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(&Address{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := client.(*ExternalClient); !ok {
+		t.Fatalf("expected an external client, got %T", client)
+	}
+
+	if err := SetDefaultClientType("putty"); err == nil {
+		t.Fatal("expected an error for an unknown client")
+	}
+}
+
+func TestNativeClientHostKeyPinning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "machine-ssh-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := newTestServer(t)
+	defer server.Close()
+
+	addr := server.address(t, dir)
+	addr.KnownHostsFile = filepath.Join(dir, "known_hosts")
+	addr.HostKeyAlias = "dev"
+
+	client, err := NewNativeClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first connection pins the key
+	if err := client.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := addr.pinnedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected a pinned key, got %d", len(keys))
+	}
+	if err := client.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a server with another key at the same address is rejected
+	other := newTestServer(t)
+	defer other.Close()
+	otherAddr := other.address(t, dir)
+	addr.Host, addr.Port = otherAddr.Host, otherAddr.Port
+
+	err = client.Run("true")
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("expected a host key mismatch, got %v", err)
+	}
+
+	// after a reset the new key is pinned
+	key, err := ScanHostKey(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(addr.KnownHostsFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := addr.CheckHostKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Run("true"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalOptionsPinning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "machine-ssh-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addr := &Address{User: "docker", Host: "1.2.3.4", Port: 22, KeyPath: "id_rsa"}
+	if args := strings.Join(addr.Command().Args, " "); !strings.Contains(args, "UserKnownHostsFile=/dev/null") {
+		t.Fatalf("expected no host key checking without a known hosts file: %s", args)
+	}
+
+	addr.KnownHostsFile = filepath.Join(dir, "known_hosts")
+	addr.HostKeyAlias = "dev"
+	args := strings.Join(addr.Command().Args, " ")
+	for _, expected := range []string{"StrictHostKeyChecking=no", "UserKnownHostsFile=" + addr.KnownHostsFile, "HostKeyAlias=dev"} {
+		if !strings.Contains(args, expected) {
+			t.Errorf("expected %q in %q", expected, args)
+		}
+	}
+
+	server := newTestServer(t)
+	defer server.Close()
+	key, err := ScanHostKey(server.address(t, dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addr.PinHostKey(key); err != nil {
+		t.Fatal(err)
+	}
+	if args := strings.Join(addr.Command().Args, " "); !strings.Contains(args, "StrictHostKeyChecking=yes") {
+		t.Fatalf("expected strict host key checking once the key is pinned: %s", args)
+	}
+}
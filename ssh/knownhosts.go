@@ -0,0 +1,157 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// errKeyScanned stops a connection of ScanHostKey once it has the host key
+var errKeyScanned = errors.New("host key scanned")
+
+// HostKeyMismatchError is returned when a machine presents a host key other
+// than the pinned one
+type HostKeyMismatchError struct {
+	Alias       string
+	Fingerprint string
+	File        string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("the host key of %s (%s) does not match the key pinned in %s; "+
+		"if the machine was re-created, pin its new key with ssh-keyscan --reset", e.Alias, e.Fingerprint, e.File)
+}
+
+// Fingerprint returns the MD5 fingerprint of the key as shown by ssh
+func Fingerprint(key ssh.PublicKey) string {
+	sum := md5.Sum(key.Marshal())
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02x", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// KnownHostsLine returns the line pinning the key in a known hosts file
+func (a *Address) KnownHostsLine(key ssh.PublicKey) string {
+	return a.HostKeyAlias + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// pinnedKeys returns the keys pinned for the machine in KnownHostsFile
+func (a *Address) pinnedKeys() ([]ssh.PublicKey, error) {
+	f, err := os.Open(a.KnownHostsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	keys := []ssh.PublicKey{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if !matchesAlias(fields[0], a.HostKeyAlias) {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in %s: %s", a.KnownHostsFile, err)
+		}
+		key, err := ssh.ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in %s: %s", a.KnownHostsFile, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+func matchesAlias(hosts string, alias string) bool {
+	for _, host := range strings.Split(hosts, ",") {
+		if host == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// PinHostKey adds the key to KnownHostsFile
+func (a *Address) PinHostKey(key ssh.PublicKey) error {
+	f, err := os.OpenFile(a.KnownHostsFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, a.KnownHostsLine(key)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// CheckHostKey checks the key against the pinned keys. If no key is pinned
+// yet, the key is pinned.
+func (a *Address) CheckHostKey(key ssh.PublicKey) error {
+	keys, err := a.pinnedKeys()
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return a.PinHostKey(key)
+	}
+
+	for _, pinned := range keys {
+		if bytes.Equal(pinned.Marshal(), key.Marshal()) {
+			return nil
+		}
+	}
+	return &HostKeyMismatchError{Alias: a.HostKeyAlias, Fingerprint: Fingerprint(key), File: a.KnownHostsFile}
+}
+
+// hostKeyCallback returns the callback checking the host key for the native
+// client, or nil to accept any key if no key is pinned
+func (a *Address) hostKeyCallback() func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if a.KnownHostsFile == "" {
+		return nil
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return a.CheckHostKey(key)
+	}
+}
+
+// ScanHostKey connects to the machine and returns its host key without
+// checking it
+func ScanHostKey(a *Address) (ssh.PublicKey, error) {
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: a.User,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			// stop before authenticating
+			return errKeyScanned
+		},
+	}
+
+	hostPort := net.JoinHostPort(a.Host, fmt.Sprintf("%d", a.Port))
+	client, err := dial(hostPort, config)
+	if client != nil {
+		client.Close()
+	}
+	if hostKey == nil {
+		return nil, fmt.Errorf("error getting host key of %s: %s", hostPort, err)
+	}
+	return hostKey, nil
+}
@@ -0,0 +1,106 @@
+package ssh
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// The parts of SOCKS5 (RFC 1928) used by the tunnel's proxy, which only
+// supports CONNECT without authentication
+const (
+	socksVersion       = 5
+	socksNoAuth        = 0
+	socksNoAcceptable  = 0xff
+	socksConnect       = 1
+	socksIPv4          = 1
+	socksDomain        = 3
+	socksIPv6          = 4
+	socksSucceeded     = 0
+	socksGeneralFailed = 1
+	socksNotSupported  = 7
+)
+
+// socksHandshake reads the greeting and the CONNECT request of a SOCKS5
+// client and returns the address it wants to connect to. The client must be
+// answered with socksReply.
+func socksHandshake(rw io.ReadWriter) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(rw, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(rw, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := rw.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksNoAcceptable {
+		return "", fmt.Errorf("no supported SOCKS authentication method")
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(rw, request); err != nil {
+		return "", err
+	}
+	if request[1] != socksConnect {
+		rw.Write([]byte{socksVersion, socksNotSupported, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksIPv4, socksIPv6:
+		ip := make([]byte, net.IPv4len)
+		if request[3] == socksIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(rw, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(rw, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(rw, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		rw.Write([]byte{socksVersion, socksNotSupported, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(rw, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply tells a SOCKS5 client whether its connection succeeded. The
+// bound address is not reported, as clients do not need it for CONNECT.
+func socksReply(w io.Writer, ok bool) error {
+	status := byte(socksSucceeded)
+	if !ok {
+		status = socksGeneralFailed
+	}
+	_, err := w.Write([]byte{socksVersion, status, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
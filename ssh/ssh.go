@@ -2,27 +2,72 @@ package ssh
 
 import (
 	"fmt"
-	"net"
 	"os"
 	"os/exec"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/docker/machine/wait"
 )
 
+// defaultOptions are the options of ssh and scp for connecting to machines,
+// whose host keys are not known
+var defaultOptions = []string{
+	"-o", "IdentitiesOnly=yes",
+	"-o", "StrictHostKeyChecking=no",
+	"-o", "UserKnownHostsFile=/dev/null",
+	"-o", "LogLevel=quiet", // suppress "Warning: Permanently added '[localhost]:2022' (ECDSA) to the list of known hosts."
+}
+
 func GetSSHCommand(host string, port int, user string, sshKey string, args ...string) *exec.Cmd {
+	addr := &Address{User: user, Host: host, Port: port, KeyPath: sshKey}
+	return addr.Command(args...)
+}
+
+// Address is where and as whom to connect to a machine with SSH
+type Address struct {
+	User    string
+	Host    string
+	Port    int
+	KeyPath string
+
+	// KnownHostsFile is where the host key of the machine is pinned. If it
+	// is empty, the host key is not checked.
+	KnownHostsFile string
+	// HostKeyAlias names the machine in KnownHostsFile, so that the pinned
+	// key still applies if the IP of the machine changes
+	HostKeyAlias string
+}
+
+// options returns the options of ssh and scp for connecting to the machine
+func (a *Address) options() []string {
+	if a.KnownHostsFile == "" {
+		return defaultOptions
+	}
 
-	defaultSSHArgs := []string{
+	// the key is added on the first connection and checked on the others
+	strict := "no"
+	if keys, err := a.pinnedKeys(); err == nil && len(keys) > 0 {
+		strict = "yes"
+	}
+	return []string{
 		"-o", "IdentitiesOnly=yes",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "LogLevel=quiet", // suppress "Warning: Permanently added '[localhost]:2022' (ECDSA) to the list of known hosts."
-		"-p", fmt.Sprintf("%d", port),
-		"-i", sshKey,
-		fmt.Sprintf("%s@%s", user, host),
+		"-o", "StrictHostKeyChecking=" + strict,
+		"-o", "UserKnownHostsFile=" + a.KnownHostsFile,
+		"-o", "HostKeyAlias=" + a.HostKeyAlias,
+		"-o", "LogLevel=error", // quiet would hide a changed host key
 	}
+}
+
+// Command returns the ssh command running args on the machine
+func (a *Address) Command(args ...string) *exec.Cmd {
+	sshArgs := append(append([]string{}, a.options()...),
+		"-p", fmt.Sprintf("%d", a.Port),
+		"-i", a.KeyPath,
+		fmt.Sprintf("%s@%s", a.User, a.Host),
+	)
 
-	sshArgs := append(defaultSSHArgs, args...)
+	sshArgs = append(sshArgs, args...)
 	cmd := exec.Command("ssh", sshArgs...)
 	cmd.Stderr = os.Stderr
 
@@ -35,6 +80,38 @@ func GetSSHCommand(host string, port int, user string, sshKey string, args ...st
 	return cmd
 }
 
+// Remote returns the scp argument for the path on the machine
+func (a *Address) Remote(path string) string {
+	host := a.Host
+	if strings.Contains(host, ":") {
+		// IPv6 addresses must be bracketed
+		host = "[" + host + "]"
+	}
+	return fmt.Sprintf("%s@%s:%s", a.User, host, path)
+}
+
+// GetSCPCommand returns a command copying the paths to the last path. The
+// paths can be on the machine at addr as returned by Remote.
+func GetSCPCommand(addr *Address, recursive bool, paths ...string) *exec.Cmd {
+	args := append([]string{}, addr.options()...)
+	args = append(args,
+		"-P", fmt.Sprintf("%d", addr.Port),
+		"-i", addr.KeyPath,
+	)
+	if recursive {
+		args = append(args, "-r")
+	}
+	args = append(args, paths...)
+
+	cmd := exec.Command("scp", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	log.Debugf("executing: %v", strings.Join(cmd.Args, " "))
+
+	return cmd
+}
+
 func GenerateSSHKey(path string) error {
 	if _, err := exec.LookPath("ssh-keygen"); err != nil {
 		return fmt.Errorf("ssh-keygen not found in the path, please install ssh-keygen")
@@ -61,17 +138,8 @@ func GenerateSSHKey(path string) error {
 	return nil
 }
 
+// WaitForTCP waits until the port at addr accepts connections, up to the
+// default timeout of the wait package
 func WaitForTCP(addr string) error {
-	for {
-		conn, err := net.Dial("tcp", addr)
-		if err != nil {
-			continue
-		}
-		defer conn.Close()
-		if _, err = conn.Read(make([]byte, 1)); err != nil {
-			continue
-		}
-		break
-	}
-	return nil
+	return wait.ForTCP(addr, 0)
 }
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -26,3 +27,16 @@ func TestGenerateSSHKey(t *testing.T) {
 	// cleanup
 	_ = os.RemoveAll(tmpDir)
 }
+
+func TestGetSCPCommand(t *testing.T) {
+	addr := &Address{User: "ubuntu", Host: "fe80::1", Port: 22, KeyPath: "/tmp/id_rsa"}
+
+	cmd := GetSCPCommand(addr, true, "logs", addr.Remote("/var/log"))
+
+	args := strings.Join(cmd.Args, " ")
+	for _, expected := range []string{"-P 22", "-i /tmp/id_rsa", "-r logs ubuntu@[fe80::1]:/var/log"} {
+		if !strings.Contains(args, expected) {
+			t.Errorf("expected %q in %q", expected, args)
+		}
+	}
+}
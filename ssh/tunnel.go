@@ -0,0 +1,271 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+)
+
+// Forward forwards connections to Local to Remote, as seen from the machine
+type Forward struct {
+	Local  string
+	Remote string
+}
+
+// ParseForward parses a forward given as [bind_address:]port:host:hostport,
+// like ssh -L, or as port:hostport to forward to a port on the machine
+// itself. The bind address defaults to localhost.
+func ParseForward(spec string) (Forward, error) {
+	var bind, port, host, hostPort string
+
+	parts := splitForward(spec)
+	switch len(parts) {
+	case 2:
+		bind, port, host, hostPort = "localhost", parts[0], "localhost", parts[1]
+	case 3:
+		bind, port, host, hostPort = "localhost", parts[0], parts[1], parts[2]
+	case 4:
+		bind, port, host, hostPort = parts[0], parts[1], parts[2], parts[3]
+	default:
+		return Forward{}, fmt.Errorf("invalid forward %q; forwards must be given as [bind_address:]port:host:hostport", spec)
+	}
+
+	for _, p := range []string{port, hostPort} {
+		if _, err := strconv.ParseUint(p, 10, 16); err != nil {
+			return Forward{}, fmt.Errorf("invalid forward %q: invalid port %q", spec, p)
+		}
+	}
+	if host == "" {
+		return Forward{}, fmt.Errorf("invalid forward %q: missing host", spec)
+	}
+
+	return Forward{
+		Local:  net.JoinHostPort(bind, port),
+		Remote: net.JoinHostPort(host, hostPort),
+	}, nil
+}
+
+// splitForward splits a forward at the colons which are not inside the
+// brackets of an IPv6 address, and removes the brackets
+func splitForward(spec string) []string {
+	parts := []string{}
+	start, inBrackets := 0, false
+	for i, c := range spec {
+		switch c {
+		case '[':
+			inBrackets = true
+		case ']':
+			inBrackets = false
+		case ':':
+			if !inBrackets {
+				parts = append(parts, spec[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, spec[start:])
+
+	for i, part := range parts {
+		parts[i] = strings.TrimSuffix(strings.TrimPrefix(part, "["), "]")
+	}
+	return parts
+}
+
+// Tunnel forwards local ports over an SSH connection to a machine. Tunnels
+// always use the native client, whatever the default client type is.
+type Tunnel struct {
+	client *NativeClient
+
+	mu        sync.Mutex
+	conn      *ssh.Client
+	listeners []net.Listener
+	closed    bool
+}
+
+// NewTunnel returns a tunnel to the machine at addr
+func NewTunnel(addr *Address) (*Tunnel, error) {
+	client, err := NewNativeClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Tunnel{client: client}, nil
+}
+
+// listen listens on the local address and hands the accepted connections to
+// handle. It returns the address listened on, which has the actual port if
+// the port of local is 0.
+func (t *Tunnel) listen(local string, handle func(conn net.Conn)) (net.Addr, error) {
+	listener, err := net.Listen("tcp", local)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %s", local, err)
+	}
+
+	t.mu.Lock()
+	t.listeners = append(t.listeners, listener)
+	t.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return listener.Addr(), nil
+}
+
+// Forward forwards the connections to the local address to the remote
+// address, as seen from the machine
+func (t *Tunnel) Forward(f Forward) (net.Addr, error) {
+	return t.listen(f.Local, func(conn net.Conn) {
+		t.forward(conn, f.Remote, nil)
+	})
+}
+
+// SOCKS runs a SOCKS5 proxy on the local address which connects from the
+// machine
+func (t *Tunnel) SOCKS(local string) (net.Addr, error) {
+	return t.listen(local, func(conn net.Conn) {
+		remote, err := socksHandshake(conn)
+		if err != nil {
+			log.Debugf("SOCKS handshake with %s failed: %s", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
+		t.forward(conn, remote, func(ok bool) error {
+			return socksReply(conn, ok)
+		})
+	})
+}
+
+// forward connects conn to the remote address over the SSH connection. If
+// reply is given, it is told whether the connection succeeded.
+func (t *Tunnel) forward(conn net.Conn, remote string, reply func(ok bool) error) {
+	defer conn.Close()
+
+	sshConn, err := t.connection()
+	if err != nil {
+		log.Debugf("error forwarding %s: %s", conn.RemoteAddr(), err)
+		if reply != nil {
+			reply(false)
+		}
+		return
+	}
+
+	remoteConn, err := sshConn.Dial("tcp", remote)
+	if reply != nil {
+		if replyErr := reply(err == nil); replyErr != nil && err == nil {
+			remoteConn.Close()
+			return
+		}
+	}
+	if err != nil {
+		log.Debugf("error connecting to %s from %s: %s", remote, t.client.Address.Host, err)
+		return
+	}
+	defer remoteConn.Close()
+
+	log.Debugf("forwarding %s to %s", conn.RemoteAddr(), remote)
+	done := make(chan struct{}, 2)
+	go func() {
+		copyHalf(remoteConn, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		copyHalf(conn, remoteConn)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// closeWriter is a connection which can be closed for writing only, such as
+// a TCP connection or an SSH channel
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// copyHalf copies one direction of a forwarded connection. When src ends,
+// dst is closed for writing, so that the other direction still works for
+// protocols which send a request and then wait for the reply. If dst cannot
+// be half-closed or the copy fails, both connections are closed.
+func copyHalf(dst net.Conn, src net.Conn) {
+	_, err := io.Copy(dst, src)
+	if writer, ok := dst.(closeWriter); ok && err == nil {
+		if writer.CloseWrite() == nil {
+			return
+		}
+	}
+	dst.Close()
+	src.Close()
+}
+
+// connection returns the SSH connection of the tunnel, connecting if it
+// is not connected yet
+func (t *Tunnel) connection() (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil, fmt.Errorf("tunnel closed")
+	}
+	if t.conn != nil {
+		return t.conn, nil
+	}
+
+	addr := t.client.Address
+	hostPort := net.JoinHostPort(addr.Host, strconv.Itoa(addr.Port))
+	conn, err := dial(hostPort, t.client.Config)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %s", hostPort, err)
+	}
+	t.conn = conn
+	return conn, nil
+}
+
+// Serve connects to the machine and forwards connections until the SSH
+// connection is lost or the tunnel is closed
+func (t *Tunnel) Serve() error {
+	conn, err := t.connection()
+	if err != nil {
+		return err
+	}
+
+	err = conn.Wait()
+
+	t.mu.Lock()
+	closed := t.closed
+	t.mu.Unlock()
+	if closed {
+		return nil
+	}
+	t.Close()
+	return fmt.Errorf("lost the SSH connection to %s: %v", t.client.Address.Host, err)
+}
+
+// Close stops listening and closes the SSH connection
+func (t *Tunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+
+	for _, listener := range t.listeners {
+		listener.Close()
+	}
+	if t.conn != nil {
+		return t.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		forward Forward
+	}{
+		{"8080:80", Forward{"localhost:8080", "localhost:80"}},
+		{"8080:db:5432", Forward{"localhost:8080", "db:5432"}},
+		{"0.0.0.0:8080:10.0.0.5:80", Forward{"0.0.0.0:8080", "10.0.0.5:80"}},
+		{"[::1]:8080:[fe80::1]:80", Forward{"[::1]:8080", "[fe80::1]:80"}},
+	}
+
+	for _, test := range tests {
+		forward, err := ParseForward(test.spec)
+		if err != nil {
+			t.Errorf("%s: %s", test.spec, err)
+			continue
+		}
+		if forward != test.forward {
+			t.Errorf("%s: expected %+v, got %+v", test.spec, test.forward, forward)
+		}
+	}
+
+	for _, spec := range []string{"8080", "a:b:c:d:e", "x:80", "8080:host:99999", "8080::80"} {
+		if _, err := ParseForward(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestSOCKSHandshake(t *testing.T) {
+	tests := []struct {
+		request []byte
+		address string
+	}{
+		{[]byte{5, 1, 0, 5, 1, 0, 1, 10, 0, 0, 5, 0, 80}, "10.0.0.5:80"},
+		{append(append([]byte{5, 2, 2, 0, 5, 1, 0, 3, 4}, "host"...), 0x1f, 0x90), "host:8080"},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		rw := struct {
+			io.Reader
+			io.Writer
+		}{bytes.NewReader(test.request), &out}
+
+		address, err := socksHandshake(rw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if address != test.address {
+			t.Errorf("expected %s, got %s", test.address, address)
+		}
+		if !bytes.Equal(out.Bytes(), []byte{5, 0}) {
+			t.Errorf("unexpected method selection %v", out.Bytes())
+		}
+	}
+
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader([]byte{5, 1, 2}), ioutil.Discard}
+	if _, err := socksHandshake(rw); err == nil {
+		t.Error("expected an error without a supported method")
+	}
+}
+
+// newEchoServer returns the address of a server echoing lines
+func newEchoServer(t *testing.T) (net.Listener, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return listener, listener.Addr().String()
+}
+
+func echo(t *testing.T, conn net.Conn) {
+	if _, err := conn.Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "hello\n" {
+		t.Fatalf("expected hello, got %q", line)
+	}
+}
+
+func TestTunnel(t *testing.T) {
+	client, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	echoServer, echoAddr := newEchoServer(t)
+	defer echoServer.Close()
+
+	tunnel, err := NewTunnel(client.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forwardAddr, err := tunnel.Forward(Forward{Local: "127.0.0.1:0", Remote: echoAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	socksAddr, err := tunnel.SOCKS("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() {
+		served <- tunnel.Serve()
+	}()
+
+	conn, err := net.Dial("tcp", forwardAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, conn)
+	conn.Close()
+
+	conn, err = net.Dial("tcp", socksAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(echoAddr)
+	portNumber, _ := strconv.Atoi(port)
+	request := append([]byte{5, 1, 0, 5, 1, 0, 1}, net.ParseIP(host).To4()...)
+	request = append(request, byte(portNumber>>8), byte(portNumber))
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 12)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply[:3], []byte{5, 0, 5}) || reply[3] != 0 {
+		t.Fatalf("unexpected SOCKS reply %v", reply)
+	}
+	echo(t, conn)
+	conn.Close()
+
+	tunnel.Close()
+	if err := <-served; err != nil {
+		t.Fatalf("expected no error after Close, got %s", err)
+	}
+	if _, err := net.Dial("tcp", forwardAddr.String()); err == nil {
+		t.Error("expected the forward to be closed")
+	}
+}
+
+func TestTunnelHalfClose(t *testing.T) {
+	client, _, cleanup := newTestClient(t)
+	defer cleanup()
+
+	// a server which replies only after the request is complete
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				request, _ := ioutil.ReadAll(conn)
+				conn.Write(append([]byte("reply to "), request...))
+				conn.Close()
+			}()
+		}
+	}()
+
+	tunnel, err := NewTunnel(client.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+	forwardAddr, err := tunnel.Forward(Forward{Local: "127.0.0.1:0", Remote: listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", forwardAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("request")); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply) != "reply to request" {
+		t.Fatalf("expected the reply after closing the request; received %q", reply)
+	}
+}
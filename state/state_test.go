@@ -14,4 +14,7 @@ func TestDaemonCreate(t *testing.T) {
 	if Error.String() != "Error" {
 		t.Fatal("Error state should be 'Error'")
 	}
+	if Timeout.String() != "Timeout" {
+		t.Fatal("Timeout state should be 'Timeout'")
+	}
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/machine/drivers"
+	"github.com/docker/machine/state"
+	"github.com/docker/machine/utils"
+)
+
+// stateCacheTTL is how long a cached state is used before the provider is
+// queried again
+const stateCacheTTL = time.Minute
+
+// stateCacheMode controls how the state cache is used
+type stateCacheMode int
+
+const (
+	// stateCacheUse uses fresh cached states and caches queried states
+	stateCacheUse stateCacheMode = iota
+	// stateCacheRefresh queries all states and caches them
+	stateCacheRefresh
+	// stateCacheOff queries all states without caching them
+	stateCacheOff
+)
+
+// hostState is the state of a host as cached next to its config
+type hostState struct {
+	State     state.State
+	URL       string
+	UpdatedAt time.Time
+}
+
+func (h *Host) stateCachePath() string {
+	return filepath.Join(h.storePath, "state.json")
+}
+
+// cachedState returns the cached state of the host if it is younger than ttl
+func (h *Host) cachedState(ttl time.Duration) (*hostState, bool) {
+	data, err := ioutil.ReadFile(h.stateCachePath())
+	if err != nil {
+		return nil, false
+	}
+
+	var cached hostState
+	if err := json.Unmarshal(data, &cached); err != nil {
+		log.Debugf("error reading state cache of host %s: %s", h.Name, err)
+		return nil, false
+	}
+
+	if time.Since(cached.UpdatedAt) > ttl {
+		return nil, false
+	}
+	return &cached, true
+}
+
+// queryState gets the state and URL of the host from its driver
+func (h *Host) queryState() (*hostState, error) {
+	currentState, err := h.Driver.GetState()
+	if err != nil {
+		return &hostState{State: currentState}, fmt.Errorf("error getting state: %s", err)
+	}
+
+	url, err := h.GetURL()
+	if err != nil {
+		if err == drivers.ErrHostIsNotRunning {
+			url = ""
+		} else {
+			return &hostState{State: currentState}, fmt.Errorf("error getting URL: %s", err)
+		}
+	}
+
+	return &hostState{
+		State:     currentState,
+		URL:       url,
+		UpdatedAt: time.Now(),
+	}, nil
+}
+
+// getState returns the state of the host, using and updating the state cache
+// as set by mode
+func (h *Host) getState(mode stateCacheMode) (*hostState, error) {
+	if mode == stateCacheUse {
+		if cached, ok := h.cachedState(stateCacheTTL); ok {
+			return cached, nil
+		}
+	}
+
+	current, err := h.queryState()
+	if err != nil {
+		// failures are not cached, so the next call queries again
+		h.invalidateStateCache()
+		return current, err
+	}
+
+	if mode != stateCacheOff {
+		h.saveStateCache(current)
+	}
+	return current, nil
+}
+
+// getStateWithTimeout is getState with a deadline. A host which does not
+// answer in time is in the Timeout state. A timeout of 0 means no deadline.
+func (h *Host) getStateWithTimeout(mode stateCacheMode, timeout time.Duration) (*hostState, error) {
+	if timeout <= 0 {
+		return h.getState(mode)
+	}
+
+	type result struct {
+		state *hostState
+		err   error
+	}
+
+	// buffered, so the query does not leak when it answers too late
+	results := make(chan result, 1)
+	go func() {
+		current, err := h.getState(mode)
+		results <- result{current, err}
+	}()
+
+	select {
+	case r := <-results:
+		return r.state, r.err
+	case <-time.After(timeout):
+		return &hostState{State: state.Timeout}, fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// refreshStateCache queries and caches the state of the host, e.g. after a
+// lifecycle command changed it
+func (h *Host) refreshStateCache() {
+	if _, err := h.getState(stateCacheRefresh); err != nil {
+		log.Debugf("error refreshing state of host %s: %s", h.Name, err)
+	}
+}
+
+func (h *Host) saveStateCache(current *hostState) {
+	data, err := json.Marshal(current)
+	if err != nil {
+		log.Debugf("error encoding state cache of host %s: %s", h.Name, err)
+		return
+	}
+	if err := utils.WriteFileAtomic(h.stateCachePath(), data, 0600); err != nil {
+		log.Debugf("error writing state cache of host %s: %s", h.Name, err)
+	}
+}
+
+func (h *Host) invalidateStateCache() {
+	if err := os.Remove(h.stateCachePath()); err != nil && !os.IsNotExist(err) {
+		log.Debugf("error removing state cache of host %s: %s", h.Name, err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/state"
+)
+
+func getTestCacheHost(t *testing.T) *Host {
+	storePath, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Host{
+		Name:       "test",
+		DriverName: "fakedriver",
+		Driver:     &FakeDriver{MockState: state.Running},
+		storePath:  storePath,
+	}
+}
+
+func TestStateCache(t *testing.T) {
+	host := getTestCacheHost(t)
+	defer os.RemoveAll(host.storePath)
+	driver := host.Driver.(*FakeDriver)
+
+	if current, _ := host.getState(stateCacheUse); current.State != state.Running {
+		t.Fatalf("expected state Running; received %s", current.State)
+	}
+
+	driver.MockState = state.Stopped
+
+	if current, _ := host.getState(stateCacheUse); current.State != state.Running {
+		t.Fatalf("expected cached state Running; received %s", current.State)
+	}
+
+	if current, _ := host.getState(stateCacheOff); current.State != state.Stopped {
+		t.Fatalf("expected state Stopped; received %s", current.State)
+	}
+	if current, _ := host.getState(stateCacheUse); current.State != state.Running {
+		t.Fatalf("expected the cache not to be updated; received %s", current.State)
+	}
+
+	if current, _ := host.getState(stateCacheRefresh); current.State != state.Stopped {
+		t.Fatalf("expected state Stopped; received %s", current.State)
+	}
+	if current, _ := host.getState(stateCacheUse); current.State != state.Stopped {
+		t.Fatalf("expected cached state Stopped; received %s", current.State)
+	}
+}
+
+func TestStateCacheExpires(t *testing.T) {
+	host := getTestCacheHost(t)
+	defer os.RemoveAll(host.storePath)
+
+	host.saveStateCache(&hostState{State: state.Stopped, UpdatedAt: time.Now().Add(-2 * stateCacheTTL)})
+
+	if _, ok := host.cachedState(stateCacheTTL); ok {
+		t.Fatal("expected the cached state to be expired")
+	}
+	if current, _ := host.getState(stateCacheUse); current.State != state.Running {
+		t.Fatalf("expected state Running; received %s", current.State)
+	}
+}
+
+func TestActionsUpdateStateCache(t *testing.T) {
+	host := getTestCacheHost(t)
+	defer os.RemoveAll(host.storePath)
+
+	host.getState(stateCacheUse)
+
+	runActionForeachMachine("stop", []*Host{host}, defaultParallel)
+
+	cached, ok := host.cachedState(stateCacheTTL)
+	if !ok {
+		t.Fatal("expected a cached state")
+	}
+	if cached.State != state.Stopped {
+		t.Fatalf("expected cached state Stopped; received %s", cached.State)
+	}
+}
+
+type slowDriver struct {
+	FakeDriver
+	delay time.Duration
+}
+
+func (d *slowDriver) GetState() (state.State, error) {
+	time.Sleep(d.delay)
+	return d.MockState, nil
+}
+
+func TestGetStateWithTimeout(t *testing.T) {
+	host := getTestCacheHost(t)
+	defer os.RemoveAll(host.storePath)
+	host.Driver = &slowDriver{FakeDriver{MockState: state.Running}, 200 * time.Millisecond}
+
+	current, err := host.getStateWithTimeout(stateCacheOff, 10*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected an error for a host which does not answer in time")
+	}
+	if current.State != state.Timeout {
+		t.Fatalf("expected state Timeout; received %s", current.State)
+	}
+
+	current, err = host.getStateWithTimeout(stateCacheOff, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.State != state.Running {
+		t.Fatalf("expected state Running; received %s", current.State)
+	}
+}
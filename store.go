@@ -2,32 +2,73 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/machine/drivers"
 	"github.com/docker/machine/utils"
+	"github.com/docker/machine/wait"
 )
 
-// Store persists hosts on the filesystem
-type Store struct {
-	Path           string
-	CaCertPath     string
-	PrivateKeyPath string
-}
+// Store persists hosts and keeps track of the active host
+type Store interface {
+	// Create creates a new host using the given driver and flags and
+	// persists it in the store. The waits of the create are limited by waiter,
+	// which may be nil.
+	Create(name string, driverName string, flags drivers.DriverOptions, waiter *wait.Waiter) (*Host, error)
 
-func NewStore(rootPath string, caCert string, privateKey string) *Store {
-	if rootPath == "" {
-		rootPath = utils.GetMachineDir()
-	}
+	// Delete deletes the host from the store without removing it from the
+	// provider. It does not take the lock of the host.
+	Delete(name string) error
+
+	// Exists returns whether a host with the given name is in the store
+	Exists(name string) (bool, error)
+
+	// GetActive returns the active host, or nil if there is none
+	GetActive() (*Host, error)
+
+	// IsActive returns whether the given host is the active host
+	IsActive(host *Host) (bool, error)
+
+	// Lock takes the advisory lock of the named host so that concurrent
+	// machine processes do not operate on the same host
+	Lock(name string) error
+
+	// List returns all of the hosts in the store
+	List() ([]Host, error)
+
+	// Load loads the host with the given name from the store
+	Load(name string) (*Host, error)
+
+	// Remove removes the host from the provider and from the store. If force
+	// is set the host is removed from the store even if the provider fails.
+	Remove(name string, force bool) error
 
-	return &Store{Path: rootPath, CaCertPath: caCert, PrivateKeyPath: privateKey}
+	// RemoveActive clears the active host
+	RemoveActive() error
+
+	// Save persists the config of the given host
+	Save(host *Host) error
+
+	// SetActive makes the given host the active host
+	SetActive(host *Host) error
+
+	// Unlock releases the lock taken by Lock
+	Unlock(name string) error
 }
 
-func (s *Store) Create(name string, driverName string, flags drivers.DriverOptions) (*Host, error) {
+// createHost contains the create logic shared by all store implementations.
+// hostPath is the local directory where the driver keeps its files (ssh
+// keys, disk images, certificates). The waits of the create are limited by
+// waiter, which may be nil.
+func createHost(s Store, hostPath string, name string, driverName string, caCert string, privateKey string, flags drivers.DriverOptions, waiter *wait.Waiter) (*Host, error) {
+	if err := s.Lock(name); err != nil {
+		return nil, err
+	}
+	defer s.Unlock(name)
+
 	exists, err := s.Exists(name)
 	if err != nil {
 		return nil, err
@@ -36,16 +77,34 @@ func (s *Store) Create(name string, driverName string, flags drivers.DriverOptio
 		return nil, fmt.Errorf("Machine %s already exists", name)
 	}
 
-	hostPath := filepath.Join(s.Path, name)
-
-	host, err := NewHost(name, driverName, hostPath, s.CaCertPath, s.PrivateKeyPath, flags.Bool("swarm-master"), flags.String("swarm-host"), flags.String("swarm-discovery"))
+	host, err := NewHost(name, driverName, hostPath, caCert, privateKey, flags.Bool("swarm-master"), flags.String("swarm-host"), flags.String("swarm-discovery"))
 	if err != nil {
 		return host, err
 	}
+	host.store = s
+	host.CreatedAt = time.Now()
+	host.MachineVersion = VERSION
+	host.Swarm = flags.Bool("swarm")
+	host.SwarmAddr = flags.String("swarm-addr")
+
 	if flags != nil {
 		if err := host.Driver.SetConfigFromFlags(flags); err != nil {
 			return host, err
 		}
+		if clone, ok := flags.(*cloneOptions); ok {
+			if err := clone.copyDriverConfig(host); err != nil {
+				return host, err
+			}
+		}
+		host.CreateFlags = createFlags(flags, driverName, host.Driver)
+
+		labels, err := parseLabels(flags.StringSlice("label"))
+		if err != nil {
+			return host, err
+		}
+		if err := host.SetLabels(labels); err != nil {
+			return host, err
+		}
 	}
 
 	if err := host.Driver.PreCreateCheck(); err != nil {
@@ -60,96 +119,97 @@ func (s *Store) Create(name string, driverName string, flags drivers.DriverOptio
 		return host, err
 	}
 
-	if err := host.Create(name); err != nil {
-		return host, err
-	}
-
-	if err := host.ConfigureAuth(); err != nil {
-		return host, err
-	}
-
-	if flags.Bool("swarm") {
-		log.Info("Configuring Swarm...")
-
-		discovery := flags.String("swarm-discovery")
-		master := flags.Bool("swarm-master")
-		swarmHost := flags.String("swarm-host")
-		addr := flags.String("swarm-addr")
-		if err := host.ConfigureSwarm(discovery, master, swarmHost, addr); err != nil {
-			log.Errorf("Error configuring Swarm: %s", err)
+	host.setWaiter(waiter)
+	err = host.Create(name)
+	host.recordEvent("create", err)
+	if err != nil {
+		if !host.phaseCompleted(requiredPhase) {
+			return host, rollbackHost(s, host, flags.Bool("keep-on-failure"), err)
 		}
+
+		// the host works without the remaining phases
+		log.Errorf("Error configuring machine %s: %s", name, err)
+		log.Warnf("Run provision %s to retry.", name)
 	}
 
 	return host, nil
 }
 
-func (s *Store) Remove(name string, force bool) error {
-	active, err := s.GetActive()
-	if err != nil {
-		return err
+// rollbackHost removes the resources of a host whose create failed with
+// createErr and deletes it from the store. If keep is set, or the rollback
+// fails, the host is kept and a later rm removes what is left.
+func rollbackHost(s Store, host *Host, keep bool, createErr error) error {
+	if keep {
+		log.Warnf("Keeping machine %s after the failed create. Run provision to resume the create, or rm to remove it.", host.Name)
+		return createErr
 	}
 
-	if active != nil && active.Name == name {
-		if err := s.RemoveActive(); err != nil {
-			return err
-		}
+	log.Infof("Rolling back the create of machine %s...", host.Name)
+	// the rollback waits on its own, even if the create timed out or was
+	// canceled
+	if err := host.rollbackCreate(wait.New(nil, 0)); err != nil {
+		log.Errorf("Error rolling back machine %s: %s", host.Name, err)
+		log.Warnf("Run rm to finish removing machine %s.", host.Name)
+		return createErr
 	}
 
-	host, err := s.Load(name)
-	if err != nil {
-		return err
+	if err := s.Delete(host.Name); err != nil {
+		log.Errorf("Error deleting machine %s: %s", host.Name, err)
 	}
-	return host.Remove(force)
+
+	return createErr
 }
 
-func (s *Store) List() ([]Host, error) {
-	dir, err := ioutil.ReadDir(s.Path)
-	if err != nil && !os.IsNotExist(err) {
-		return nil, err
+// loadHost decodes a persisted host config, migrating it to the current
+// version first. The original config is handed to backup before the
+// migrated one is saved. The migrated config is only saved if the host can
+// be locked, so that it cannot overwrite a change made meanwhile; otherwise
+// it is saved with the next change of the host.
+func loadHost(s Store, name string, hostPath string, data []byte, backup func(version int, data []byte) error) (*Host, error) {
+	migrated, version, ok, err := migrateHostConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("error loading host %q: %s", name, err)
 	}
 
-	hosts := []Host{}
+	host := &Host{Name: name, storePath: hostPath, store: s}
+	if err := host.decodeConfig(migrated); err != nil {
+		return nil, err
+	}
 
-	for _, file := range dir {
-		// don't load hidden dirs; used for configs
-		if file.IsDir() && strings.Index(file.Name(), ".") != 0 {
-			host, err := s.Load(file.Name())
-			if err != nil {
-				log.Errorf("error loading host %q: %s", file.Name(), err)
-				continue
-			}
-			hosts = append(hosts, *host)
+	if ok {
+		log.Debugf("migrated config of host %q from version %d", name, version)
+		if err := backup(version, data); err != nil {
+			return nil, fmt.Errorf("error backing up config of host %q: %s", name, err)
+		}
+		if err := s.Lock(name); err != nil {
+			log.Debugf("not saving the migrated config of host %q: %s", name, err)
+			return host, nil
+		}
+		err := s.Save(host)
+		s.Unlock(name)
+		if err != nil {
+			return nil, err
 		}
 	}
-	return hosts, nil
-}
 
-func (s *Store) Exists(name string) (bool, error) {
-	_, err := os.Stat(filepath.Join(s.Path, name))
-	if os.IsNotExist(err) {
-		return false, nil
-	} else if err == nil {
-		return true, nil
-	}
-	return false, err
+	return host, nil
 }
 
-func (s *Store) Load(name string) (*Host, error) {
-	hostPath := filepath.Join(s.Path, name)
-	return LoadHost(name, hostPath)
-}
+// clearActive removes the active marker if it points at the named host
+func clearActive(s Store, name string) error {
+	active, err := s.GetActive()
+	if err != nil {
+		return err
+	}
 
-func (s *Store) GetActive() (*Host, error) {
-	hostName, err := ioutil.ReadFile(s.activePath())
-	if os.IsNotExist(err) {
-		return nil, nil
-	} else if err != nil {
-		return nil, err
+	if active != nil && active.Name == name {
+		return s.RemoveActive()
 	}
-	return s.Load(string(hostName))
+	return nil
 }
 
-func (s *Store) IsActive(host *Host) (bool, error) {
+// isActive compares the given host against the active host of the store
+func isActive(s Store, host *Host) (bool, error) {
 	active, err := s.GetActive()
 	if err != nil {
 		return false, err
@@ -160,19 +220,25 @@ func (s *Store) IsActive(host *Host) (bool, error) {
 	return active.Name == host.Name, nil
 }
 
-func (s *Store) SetActive(host *Host) error {
-	if err := os.MkdirAll(filepath.Dir(s.activePath()), 0700); err != nil {
-		return err
-	}
-	return ioutil.WriteFile(s.activePath(), []byte(host.Name), 0600)
+// hostLock returns the advisory lock for the named host. Locks are kept in
+// a hidden directory so that they do not show up as hosts.
+func hostLock(rootPath string, name string) *utils.FileLock {
+	return utils.NewFileLock(filepath.Join(rootPath, ".locks", name))
 }
 
-func (s *Store) RemoveActive() error {
-	return os.Remove(s.activePath())
+func lockHost(rootPath string, name string) error {
+	if err := hostLock(rootPath, name).TryLock(); err != nil {
+		if locked, ok := err.(utils.ErrLocked); ok {
+			if locked.Pid == os.Getpid() {
+				return fmt.Errorf("machine %s is already locked by this process", name)
+			}
+			return fmt.Errorf("machine %s is locked by pid %d", name, locked.Pid)
+		}
+		return err
+	}
+	return nil
 }
 
-// activePath returns the path to the file that stores the name of the
-// active host
-func (s *Store) activePath() string {
-	return filepath.Join(s.Path, ".active")
+func unlockHost(rootPath string, name string) error {
+	return hostLock(rootPath, name).Unlock()
 }
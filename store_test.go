@@ -1,235 +1,190 @@
 package main
 
 import (
+	"errors"
 	"os"
-	"path/filepath"
+	"reflect"
 	"testing"
 
-	_ "github.com/docker/machine/drivers/none"
-)
-
-const (
-	TestStoreDir = ".store-test"
+	"github.com/codegangsta/cli"
+	"github.com/docker/machine/drivers"
 )
 
 var (
-	TestMachineDir = filepath.Join(TestStoreDir, "machine", "machines")
+	rolledBack   []string
+	failRollback = map[string]bool{}
 )
 
-type DriverOptionsMock struct {
-	Data map[string]interface{}
+// rollbackDriver records two resources and then fails to create the machine
+type rollbackDriver struct {
+	FakeDriver
+	recordStep drivers.StepRecorder
 }
 
-func (d DriverOptionsMock) String(key string) string {
-	return d.Data[key].(string)
+func (d *rollbackDriver) DriverName() string {
+	return "rollbackdriver"
 }
 
-func (d DriverOptionsMock) Int(key string) int {
-	return d.Data[key].(int)
+func (d *rollbackDriver) SetStepRecorder(record drivers.StepRecorder) {
+	d.recordStep = record
 }
 
-func (d DriverOptionsMock) Bool(key string) bool {
-	return d.Data[key].(bool)
+func (d *rollbackDriver) Rollback(step drivers.CreateStep) error {
+	if failRollback[step.Resource] {
+		return errors.New("rollback failed")
+	}
+	rolledBack = append(rolledBack, step.Resource+" "+step.ID)
+	return nil
 }
 
-func clearHosts() error {
-	return os.RemoveAll(TestStoreDir)
+func (d *rollbackDriver) Create() error {
+	if err := drivers.RecordStep(d.recordStep, "disk", "1"); err != nil {
+		return err
+	}
+	if err := drivers.RecordStep(d.recordStep, "vm", "2"); err != nil {
+		return err
+	}
+	return errors.New("create failed")
 }
 
-func getDefaultTestDriverFlags() *DriverOptionsMock {
-	return &DriverOptionsMock{
-		Data: map[string]interface{}{
-			"name":            "test",
-			"url":             "unix:///var/run/docker.sock",
-			"swarm":           false,
-			"swarm-host":      "",
-			"swarm-master":    false,
-			"swarm-discovery": "",
-		},
-	}
+// failingDriver fails to create the machine without recording resources
+type failingDriver struct {
+	FakeDriver
 }
 
-func TestStoreCreate(t *testing.T) {
-	if err := clearHosts(); err != nil {
-		t.Fatal(err)
-	}
+func (d *failingDriver) DriverName() string {
+	return "failingdriver"
+}
 
-	flags := getDefaultTestDriverFlags()
+func (d *failingDriver) Create() error {
+	return errors.New("create failed")
+}
 
-	store := NewStore(TestStoreDir, "", "")
+func (d *failingDriver) Remove() error {
+	rolledBack = append(rolledBack, "machine")
+	return nil
+}
 
-	host, err := store.Create("test", "none", flags)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if host.Name != "test" {
-		t.Fatal("Host name is incorrect")
-	}
-	path := filepath.Join(TestStoreDir, "test")
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		t.Fatalf("Host path doesn't exist: %s", path)
-	}
+func init() {
+	drivers.Register("rollbackdriver", &drivers.RegisteredDriver{
+		New: func(machineName string, storePath string, caCert string, privateKey string) (drivers.Driver, error) {
+			return &rollbackDriver{}, nil
+		},
+		GetCreateFlags: func() []cli.Flag { return nil },
+	})
+	drivers.Register("failingdriver", &drivers.RegisteredDriver{
+		New: func(machineName string, storePath string, caCert string, privateKey string) (drivers.Driver, error) {
+			return &failingDriver{}, nil
+		},
+		GetCreateFlags: func() []cli.Flag { return nil },
+	})
 }
 
-func TestStoreRemove(t *testing.T) {
-	if err := clearHosts(); err != nil {
-		t.Fatal(err)
-	}
+func createFailingHost(t *testing.T, store Store, driverName string, keep bool) {
+	rolledBack = nil
 
-	flags := getDefaultTestDriverFlags()
+	flags := getTestDriverFlags()
+	flags.Data["keep-on-failure"] = keep
 
-	store := NewStore(TestStoreDir, "", "")
-	_, err := store.Create("test", "none", flags)
-	if err != nil {
-		t.Fatal(err)
-	}
-	path := filepath.Join(TestStoreDir, "test")
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		t.Fatalf("Host path doesn't exist: %s", path)
-	}
-	err = store.Remove("test", false)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if _, err := os.Stat(path); err == nil {
-		t.Fatalf("Host path still exists after remove: %s", path)
+	if _, err := store.Create(hostTestName, driverName, flags, nil); err == nil || err.Error() != "create failed" {
+		t.Fatalf("expected create to fail; received %v", err)
 	}
 }
 
-func TestStoreList(t *testing.T) {
-	if err := clearHosts(); err != nil {
+func TestCreateRollback(t *testing.T) {
+	store, err := getTestStore()
+	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.RemoveAll(store.Path)
 
-	flags := getDefaultTestDriverFlags()
+	createFailingHost(t, store, "rollbackdriver", false)
 
-	store := NewStore(TestStoreDir, "", "")
-	_, err := store.Create("test", "none", flags)
-	if err != nil {
-		t.Fatal(err)
+	if expected := []string{"vm 2", "disk 1"}; !reflect.DeepEqual(rolledBack, expected) {
+		t.Fatalf("expected %v to be rolled back; received %v", expected, rolledBack)
 	}
-	hosts, err := store.List()
-	if len(hosts) != 1 {
-		t.Fatalf("List returned %d items", len(hosts))
+	if exists, _ := store.Exists(hostTestName); exists {
+		t.Fatal("expected the machine to be deleted")
 	}
-	if hosts[0].Name != "test" {
-		t.Fatalf("hosts[0] name is incorrect, got: %s", hosts[0].Name)
-	}
-}
 
-func TestStoreExists(t *testing.T) {
-	if err := clearHosts(); err != nil {
-		t.Fatal(err)
-	}
+	createFailingHost(t, store, "failingdriver", false)
 
-	flags := getDefaultTestDriverFlags()
-
-	store := NewStore(TestStoreDir, "", "")
-	exists, err := store.Exists("test")
-	if exists {
-		t.Fatal("Exists returned true when it should have been false")
+	if expected := []string{"machine"}; !reflect.DeepEqual(rolledBack, expected) {
+		t.Fatalf("expected %v to be rolled back; received %v", expected, rolledBack)
 	}
-	_, err = store.Create("test", "none", flags)
-	if err != nil {
-		t.Fatal(err)
-	}
-	exists, err = store.Exists("test")
-	if err != nil {
-		t.Fatal(err)
-	}
-	if !exists {
-		t.Fatal("Exists returned false when it should have been true")
+	if exists, _ := store.Exists(hostTestName); exists {
+		t.Fatal("expected the machine to be deleted")
 	}
 }
 
-func TestStoreLoad(t *testing.T) {
-	if err := clearHosts(); err != nil {
+func TestCreateKeepOnFailure(t *testing.T) {
+	store, err := getTestStore()
+	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.RemoveAll(store.Path)
 
-	expectedURL := "unix:///foo/baz"
-	flags := getDefaultTestDriverFlags()
-	flags.Data["url"] = expectedURL
+	createFailingHost(t, store, "rollbackdriver", true)
 
-	store := NewStore(TestStoreDir, "", "")
-	_, err := store.Create("test", "none", flags)
-	if err != nil {
-		t.Fatal(err)
+	if len(rolledBack) != 0 {
+		t.Fatalf("expected nothing to be rolled back; received %v", rolledBack)
 	}
 
-	store = NewStore(TestStoreDir, "", "")
-	host, err := store.Load("test")
-	if host.Name != "test" {
-		t.Fatal("Host name is incorrect")
-	}
-	actualURL, err := host.GetURL()
+	host, err := store.Load(hostTestName)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if actualURL != expectedURL {
-		t.Fatalf("GetURL is not %q, got %q", expectedURL, expectedURL)
+	expected := []drivers.CreateStep{{Resource: "disk", ID: "1"}, {Resource: "vm", ID: "2"}}
+	if !reflect.DeepEqual(host.CreateSteps, expected) {
+		t.Fatalf("expected steps %v; received %v", expected, host.CreateSteps)
 	}
-}
 
-func TestStoreGetSetActive(t *testing.T) {
-	if err := clearHosts(); err != nil {
+	if err := store.Remove(hostTestName, false); err != nil {
 		t.Fatal(err)
 	}
+	if expected := []string{"vm 2", "disk 1"}; !reflect.DeepEqual(rolledBack, expected) {
+		t.Fatalf("expected %v to be rolled back; received %v", expected, rolledBack)
+	}
+	if exists, _ := store.Exists(hostTestName); exists {
+		t.Fatal("expected the machine to be removed")
+	}
+}
 
-	flags := getDefaultTestDriverFlags()
-
-	//store := NewStore(TestStoreDir, "", "")
+func TestCreateInterruptedRollback(t *testing.T) {
 	store, err := getTestStore()
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.RemoveAll(store.Path)
 
-	// No hosts set
-	host, err := store.GetActive()
-	if err != nil {
-		t.Fatal(err)
-	}
+	failRollback["disk"] = true
+	defer delete(failRollback, "disk")
 
-	if host != nil {
-		t.Fatalf("GetActive: Active host should not exist")
-	}
+	createFailingHost(t, store, "rollbackdriver", false)
 
-	// Set normal host
-	originalHost, err := store.Create("test", "none", flags)
+	host, err := store.Load(hostTestName)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	if err := store.SetActive(originalHost); err != nil {
-		t.Fatal(err)
+	expected := []drivers.CreateStep{{Resource: "disk", ID: "1"}}
+	if !reflect.DeepEqual(host.CreateSteps, expected) {
+		t.Fatalf("expected steps %v; received %v", expected, host.CreateSteps)
 	}
 
-	host, err = store.GetActive()
-	if err != nil {
-		t.Fatal(err)
-	}
-	if host.Name != "test" {
-		t.Fatalf("Active host is not 'test', got %s", host.Name)
-	}
-	isActive, err := store.IsActive(host)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if isActive != true {
-		t.Fatal("IsActive: Active host is not test")
+	if err := store.Remove(hostTestName, false); err == nil {
+		t.Fatal("expected remove to fail while the rollback fails")
 	}
 
-	// remove active host altogether
-	if err := store.RemoveActive(); err != nil {
-		t.Fatal(err)
-	}
+	delete(failRollback, "disk")
+	rolledBack = nil
 
-	host, err = store.GetActive()
-	if err != nil {
+	if err := store.Remove(hostTestName, false); err != nil {
 		t.Fatal(err)
 	}
-
-	if host != nil {
-		t.Fatalf("Active host %s is not nil", host.Name)
+	if expected := []string{"disk 1"}; !reflect.DeepEqual(rolledBack, expected) {
+		t.Fatalf("expected %v to be rolled back; received %v", expected, rolledBack)
+	}
+	if exists, _ := store.Exists(hostTestName); exists {
+		t.Fatal("expected the machine to be removed")
 	}
 }
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/codegangsta/cli"
+	"github.com/docker/machine/ssh"
+	"github.com/docker/machine/utils"
+)
+
+// tunnelLocalHost is the address the Docker and Swarm ports are forwarded to.
+// Server certs are issued for it as well as for the machine IP.
+const tunnelLocalHost = "127.0.0.1"
+
+// tunnelInfo records the local ends of a running tunnel --docker, so that
+// env --tunnel can point Docker at them
+type tunnelInfo struct {
+	DockerHost string
+	SwarmHost  string `json:",omitempty"`
+}
+
+func (h *Host) tunnelInfoPath() string {
+	return filepath.Join(h.storePath, "tunnel.json")
+}
+
+func (h *Host) saveTunnelInfo(info *tunnelInfo) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(h.tunnelInfoPath(), data, 0600)
+}
+
+func (h *Host) removeTunnelInfo() {
+	if err := os.Remove(h.tunnelInfoPath()); err != nil && !os.IsNotExist(err) {
+		log.Debugf("error removing tunnel info of host %s: %s", h.Name, err)
+	}
+}
+
+// loadTunnelInfo returns the local ends of the running tunnel to the host.
+// A tunnel which was killed without cleaning up is detected by its Docker
+// port no longer accepting connections.
+func (h *Host) loadTunnelInfo() (*tunnelInfo, error) {
+	notRunning := fmt.Errorf("No tunnel to %s is running; start one with: tunnel --docker %s", h.Name, h.Name)
+
+	data, err := ioutil.ReadFile(h.tunnelInfoPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, notRunning
+		}
+		return nil, err
+	}
+
+	var info tunnelInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("error reading tunnel info of host %s: %s", h.Name, err)
+	}
+
+	u, err := url.Parse(info.DockerHost)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tunnel info of host %s: %s", h.Name, err)
+	}
+	conn, err := net.DialTimeout("tcp", u.Host, time.Second)
+	if err != nil {
+		return nil, notRunning
+	}
+	conn.Close()
+
+	if err := h.checkTunnelCert(); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// checkTunnelCert checks that the server cert of the host is valid for the
+// local end of the tunnel, which certs generated before tunnel existed are not
+func (h *Host) checkTunnelCert() error {
+	data, err := ioutil.ReadFile(filepath.Join(h.storePath, "server.pem"))
+	if err != nil {
+		return fmt.Errorf("error reading server cert of host %s: %s", h.Name, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("error reading server cert of host %s: no PEM data found", h.Name)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("error reading server cert of host %s: %s", h.Name, err)
+	}
+
+	if err := cert.VerifyHostname(tunnelLocalHost); err != nil {
+		return fmt.Errorf("The server cert of %s is not valid for %s; regenerate it with: provision --from-phase auth %s", h.Name, tunnelLocalHost, h.Name)
+	}
+	return nil
+}
+
+// urlPort returns the port of a URL such as tcp://0.0.0.0:3376
+func urlPort(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+	_, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return "", fmt.Errorf("no port in %q", rawurl)
+	}
+	return port, nil
+}
+
+// forwardDocker forwards a local port to the Docker port of the host, and
+// one to the Swarm port if the host is a Swarm master. A port of 0 picks a
+// free port.
+func (h *Host) forwardDocker(tunnel *ssh.Tunnel, port int) (*tunnelInfo, error) {
+	dockerURL, err := h.GetURL()
+	if err != nil {
+		return nil, err
+	}
+	dockerPort, err := urlPort(dockerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := tunnel.Forward(ssh.Forward{
+		Local:  net.JoinHostPort(tunnelLocalHost, strconv.Itoa(port)),
+		Remote: net.JoinHostPort("localhost", dockerPort),
+	})
+	if err != nil {
+		return nil, err
+	}
+	info := &tunnelInfo{DockerHost: "tcp://" + local.String()}
+
+	if h.SwarmMaster {
+		swarmPort, err := urlPort(h.SwarmHost)
+		if err != nil {
+			return nil, err
+		}
+		local, err := tunnel.Forward(ssh.Forward{
+			Local:  net.JoinHostPort(tunnelLocalHost, "0"),
+			Remote: net.JoinHostPort("localhost", swarmPort),
+		})
+		if err != nil {
+			return nil, err
+		}
+		info.SwarmHost = "tcp://" + local.String()
+	}
+
+	return info, nil
+}
+
+func cmdTunnel(c *cli.Context) {
+	specs := c.StringSlice("L")
+	socks := c.String("D")
+	docker := c.Bool("docker")
+
+	if len(specs) == 0 && socks == "" && !docker {
+		cli.ShowCommandHelp(c, "tunnel")
+		log.Fatal("You must specify forwards with -L, a SOCKS proxy with -D, or --docker")
+	}
+
+	forwards := []ssh.Forward{}
+	for _, spec := range specs {
+		forward, err := ssh.ParseForward(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		forwards = append(forwards, forward)
+	}
+
+	host := getHost(c)
+	if docker {
+		if err := host.checkTunnelCert(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	addr, err := host.sshAddress()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tunnel, err := ssh.NewTunnel(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, forward := range forwards {
+		local, err := tunnel.Forward(forward)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("Forwarding %s to %s on %s", local, forward.Remote, host.Name)
+	}
+
+	if socks != "" {
+		local, err := tunnel.SOCKS(socks)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("SOCKS proxy to %s listening on %s", host.Name, local)
+	}
+
+	if docker {
+		info, err := host.forwardDocker(tunnel, c.Int("docker-port"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := host.saveTunnelInfo(info); err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("Forwarding %s to the Docker port of %s", info.DockerHost, host.Name)
+		if info.SwarmHost != "" {
+			log.Infof("Forwarding %s to the Swarm port of %s", info.SwarmHost, host.Name)
+		}
+		log.Infof("To point your Docker client at the tunnel, run this in your shell: $(%s env --tunnel %s)", c.App.Name, host.Name)
+	}
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		tunnel.Close()
+	}()
+
+	err = tunnel.Serve()
+	if docker {
+		host.removeTunnelInfo()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/utils"
+)
+
+func TestURLPort(t *testing.T) {
+	port, err := urlPort("tcp://0.0.0.0:3376")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != "3376" {
+		t.Fatalf("expected port 3376; received %s", port)
+	}
+
+	if _, err := urlPort("tcp://10.0.0.5"); err == nil {
+		t.Fatal("expected an error for a URL without a port")
+	}
+}
+
+// generateTestServerCert generates the server cert of the host for hosts
+func generateTestServerCert(t *testing.T, host *Host, hosts []string) {
+	caCertPath := filepath.Join(host.storePath, "ca.pem")
+	caKeyPath := filepath.Join(host.storePath, "ca-key.pem")
+	if err := utils.GenerateCACertificate(caCertPath, caKeyPath, "test", 1024); err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(host.storePath, "server.pem")
+	keyPath := filepath.Join(host.storePath, "server-key.pem")
+	if err := utils.GenerateCert(hosts, certPath, keyPath, caCertPath, caKeyPath, "test", 1024); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTunnelInfo(t *testing.T) {
+	host := getTestCacheHost(t)
+	defer os.RemoveAll(host.storePath)
+	generateTestServerCert(t, host, []string{"10.0.0.5", tunnelLocalHost, "localhost"})
+
+	if _, err := host.loadTunnelInfo(); err == nil {
+		t.Fatal("expected an error without a tunnel")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := &tunnelInfo{
+		DockerHost: "tcp://" + listener.Addr().String(),
+		SwarmHost:  "tcp://127.0.0.1:1",
+	}
+	if err := host.saveTunnelInfo(saved); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := host.loadTunnelInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *info != *saved {
+		t.Fatalf("expected %+v; received %+v", saved, info)
+	}
+
+	// a tunnel which was killed leaves its info behind
+	listener.Close()
+	if _, err := host.loadTunnelInfo(); err == nil {
+		t.Fatal("expected an error after the tunnel stopped")
+	}
+
+	host.removeTunnelInfo()
+	if _, err := os.Stat(host.tunnelInfoPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected the tunnel info to be removed; received %v", err)
+	}
+}
+
+func TestTunnelInfoCert(t *testing.T) {
+	host := getTestCacheHost(t)
+	defer os.RemoveAll(host.storePath)
+	// a cert generated before tunnel existed
+	generateTestServerCert(t, host, []string{"10.0.0.5"})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if err := host.saveTunnelInfo(&tunnelInfo{DockerHost: "tcp://" + listener.Addr().String()}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = host.loadTunnelInfo()
+	if err == nil {
+		t.Fatal("expected an error for a cert which is not valid for the tunnel")
+	}
+	if !strings.Contains(err.Error(), "provision --from-phase auth") {
+		t.Fatalf("expected the error to tell how to regenerate the cert; received %s", err)
+	}
+}
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	saltSize         = 16
+	keySize          = 32
+	kdfIterations    = 100000
+	encryptedVersion = "machine-enc:1:"
+)
+
+var ErrDecrypt = errors.New("unable to decrypt data; wrong passphrase?")
+
+// Deriving keys is deliberately slow, so a process uses a single salt per
+// passphrase for everything it encrypts (nonces are still random) and
+// caches the keys it derived.
+var (
+	encryptSalts = map[string][]byte{}
+	keyCache     = map[string][]byte{}
+	cacheLock    sync.Mutex
+)
+
+// Encrypt encrypts data with AES-256-GCM using a key derived from the
+// passphrase. The result contains everything needed to decrypt it apart
+// from the passphrase.
+func Encrypt(data []byte, passphrase string) ([]byte, error) {
+	cacheLock.Lock()
+	salt, ok := encryptSalts[passphrase]
+	if !ok {
+		salt = make([]byte, saltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			cacheLock.Unlock()
+			return nil, err
+		}
+		encryptSalts[passphrase] = salt
+	}
+	cacheLock.Unlock()
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := []byte(encryptedVersion)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, nil), nil
+}
+
+// Decrypt reverses Encrypt
+func Decrypt(data []byte, passphrase string) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, errors.New("data is not encrypted")
+	}
+	data = data[len(encryptedVersion):]
+
+	if len(data) < saltSize {
+		return nil, ErrDecrypt
+	}
+	salt := data[:saltSize]
+	data = data[saltSize:]
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce := data[:gcm.NonceSize()]
+
+	plain, err := gcm.Open(nil, nonce, data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// IsEncrypted returns whether data was produced by Encrypt
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedVersion))
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	cacheLock.Lock()
+	cacheKey := string(salt) + "\x00" + passphrase
+	key, ok := keyCache[cacheKey]
+	if !ok {
+		key = deriveKey([]byte(passphrase), salt, kdfIterations, keySize)
+		keyCache[cacheKey] = key
+	}
+	cacheLock.Unlock()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey implements PBKDF2 (RFC 2898) with HMAC-SHA256
+func deriveKey(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:4])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for x := range u {
+				t[x] ^= u[x]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
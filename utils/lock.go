@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrLocked is returned when a lock is held by another process
+type ErrLocked struct {
+	Path string
+	Pid  int
+}
+
+func (e ErrLocked) Error() string {
+	if e.Pid == os.Getpid() {
+		return fmt.Sprintf("%s is already locked by this process; the lock is not reentrant", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by pid %d", e.Path, e.Pid)
+}
+
+// FileLock is an advisory lock backed by a file containing the pid of the
+// process holding it. Locks left behind by processes that no longer exist
+// are taken over. The lock is not reentrant: taking a lock the process
+// already holds fails with ErrLocked, so code running while a lock is held
+// must not take it again.
+type FileLock struct {
+	Path string
+}
+
+func NewFileLock(path string) *FileLock {
+	return &FileLock{Path: path}
+}
+
+// TryLock takes the lock or returns ErrLocked if another process holds it
+func (l *FileLock) TryLock() error {
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0700); err != nil {
+		return err
+	}
+
+	err := createLockFile(l.Path)
+	if !os.IsExist(err) {
+		return err
+	}
+
+	pid, err := l.owner()
+	if err != nil {
+		return err
+	}
+	if pid == os.Getpid() || ProcessExists(pid) {
+		return ErrLocked{Path: l.Path, Pid: pid}
+	}
+	return l.takeOver(pid)
+}
+
+// takeOver replaces a lock left behind by the process with the stale pid.
+// Processes taking over the lock serialize on a second lock file, and the
+// owner is checked again once it is held, so that a process which read the
+// same stale pid cannot remove the lock another process has just taken.
+func (l *FileLock) takeOver(stale int) error {
+	guard := &FileLock{Path: l.Path + ".takeover"}
+	if err := createLockFile(guard.Path); err != nil {
+		if !os.IsExist(err) {
+			return err
+		}
+		// a process which died while taking over leaves its guard behind
+		pid, err := guard.owner()
+		if err != nil {
+			return err
+		}
+		if pid == os.Getpid() || ProcessExists(pid) {
+			return ErrLocked{Path: l.Path, Pid: stale}
+		}
+		if err := os.Remove(guard.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := createLockFile(guard.Path); err != nil {
+			if os.IsExist(err) {
+				return ErrLocked{Path: l.Path, Pid: stale}
+			}
+			return err
+		}
+	}
+	defer guard.Unlock()
+
+	pid, err := l.owner()
+	if err != nil {
+		return err
+	}
+	if pid != stale && pid != 0 {
+		return ErrLocked{Path: l.Path, Pid: pid}
+	}
+
+	if err := os.Remove(l.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := createLockFile(l.Path); err != nil {
+		if os.IsExist(err) {
+			pid, _ := l.owner()
+			return ErrLocked{Path: l.Path, Pid: pid}
+		}
+		return err
+	}
+	return nil
+}
+
+// createLockFile creates the lock file with the pid of this process. It
+// fails with an error satisfying os.IsExist if the file exists.
+func createLockFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d", os.Getpid())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// Unlock releases the lock. It fails without removing the lock file if the
+// lock is held by another process, e.g. after it was taken over.
+func (l *FileLock) Unlock() error {
+	pid, err := l.owner()
+	if err != nil {
+		return err
+	}
+	if pid != os.Getpid() {
+		if _, err := os.Stat(l.Path); os.IsNotExist(err) {
+			return nil
+		}
+		return ErrLocked{Path: l.Path, Pid: pid}
+	}
+
+	if err := os.Remove(l.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// owner returns the pid stored in the lock file, or 0 if it is unreadable
+// (e.g. a process died between creating and writing the file)
+func (l *FileLock) owner() (int, error) {
+	data, err := ioutil.ReadFile(l.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, nil
+	}
+	return pid, nil
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileLock(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	lock := NewFileLock(filepath.Join(tmpDir, "locks", "test"))
+
+	if err := lock.TryLock(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewFileLock(lock.Path).TryLock()
+	locked, ok := err.(ErrLocked)
+	if !ok {
+		t.Fatalf("expected ErrLocked; received %v", err)
+	}
+	if locked.Pid != os.Getpid() {
+		t.Fatalf("expected lock owner %d; received %d", os.Getpid(), locked.Pid)
+	}
+
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lock.TryLock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileLockNotReentrant(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	lock := NewFileLock(filepath.Join(tmpDir, "test"))
+	if err := lock.TryLock(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = lock.TryLock()
+	locked, ok := err.(ErrLocked)
+	if !ok {
+		t.Fatalf("expected ErrLocked when locking again; received %v", err)
+	}
+	if locked.Pid != os.Getpid() || !strings.Contains(err.Error(), "not reentrant") {
+		t.Fatalf("expected the error to tell that the lock is not reentrant; received %q", err)
+	}
+
+	// the failed attempt leaves the lock held, and one unlock releases it
+	if _, err := os.Stat(lock.Path); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(lock.Path); !os.IsNotExist(err) {
+		t.Fatalf("expected the lock to be released; received %v", err)
+	}
+}
+
+func TestFileLockStale(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	lockPath := filepath.Join(tmpDir, "test")
+
+	// a pid which cannot belong to a running process
+	if err := ioutil.WriteFile(lockPath, []byte(fmt.Sprintf("%d", 1<<30)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	lock := NewFileLock(lockPath)
+	if err := lock.TryLock(); err != nil {
+		t.Fatalf("expected stale lock to be taken over; received %v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileLockUnlockOtherOwner(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	lockPath := filepath.Join(tmpDir, "test")
+	if err := ioutil.WriteFile(lockPath, []byte(fmt.Sprintf("%d", 1<<30)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := NewFileLock(lockPath).Unlock().(ErrLocked); !ok {
+		t.Fatal("expected ErrLocked when unlocking the lock of another process")
+	}
+	if _, err := os.Stat(lockPath); err != nil {
+		t.Fatalf("expected the lock of another process to be kept; received %v", err)
+	}
+}
+
+func TestFileLockStaleTakeOverOnce(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	lockPath := filepath.Join(tmpDir, "test")
+	stale := 1 << 30
+	if err := ioutil.WriteFile(lockPath, []byte(fmt.Sprintf("%d", stale)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	lock := NewFileLock(lockPath)
+	if err := lock.takeOver(stale); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second process which read the same stale pid must not take the
+	// lock from the first
+	if _, ok := NewFileLock(lockPath).takeOver(stale).(ErrLocked); !ok {
+		t.Fatal("expected ErrLocked when the stale lock was already taken over")
+	}
+	if _, err := os.Stat(lockPath + ".takeover"); !os.IsNotExist(err) {
+		t.Fatalf("expected the takeover guard to be removed; received %v", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import "syscall"
+
+// ProcessExists returns whether a process with the given pid is running
+func ProcessExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
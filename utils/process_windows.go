@@ -0,0 +1,18 @@
+package utils
+
+import "syscall"
+
+const processQueryLimitedInformation = 0x1000
+
+// ProcessExists returns whether a process with the given pid is running
+func ProcessExists(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	syscall.CloseHandle(h)
+	return true
+}
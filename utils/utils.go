@@ -2,6 +2,7 @@ package utils
 
 import (
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -79,3 +80,37 @@ func CopyFile(src, dst string) error {
 
 	return nil
 }
+
+// WriteFileAtomic writes data to a temporary file next to filename and
+// renames it into place, so readers never see a partially written file.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+
+	err = f.Chmod(perm)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, filename)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return nil
+}
@@ -137,3 +137,37 @@ func TestGetUsername(t *testing.T) {
 		t.Fatalf("expected username %s; received %s", currentUser, username)
 	}
 }
+
+func TestWriteFileAtomic(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "machine-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filename := filepath.Join(tmpDir, "config.json")
+
+	if err := ioutil.WriteFile(filename, []byte("old contents"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFileAtomic(filename, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Fatalf("expected contents %q; received %q", "new", string(data))
+	}
+
+	files, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected temporary file to be renamed; found %d files", len(files))
+	}
+}
@@ -0,0 +1,246 @@
+// Package wait waits for machines to reach a condition, such as a port
+// accepting connections, backing off exponentially between the checks.
+// The waits of an operation, such as the create of a machine, are made with
+// a Waiter, which gives up at the deadline of the operation or when it is
+// canceled, e.g. on Ctrl-C, so that a machine which never boots does not hang
+// machine. The package functions wait with the Waiter of the process.
+package wait
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/cenkalti/backoff"
+)
+
+const (
+	// DefaultTimeout is how long a wait checks its condition if it is not
+	// given a timeout
+	DefaultTimeout = 10 * time.Minute
+
+	initialInterval = 500 * time.Millisecond
+	maxInterval     = 10 * time.Second
+)
+
+// ErrCanceled is returned by waits after Cancel was called
+var ErrCanceled = errors.New("canceled")
+
+// TimeoutError is returned by waits which timed out
+type TimeoutError struct {
+	What    string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Timeout == 0 {
+		return fmt.Sprintf("timed out waiting for %s", e.What)
+	}
+	return fmt.Sprintf("timed out after %s waiting for %s", e.Timeout, e.What)
+}
+
+// Waiter limits the waits of an operation
+type Waiter struct {
+	parent   *Waiter
+	deadline time.Time
+
+	mu       sync.Mutex
+	canceled chan struct{}
+}
+
+// std is the Waiter of the process, which waits without a deadline
+var std = New(nil, 0)
+
+// New returns a Waiter whose waits time out after timeout, or at the deadline
+// of parent, and are canceled when parent is. A nil parent limits nothing, a
+// timeout of 0 means no deadline of its own.
+func New(parent *Waiter, timeout time.Duration) *Waiter {
+	w := &Waiter{parent: parent, canceled: make(chan struct{})}
+	if timeout > 0 {
+		w.deadline = time.Now().Add(timeout)
+	}
+	return w
+}
+
+// Cancel makes all running and later waits of the Waiter and the Waiters
+// derived from it return ErrCanceled
+func (w *Waiter) Cancel() {
+	if w == nil {
+		w = std
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-w.canceled:
+	default:
+		close(w.canceled)
+	}
+}
+
+// getDeadline returns the earliest deadline of the Waiter and its parents
+func (w *Waiter) getDeadline() time.Time {
+	deadline := time.Time{}
+	for ; w != nil; w = w.parent {
+		if !w.deadline.IsZero() && (deadline.IsZero() || w.deadline.Before(deadline)) {
+			deadline = w.deadline
+		}
+	}
+	return deadline
+}
+
+// isCanceled returns whether the Waiter or one of its parents is canceled
+func (w *Waiter) isCanceled() bool {
+	for ; w != nil; w = w.parent {
+		select {
+		case <-w.canceled:
+			return true
+		default:
+		}
+	}
+	return false
+}
+
+// done returns a channel which is closed when the Waiter or one of its
+// parents is canceled. The returned function must be called when the wait
+// is over.
+func (w *Waiter) done() (<-chan struct{}, func()) {
+	if w.parent == nil {
+		return w.canceled, func() {}
+	}
+
+	parentDone, stopParent := w.parent.done()
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		defer stopParent()
+		select {
+		case <-w.canceled:
+			close(done)
+		case <-parentDone:
+			close(done)
+		case <-stop:
+		}
+	}()
+	return done, func() { close(stop) }
+}
+
+// Cancel cancels the waits of the process, see Waiter.Cancel
+func Cancel() {
+	std.Cancel()
+}
+
+// Until waits with the Waiter of the process, see Waiter.Until
+func Until(what string, timeout time.Duration, check func() (bool, error)) error {
+	return std.Until(what, timeout, check)
+}
+
+// Sleep sleeps with the Waiter of the process, see Waiter.Sleep
+func Sleep(d time.Duration) error {
+	return std.Sleep(d)
+}
+
+// ForTCP waits with the Waiter of the process, see Waiter.ForTCP
+func ForTCP(addr string, timeout time.Duration) error {
+	return std.ForTCP(addr, timeout)
+}
+
+// Until calls check until it returns true, backing off exponentially between
+// the calls. It returns the error of check if there is one, a *TimeoutError
+// after timeout or at the deadline, or ErrCanceled. A timeout of 0 means
+// DefaultTimeout. Checks which can fail temporarily should log the failure
+// and return false. A nil Waiter waits as the package functions do.
+func (w *Waiter) Until(what string, timeout time.Duration, check func() (bool, error)) error {
+	if w == nil {
+		w = std
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = initialInterval
+	b.MaxInterval = maxInterval
+	b.MaxElapsedTime = 0
+	b.Reset()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var deadlineC <-chan time.Time
+	if d := w.getDeadline(); !d.IsZero() {
+		deadlineTimer := time.NewTimer(d.Sub(time.Now()))
+		defer deadlineTimer.Stop()
+		deadlineC = deadlineTimer.C
+	}
+
+	cancel, stop := w.done()
+	defer stop()
+	for {
+		if w.isCanceled() {
+			return ErrCanceled
+		}
+
+		done, err := check()
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		interval := b.NextBackOff()
+		log.Debugf("waiting %s for %s", interval, what)
+
+		select {
+		case <-time.After(interval):
+		case <-timer.C:
+			return &TimeoutError{What: what, Timeout: timeout}
+		case <-deadlineC:
+			return &TimeoutError{What: what}
+		case <-cancel:
+			return ErrCanceled
+		}
+	}
+}
+
+// Sleep sleeps for d, returning ErrCanceled if the Waiter is canceled
+// meanwhile
+func (w *Waiter) Sleep(d time.Duration) error {
+	if w == nil {
+		w = std
+	}
+	if w.isCanceled() {
+		return ErrCanceled
+	}
+	cancel, stop := w.done()
+	defer stop()
+	select {
+	case <-time.After(d):
+		return nil
+	case <-cancel:
+		return ErrCanceled
+	}
+}
+
+// ForTCP waits until the TCP port at addr accepts connections and sends
+// data, such as the banner of an SSH server
+func (w *Waiter) ForTCP(addr string, timeout time.Duration) error {
+	return w.Until(fmt.Sprintf("%s to accept connections", addr), timeout, func() (bool, error) {
+		conn, err := net.DialTimeout("tcp", addr, maxInterval)
+		if err != nil {
+			log.Debugf("error connecting to %s: %s", addr, err)
+			return false, nil
+		}
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(maxInterval))
+		if _, err := conn.Read(make([]byte, 1)); err != nil {
+			log.Debugf("error reading from %s: %s", addr, err)
+			return false, nil
+		}
+		return true, nil
+	})
+}
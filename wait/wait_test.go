@@ -0,0 +1,141 @@
+package wait
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUntil(t *testing.T) {
+	calls := 0
+	err := Until("the third call", time.Minute, func() (bool, error) {
+		calls++
+		return calls == 3, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestUntilError(t *testing.T) {
+	expected := errors.New("provider error")
+	err := Until("an error", time.Minute, func() (bool, error) {
+		return false, expected
+	})
+	if err != expected {
+		t.Fatalf("expected %v, got %v", expected, err)
+	}
+}
+
+func TestUntilTimeout(t *testing.T) {
+	err := Until("nothing", 100*time.Millisecond, func() (bool, error) {
+		return false, nil
+	})
+	if _, ok := err.(*TimeoutError); !ok {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
+
+func TestUntilDeadline(t *testing.T) {
+	w := New(nil, 100*time.Millisecond)
+
+	err := w.Until("nothing", time.Minute, func() (bool, error) {
+		return false, nil
+	})
+	if _, ok := err.(*TimeoutError); !ok {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	// the deadline of the parent applies to the waits of its children
+	err = New(w, time.Minute).Until("nothing", time.Minute, func() (bool, error) {
+		return false, nil
+	})
+	if _, ok := err.(*TimeoutError); !ok {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	w := New(nil, 0)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		w.Cancel()
+	}()
+
+	err := w.Until("nothing", time.Minute, func() (bool, error) {
+		return false, nil
+	})
+	if err != ErrCanceled {
+		t.Fatalf("expected ErrCanceled, got %v", err)
+	}
+
+	// later waits are canceled too
+	if err := w.Sleep(time.Minute); err != ErrCanceled {
+		t.Fatalf("expected ErrCanceled, got %v", err)
+	}
+}
+
+func TestCancelParent(t *testing.T) {
+	parent := New(nil, 0)
+	child := New(parent, 0)
+	other := New(parent, 0)
+
+	// canceling an operation leaves the others alone
+	child.Cancel()
+	if err := child.Sleep(time.Minute); err != ErrCanceled {
+		t.Fatalf("expected ErrCanceled, got %v", err)
+	}
+	if err := other.Sleep(time.Millisecond); err != nil {
+		t.Fatalf("expected the sibling not to be canceled, got %v", err)
+	}
+	if err := parent.Sleep(time.Millisecond); err != nil {
+		t.Fatalf("expected the parent not to be canceled, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		parent.Cancel()
+	}()
+	if err := other.Sleep(time.Minute); err != ErrCanceled {
+		t.Fatalf("expected the running wait of the child to be canceled, got %v", err)
+	}
+
+	// the process waits are not canceled by other Waiters
+	if err := Sleep(time.Millisecond); err != nil {
+		t.Fatalf("expected the process waits not to be canceled, got %v", err)
+	}
+}
+
+func TestForTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("SSH-2.0-test\r\n"))
+			conn.Close()
+		}
+	}()
+
+	if err := ForTCP(listener.Addr().String(), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := listener.Addr().String()
+	listener.Close()
+	if err := ForTCP(addr, time.Second); err == nil {
+		t.Fatal("expected a timeout for a closed port")
+	}
+}